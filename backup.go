@@ -0,0 +1,435 @@
+package bbolt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"sync"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// incrementMagic marks the beginning of an incremental backup stream.
+const incrementMagic uint32 = 0xED0CDAEE
+
+// incrementVersion is the version of the incremental backup stream format.
+const incrementVersion uint32 = 1
+
+// incrementHeaderSize is the size of the header at the beginning of an
+// incremental backup stream:
+//
+//	magic     uint32
+//	version   uint32
+//	pageSize  uint32
+//	reserved  uint32
+//	since     uint64  txid the increment applies on top of
+//	txid      uint64  txid of the database after the increment is applied
+//	pgid      uint64  high water mark after the increment is applied
+//	runs      uint64  number of page runs that follow the meta pages
+const incrementHeaderSize = 48
+
+// incrementRunHeaderSize is the size of the header of each page run:
+//
+//	pgid      uint64  id of the first page of the run
+//	count     uint64  number of pages in the run
+const incrementRunHeaderSize = 16
+
+// pageRun is a contiguous range of pages.
+type pageRun struct {
+	id    common.Pgid
+	count uint64
+}
+
+// maxPageWrites is the number of pages whose last write is tracked for
+// incremental backups. Once more pages have been written, the older half of
+// the writes is forgotten.
+const maxPageWrites = 1 << 20
+
+// pageWrites remembers which transaction last wrote each page since the
+// database was opened, for up to limit pages, in databases whose pages do not
+// store it. Pages that are not tracked have not been written since base.
+type pageWrites struct {
+	mu    sync.Mutex
+	base  common.Txid
+	limit int
+	txids map[common.Pgid]common.Txid
+}
+
+// reset starts tracking from the given transaction.
+func (pw *pageWrites) reset(txid common.Txid) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.base = txid
+	pw.limit = maxPageWrites
+	pw.txids = make(map[common.Pgid]common.Txid)
+}
+
+// record marks the given pages, including their overflow pages, as written
+// by txid, if page writes are tracked.
+func (pw *pageWrites) record(txid common.Txid, pages common.Pages) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.txids == nil {
+		return
+	}
+	for _, p := range pages {
+		for i := common.Pgid(0); i <= common.Pgid(p.Overflow()); i++ {
+			pw.txids[p.Id()+i] = txid
+		}
+	}
+	if len(pw.txids) > pw.limit {
+		pw.forget()
+	}
+}
+
+// forget stops tracking the pages last written by the older half of the
+// writes, and moves base past them.
+func (pw *pageWrites) forget() {
+	txids := make([]common.Txid, 0, len(pw.txids))
+	for _, txid := range pw.txids {
+		txids = append(txids, txid)
+	}
+	sort.Slice(txids, func(i, j int) bool { return txids[i] < txids[j] })
+	base := txids[len(txids)/2]
+	for id, txid := range pw.txids {
+		if txid <= base {
+			delete(pw.txids, id)
+		}
+	}
+	pw.base = base
+}
+
+// changedSince returns the runs of pages below the high water mark that may
+// have been written after the given transaction. Every data page is returned
+// if the writes were not tracked for that long.
+func (pw *pageWrites) changedSince(since common.Txid, hwm common.Pgid) []pageRun {
+	if hwm <= 2 {
+		return nil
+	}
+
+	pw.mu.Lock()
+	if since < pw.base {
+		pw.mu.Unlock()
+		return []pageRun{{id: 2, count: uint64(hwm - 2)}}
+	}
+	var ids common.Pgids
+	for id, txid := range pw.txids {
+		if txid > since && id >= 2 && id < hwm {
+			ids = append(ids, id)
+		}
+	}
+	pw.mu.Unlock()
+
+	sort.Sort(ids)
+	return pageRuns(ids)
+}
+
+// writtenSince returns the runs of pages reachable by the transaction which
+// were written after the given transaction, according to the transaction ids
+// stored in the pages. Since a page is only written along with its ancestors,
+// the children of a page written before since are not visited.
+func (tx *Tx) writtenSince(since common.Txid) ([]pageRun, error) {
+	var ids common.Pgids
+	visit := func(id common.Pgid) (*common.Page, error) {
+		p, err := tx.checkedPage(id)
+		if err != nil {
+			return nil, err
+		}
+		// The transaction id is stored outside of the encrypted body, so it is
+		// read from the mapped page.
+		if tx.view().page(id, tx.db.pageSize).Txid(tx.db.pageSize) <= since {
+			return nil, nil
+		}
+		for i := common.Pgid(0); i <= common.Pgid(p.Overflow()); i++ {
+			ids = append(ids, id+i)
+		}
+		return p, nil
+	}
+
+	if tx.meta.Freelist() != common.PgidNoFreelist {
+		if _, err := visit(tx.meta.Freelist()); err != nil {
+			return nil, err
+		}
+	}
+	var walk func(id common.Pgid) error
+	walk = func(id common.Pgid) error {
+		p, err := visit(id)
+		if err != nil || p == nil {
+			return err
+		}
+		switch {
+		case p.IsBranchPage():
+			for i := range p.BranchPageElements() {
+				if err := walk(p.BranchPageElement(uint16(i)).Pgid()); err != nil {
+					return err
+				}
+			}
+		case p.IsLeafPage():
+			for i := range p.LeafPageElements() {
+				// Inline buckets do not have pages of their own.
+				if b := p.LeafPageElement(uint16(i)).Bucket(); b != nil && b.RootPage() != 0 {
+					if err := walk(b.RootPage()); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(tx.meta.RootBucket().RootPage()); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(ids)
+	return pageRuns(ids), nil
+}
+
+// pageRuns returns the runs of contiguous pages of the sorted page ids.
+func pageRuns(ids common.Pgids) []pageRun {
+	var runs []pageRun
+	for _, id := range ids {
+		if n := len(runs); n > 0 && runs[n-1].id+common.Pgid(runs[n-1].count) == id {
+			runs[n-1].count++
+			continue
+		}
+		runs = append(runs, pageRun{id: id, count: 1})
+	}
+	return runs
+}
+
+// WriteIncrementalTo writes the pages that changed after the transaction
+// sinceTxid to a writer. The increment can be applied with ApplyIncrement to
+// a copy of the database at sinceTxid, such as one produced by WriteTo or by
+// applying earlier increments, to bring it to the state seen by this
+// transaction.
+//
+// In databases of format version 4, every page stores the id of the
+// transaction which wrote it, so the increment only contains the pages which
+// changed, whichever process takes it. In other databases, page writes are
+// tracked in memory from the time the database is opened, for a bounded number
+// of pages. If sinceTxid is older than the writes which are still tracked, the
+// increment contains every page of the database.
+func (tx *Tx) WriteIncrementalTo(w io.Writer, sinceTxid int) (n int64, err error) {
+	if tx.db == nil {
+		return 0, common.ErrTxClosed
+	}
+
+	// Attempt to open reader with WriteFlag
+	f, closeReader, err := tx.db.openReader(tx.WriteFlag)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if cerr := closeReader(); err == nil {
+			err = cerr
+		}
+	}()
+
+	var runs []pageRun
+	if tx.db.pageTxids {
+		if runs, err = tx.writtenSince(common.Txid(sinceTxid)); err != nil {
+			return 0, err
+		}
+	} else {
+		runs = tx.db.writes.changedSince(common.Txid(sinceTxid), tx.meta.Pgid())
+	}
+
+	h := fnv.New64a()
+	cw := &countWriter{w: io.MultiWriter(w, h)}
+
+	// Write the header.
+	var hdr [incrementHeaderSize]byte
+	binary.LittleEndian.PutUint32(hdr[0:], incrementMagic)
+	binary.LittleEndian.PutUint32(hdr[4:], incrementVersion)
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(tx.db.pageSize))
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(sinceTxid))
+	binary.LittleEndian.PutUint64(hdr[24:], uint64(tx.meta.Txid()))
+	binary.LittleEndian.PutUint64(hdr[32:], uint64(tx.meta.Pgid()))
+	binary.LittleEndian.PutUint64(hdr[40:], uint64(len(runs)))
+	if _, err := cw.Write(hdr[:]); err != nil {
+		return cw.n, fmt.Errorf("header copy: %s", err)
+	}
+
+	// Write both meta pages the same way WriteTo does.
+	if _, err := tx.writeMetaPages(cw); err != nil {
+		return cw.n, err
+	}
+
+	// Copy the changed pages.
+	for _, run := range runs {
+		var rhdr [incrementRunHeaderSize]byte
+		binary.LittleEndian.PutUint64(rhdr[0:], uint64(run.id))
+		binary.LittleEndian.PutUint64(rhdr[8:], run.count)
+		if _, err := cw.Write(rhdr[:]); err != nil {
+			return cw.n, fmt.Errorf("page %d copy: %s", run.id, err)
+		}
+
+		offset := int64(run.id) * int64(tx.db.pageSize)
+		size := int64(run.count) * int64(tx.db.pageSize)
+		if _, err := io.CopyN(cw, io.NewSectionReader(f, offset, size), size); err != nil {
+			return cw.n, fmt.Errorf("page %d copy: %s", run.id, err)
+		}
+	}
+
+	// Finish with the checksum of everything written so far.
+	var sum [8]byte
+	binary.LittleEndian.PutUint64(sum[:], h.Sum64())
+	nn, err := w.Write(sum[:])
+	n = cw.n + int64(nn)
+	if err != nil {
+		return n, fmt.Errorf("checksum copy: %s", err)
+	}
+
+	return n, nil
+}
+
+// ApplyIncrement applies an increment written by Tx.WriteIncrementalTo to the
+// database file at path. The file must contain the database exactly as of the
+// transaction the increment was taken since, and it must not be opened while
+// the increment is applied.
+//
+// Pages are written in place, so a failed call can leave the file in an
+// inconsistent state. Apply increments to a copy of the base backup.
+func ApplyIncrement(path string, r io.Reader) (err error) {
+	h := fnv.New64a()
+	tr := io.TeeReader(r, h)
+
+	// Read and validate the header.
+	var hdr [incrementHeaderSize]byte
+	if _, err := io.ReadFull(tr, hdr[:]); err != nil {
+		return fmt.Errorf("read header: %w", err)
+	}
+	if binary.LittleEndian.Uint32(hdr[0:]) != incrementMagic {
+		return common.ErrInvalidIncrement
+	} else if binary.LittleEndian.Uint32(hdr[4:]) != incrementVersion {
+		return common.ErrVersionMismatch
+	}
+	pageSize := int(binary.LittleEndian.Uint32(hdr[8:]))
+	since := common.Txid(binary.LittleEndian.Uint64(hdr[16:]))
+	txid := common.Txid(binary.LittleEndian.Uint64(hdr[24:]))
+	hwm := common.Pgid(binary.LittleEndian.Uint64(hdr[32:]))
+	runs := binary.LittleEndian.Uint64(hdr[40:])
+	if pageSize < 1024 || hwm < 2 {
+		return common.ErrInvalidIncrement
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	// The increment only applies on top of the transaction it was taken since.
+	base, err := readFileMeta(f, pageSize)
+	if err != nil {
+		return err
+	} else if base.PageSize() != uint32(pageSize) || base.Txid() != since {
+		return common.ErrIncrementMismatch
+	}
+
+	// Read the new meta pages. They are written last, once all pages are in place.
+	metas := make([]byte, 2*pageSize)
+	if _, err := io.ReadFull(tr, metas); err != nil {
+		return fmt.Errorf("read meta pages: %w", err)
+	}
+	for i := 0; i < 2; i++ {
+		m := common.LoadPageMeta(metas[i*pageSize:])
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("meta %d: %w", i, err)
+		} else if m.Pgid() != hwm || (m.Txid() != txid && m.Txid() != txid-1) {
+			return common.ErrInvalidIncrement
+		}
+	}
+
+	// Copy the pages into place.
+	buf := make([]byte, 64*pageSize)
+	for i := uint64(0); i < runs; i++ {
+		var rhdr [incrementRunHeaderSize]byte
+		if _, err := io.ReadFull(tr, rhdr[:]); err != nil {
+			return fmt.Errorf("read page run: %w", err)
+		}
+		id := common.Pgid(binary.LittleEndian.Uint64(rhdr[0:]))
+		count := binary.LittleEndian.Uint64(rhdr[8:])
+		if id < 2 || count == 0 || id+common.Pgid(count) > hwm {
+			return common.ErrInvalidIncrement
+		}
+
+		offset := int64(id) * int64(pageSize)
+		rem := int64(count) * int64(pageSize)
+		for rem > 0 {
+			chunk := buf
+			if int64(len(chunk)) > rem {
+				chunk = chunk[:rem]
+			}
+			if _, err := io.ReadFull(tr, chunk); err != nil {
+				return fmt.Errorf("read page %d: %w", id, err)
+			}
+			if _, err := f.WriteAt(chunk, offset); err != nil {
+				return err
+			}
+			offset += int64(len(chunk))
+			rem -= int64(len(chunk))
+		}
+	}
+
+	// Verify the checksum before making the new state visible.
+	var sum [8]byte
+	if _, err := io.ReadFull(r, sum[:]); err != nil {
+		return fmt.Errorf("read checksum: %w", err)
+	} else if binary.LittleEndian.Uint64(sum[:]) != h.Sum64() {
+		return common.ErrChecksum
+	}
+
+	if err := f.Truncate(int64(hwm) * int64(pageSize)); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(metas, 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readFileMeta returns the newest valid meta page of a database file.
+func readFileMeta(f *os.File, pageSize int) (*common.Meta, error) {
+	buf := make([]byte, 2*pageSize)
+	if _, err := f.ReadAt(buf, 0); err != nil {
+		return nil, err
+	}
+
+	var meta *common.Meta
+	for i := 0; i < 2; i++ {
+		m := common.LoadPageMeta(buf[i*pageSize:])
+		if m.Validate() != nil {
+			continue
+		}
+		if meta == nil || m.Txid() > meta.Txid() {
+			meta = m
+		}
+	}
+	if meta == nil {
+		return nil, common.ErrInvalid
+	}
+	return meta, nil
+}
+
+// countWriter counts the bytes written to the underlying writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,225 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that a full backup followed by increments restores the latest state.
+func TestTx_WriteIncrementalTo(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	dir := t.TempDir()
+
+	fill := func(from, to int) {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			for i := from; i < to; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("%08d", i)), bytes.Repeat([]byte{byte(i)}, 100)); err != nil {
+					return err
+				}
+			}
+			return b.Delete([]byte(fmt.Sprintf("%08d", from/2)))
+		})
+		require.NoError(t, err)
+	}
+
+	// Take a full backup.
+	fill(0, 1000)
+	base := filepath.Join(dir, "base")
+	var txid int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		txid = tx.ID()
+		return tx.CopyFile(base, 0600)
+	}))
+
+	// Take two increments, each since the previous backup.
+	var increments []string
+	for i := 1; i <= 2; i++ {
+		fill(i*1000, i*1000+500)
+		path := filepath.Join(dir, fmt.Sprintf("inc%d", i))
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		require.NoError(t, db.View(func(tx *bolt.Tx) error {
+			_, err := tx.WriteIncrementalTo(f, txid)
+			txid = tx.ID()
+			return err
+		}))
+		require.NoError(t, f.Close())
+		increments = append(increments, path)
+	}
+
+	// The increments only hold a fraction of the database.
+	baseInfo, err := os.Stat(base)
+	require.NoError(t, err)
+	incInfo, err := os.Stat(increments[1])
+	require.NoError(t, err)
+	require.Less(t, incInfo.Size(), baseInfo.Size())
+
+	for _, path := range increments {
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		require.NoError(t, bolt.ApplyIncrement(base, f))
+		require.NoError(t, f.Close())
+	}
+
+	restored := btesting.MustOpenDBWithOption(t, base, nil)
+	require.Equal(t, dumpBucket(t, db.DB, []byte("widgets")), dumpBucket(t, restored.DB, []byte("widgets")))
+	require.NoError(t, restored.View(func(tx *bolt.Tx) error {
+		require.Equal(t, txid, tx.ID())
+		return nil
+	}))
+}
+
+// Ensure that an increment taken since a transaction older than the open
+// database still restores correctly.
+func TestTx_WriteIncrementalTo_Reopen(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("widgets"), 2, 100,
+		func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, key)) },
+		func(tx int, key int) []byte { return make([]byte, 50) },
+	))
+
+	base := filepath.Join(t.TempDir(), "base")
+	var txid int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		txid = tx.ID()
+		return tx.CopyFile(base, 0600)
+	}))
+
+	// Reopening the database forgets which pages were written before.
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 100,
+		func(tx int, key int) []byte { return []byte(fmt.Sprintf("x%04d", key)) },
+		func(tx int, key int) []byte { return []byte("value") },
+	))
+	db.MustClose()
+	db.MustReopen()
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 100,
+		func(tx int, key int) []byte { return []byte(fmt.Sprintf("y%04d", key)) },
+		func(tx int, key int) []byte { return []byte("value") },
+	))
+
+	var buf bytes.Buffer
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		n, err := tx.WriteIncrementalTo(&buf, txid)
+		require.Equal(t, int64(buf.Len()), n)
+		require.Greater(t, n, tx.Size())
+		return err
+	}))
+	require.NoError(t, bolt.ApplyIncrement(base, &buf))
+
+	restored := btesting.MustOpenDBWithOption(t, base, nil)
+	require.Equal(t, dumpBucket(t, db.DB, []byte("widgets")), dumpBucket(t, restored.DB, []byte("widgets")))
+}
+
+// Ensure that the increments of a database of format version 4 only hold the
+// changed pages after the database is reopened, and restore its latest state.
+func TestTx_WriteIncrementalTo_PageTxids(t *testing.T) {
+	for _, opts := range []*bolt.Options{
+		{FormatVersion: common.VersionFeatures},
+		{FormatVersion: common.VersionFeatures, Encryption: testKey},
+	} {
+		t.Run(fmt.Sprintf("encrypted-%t", opts.Encryption != nil), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, opts)
+			require.NoError(t, db.Fill([]byte("widgets"), 10, 1000,
+				func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, key)) },
+				func(tx int, key int) []byte { return make([]byte, 50) },
+			))
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.Bucket([]byte("widgets")).CreateBucket([]byte("nested"))
+				if err != nil {
+					return err
+				}
+				return b.Put([]byte("large"), make([]byte, 3*db.Info().PageSize))
+			}))
+
+			base := filepath.Join(t.TempDir(), "base")
+			var txid int
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				txid = tx.ID()
+				return tx.CopyFile(base, 0600)
+			}))
+
+			// Change a key of the top-level bucket and of the nested bucket
+			// after reopening the database.
+			db.MustClose()
+			db.MustReopen()
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				if err := b.Put([]byte("00050000"), []byte("changed")); err != nil {
+					return err
+				}
+				return b.Bucket([]byte("nested")).Put([]byte("large"), bytes.Repeat([]byte{1}, 3*db.Info().PageSize))
+			}))
+
+			var buf bytes.Buffer
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				n, err := tx.WriteIncrementalTo(&buf, txid)
+				require.Less(t, n, tx.Size()/4)
+				return err
+			}))
+			require.NoError(t, bolt.ApplyIncrement(base, &buf))
+
+			restored := btesting.MustOpenDBWithOption(t, base, &bolt.Options{Encryption: opts.Encryption})
+			require.Equal(t, dumpBucket(t, db.DB, []byte("widgets")), dumpBucket(t, restored.DB, []byte("widgets")))
+			require.NoError(t, restored.View(func(tx *bolt.Tx) error {
+				require.Equal(t, bytes.Repeat([]byte{1}, 3*db.Info().PageSize), tx.Bucket([]byte("widgets")).Bucket([]byte("nested")).Get([]byte("large")))
+				return nil
+			}))
+			restored.MustCheck()
+		})
+	}
+}
+
+// Ensure that an increment is rejected by a base at a different transaction.
+func TestApplyIncrement_Mismatch(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	base := filepath.Join(t.TempDir(), "base")
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(base, 0600)
+	}))
+
+	var buf bytes.Buffer
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}))
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteIncrementalTo(&buf, tx.ID())
+		return err
+	}))
+	require.ErrorIs(t, bolt.ApplyIncrement(base, bytes.NewReader(buf.Bytes())), common.ErrIncrementMismatch)
+
+	// A corrupted increment fails the checksum.
+	var inc bytes.Buffer
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteIncrementalTo(&inc, tx.ID()-1)
+		return err
+	}))
+	data := inc.Bytes()
+	data[len(data)-20] ^= 0xff
+	require.ErrorIs(t, bolt.ApplyIncrement(base, bytes.NewReader(data)), common.ErrChecksum)
+}
+
+// dumpBucket returns all key/value pairs of a top level bucket.
+func dumpBucket(t testing.TB, db *bolt.DB, name []byte) map[string]string {
+	m := make(map[string]string)
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(name).ForEach(func(k, v []byte) error {
+			m[string(k)] = string(v)
+			return nil
+		})
+	}))
+	return m
+}
@@ -1,10 +1,48 @@
 package bbolt
 
 import (
+	"io"
 	"syscall"
+
+	"golang.org/x/sys/unix"
 )
 
+// maxIovecs is the largest number of buffers written by a call to pwritev,
+// which is IOV_MAX on Linux.
+const maxIovecs = 1024
+
 // fdatasync flushes written data to a file descriptor.
-func fdatasync(db *DB) error {
-	return syscall.Fdatasync(int(db.file.Fd()))
+func fdatasync(s *fileStorage) error {
+	return syscall.Fdatasync(int(s.file.Fd()))
+}
+
+// writevAt writes the buffers to the file with pwritev, starting at offset off.
+func (s *fileStorage) writevAt(bufs [][]byte, off int64) (calls int, err error) {
+	for len(bufs) > 0 {
+		batch := bufs
+		if len(batch) > maxIovecs {
+			batch = batch[:maxIovecs]
+		}
+		n, err := unix.Pwritev(int(s.file.Fd()), batch, off)
+		calls++
+		if err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return calls, err
+		} else if n == 0 {
+			return calls, io.ErrShortWrite
+		}
+
+		// Skip what was written, which can end in the middle of a buffer.
+		off += int64(n)
+		for n > 0 {
+			if n < len(bufs[0]) {
+				bufs[0] = bufs[0][n:]
+				break
+			}
+			n -= len(bufs[0])
+			bufs = bufs[1:]
+		}
+	}
+	return calls, nil
 }
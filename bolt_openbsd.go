@@ -4,13 +4,15 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-func msync(db *DB) error {
-	return unix.Msync(db.data[:db.datasz], unix.MS_INVALIDATE)
+func msync(s *fileStorage) error {
+	return unix.Msync(s.data, unix.MS_INVALIDATE)
 }
 
-func fdatasync(db *DB) error {
-	if db.data != nil {
-		return msync(db)
+func fdatasync(s *fileStorage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data != nil {
+		return msync(s)
 	}
-	return db.file.Sync()
+	return s.file.Sync()
 }
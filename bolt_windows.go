@@ -1,6 +1,7 @@
 package bbolt
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"syscall"
@@ -13,12 +14,13 @@ import (
 )
 
 // fdatasync flushes written data to a file descriptor.
-func fdatasync(db *DB) error {
-	return db.file.Sync()
+func fdatasync(s *fileStorage) error {
+	return s.file.Sync()
 }
 
-// flock acquires an advisory lock on a file descriptor.
-func flock(db *DB, exclusive bool, timeout time.Duration) error {
+// flock acquires an advisory lock on a file descriptor. It gives up when the
+// timeout expires or when ctx is done.
+func flock(ctx context.Context, s *fileStorage, exclusive bool, timeout time.Duration) error {
 	var t time.Time
 	if timeout != 0 {
 		t = time.Now()
@@ -31,7 +33,7 @@ func flock(db *DB, exclusive bool, timeout time.Duration) error {
 		// Fix for https://github.com/etcd-io/bbolt/issues/121. Use byte-range
 		// -1..0 as the lock on the database file.
 		var m1 uint32 = (1 << 32) - 1 // -1 in a uint32
-		err := windows.LockFileEx(windows.Handle(db.file.Fd()), flags, 0, 1, 0, &windows.Overlapped{
+		err := windows.LockFileEx(windows.Handle(s.file.Fd()), flags, 0, 1, 0, &windows.Overlapped{
 			Offset:     m1,
 			OffsetHigh: m1,
 		})
@@ -48,14 +50,18 @@ func flock(db *DB, exclusive bool, timeout time.Duration) error {
 		}
 
 		// Wait for a bit and try again.
-		time.Sleep(flockRetryTimeout)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(flockRetryTimeout):
+		}
 	}
 }
 
 // funlock releases an advisory lock on a file descriptor.
-func funlock(db *DB) error {
+func funlock(s *fileStorage) error {
 	var m1 uint32 = (1 << 32) - 1 // -1 in a uint32
-	return windows.UnlockFileEx(windows.Handle(db.file.Fd()), 0, 1, 0, &windows.Overlapped{
+	return windows.UnlockFileEx(windows.Handle(s.file.Fd()), 0, 1, 0, &windows.Overlapped{
 		Offset:     m1,
 		OffsetHigh: m1,
 	})
@@ -63,22 +69,22 @@ func funlock(db *DB) error {
 
 // mmap memory maps a DB's data file.
 // Based on: https://github.com/edsrzf/mmap-go
-func mmap(db *DB, sz int) error {
+func mmap(s *fileStorage, sz int, flags int) ([]byte, error) {
 	var sizelo, sizehi uint32
 
-	if !db.readOnly {
+	if !s.readOnly {
 		// Truncate the database to the size of the mmap.
-		if err := db.file.Truncate(int64(sz)); err != nil {
-			return fmt.Errorf("truncate: %s", err)
+		if err := s.file.Truncate(int64(sz)); err != nil {
+			return nil, fmt.Errorf("truncate: %s", err)
 		}
 		sizehi = uint32(sz >> 32)
 		sizelo = uint32(sz) & 0xffffffff
 	}
 
 	// Open a file mapping handle.
-	h, errno := syscall.CreateFileMapping(syscall.Handle(db.file.Fd()), nil, syscall.PAGE_READONLY, sizehi, sizelo, nil)
+	h, errno := syscall.CreateFileMapping(syscall.Handle(s.file.Fd()), nil, syscall.PAGE_READONLY, sizehi, sizelo, nil)
 	if h == 0 {
-		return os.NewSyscallError("CreateFileMapping", errno)
+		return nil, os.NewSyscallError("CreateFileMapping", errno)
 	}
 
 	// Create the memory map.
@@ -86,34 +92,24 @@ func mmap(db *DB, sz int) error {
 	if addr == 0 {
 		// Do our best and report error returned from MapViewOfFile.
 		_ = syscall.CloseHandle(h)
-		return os.NewSyscallError("MapViewOfFile", errno)
+		return nil, os.NewSyscallError("MapViewOfFile", errno)
 	}
 
 	// Close mapping handle.
 	if err := syscall.CloseHandle(syscall.Handle(h)); err != nil {
-		return os.NewSyscallError("CloseHandle", err)
+		return nil, os.NewSyscallError("CloseHandle", err)
 	}
 
-	// Convert to a byte array.
-	db.data = (*[maxMapSize]byte)(unsafe.Pointer(addr))
-	db.datasz = sz
-
-	return nil
+	// Convert to a byte slice.
+	return unsafe.Slice((*byte)(unsafe.Pointer(addr)), sz), nil
 }
 
 // munmap unmaps a pointer from a file.
 // Based on: https://github.com/edsrzf/mmap-go
-func munmap(db *DB) error {
-	if db.data == nil {
-		return nil
-	}
-
-	addr := (uintptr)(unsafe.Pointer(&db.data[0]))
-	var err1 error
+func munmap(b []byte) error {
+	addr := (uintptr)(unsafe.Pointer(&b[0]))
 	if err := syscall.UnmapViewOfFile(addr); err != nil {
-		err1 = os.NewSyscallError("UnmapViewOfFile", err)
+		return os.NewSyscallError("UnmapViewOfFile", err)
 	}
-	db.data = nil
-	db.datasz = 0
-	return err1
+	return nil
 }
@@ -4,6 +4,6 @@
 package bbolt
 
 // fdatasync flushes written data to a file descriptor.
-func fdatasync(db *DB) error {
-	return db.file.Sync()
+func fdatasync(s *fileStorage) error {
+	return s.file.Sync()
 }
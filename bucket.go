@@ -2,6 +2,7 @@ package bbolt
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"unsafe"
 
@@ -33,6 +34,11 @@ type Bucket struct {
 	page     *common.Page          // inline page reference
 	rootNode *node                 // materialized node for the root page.
 	nodes    map[common.Pgid]*node // node cache
+	parent   *Bucket               // parent bucket in a writable transaction
+	name     []byte                // name of the bucket in its parent
+	ext      common.BucketExt      // options stored in the bucket header
+	codec    Codec                 // codec compressing the values, if any
+	cmp      Comparator            // comparator ordering the keys, if any
 
 	// Sets the threshold for filling nodes when they split. By default,
 	// the bucket will fill to 50% but it can be useful to increase this
@@ -42,6 +48,28 @@ type Bucket struct {
 	FillPercent float64
 }
 
+// BucketOptions represents the options of a bucket. They are set when the
+// bucket is created and stored in its header.
+//
+// Writing a bucket with options, or a compressed value, raises the format
+// version of the database to 4, which older versions of bbolt refuse to open.
+type BucketOptions struct {
+	// Codec compresses the values of the bucket. Values are compressed by
+	// Put and decompressed by Get and cursors, and are left uncompressed if
+	// they do not get smaller. Values are not compressed if Codec is nil.
+	Codec Codec
+
+	// Comparator orders the keys of the bucket, which are ordered by
+	// bytes.Compare if it is nil. Prefix scans assume the keys sharing a
+	// prefix to be adjacent, which not every comparator guarantees.
+	Comparator Comparator
+
+	// Counted keeps the number of keys and the total size of the values of
+	// the bucket in its header, so that Len and ValueSize do not need to
+	// read the whole bucket.
+	Counted bool
+}
+
 // newBucket returns a new bucket associated with a transaction.
 func newBucket(tx *Tx) Bucket {
 	var b = Bucket{tx: tx, FillPercent: DefaultFillPercent}
@@ -64,7 +92,7 @@ func (b *Bucket) Root() common.Pgid {
 
 // Writable returns whether the bucket is writable.
 func (b *Bucket) Writable() bool {
-	return b.tx.writable
+	return b.tx.writable && b.declared()
 }
 
 // Cursor creates a cursor associated with the bucket.
@@ -82,12 +110,29 @@ func (b *Bucket) Cursor() *Cursor {
 }
 
 // Bucket retrieves a nested bucket by name.
-// Returns nil if the bucket does not exist.
+// Returns nil if the bucket does not exist, or if it can not be opened because
+// its values are compressed by a codec, or its keys are ordered by a
+// comparator, which is not registered. Use LookupBucket to tell them apart.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) Bucket(name []byte) *Bucket {
+	child, _ := b.bucket(name)
+	return child
+}
+
+// LookupBucket is like Bucket, but returns an error wrapping ErrUnknownCodec
+// or ErrUnknownComparator if the bucket exists but its codec or comparator is
+// not registered. It returns a nil bucket and error if the bucket does not
+// exist.
+func (b *Bucket) LookupBucket(name []byte) (*Bucket, error) {
+	return b.bucket(name)
+}
+
+// bucket retrieves a nested bucket by name. Returns a nil bucket and error if
+// the bucket does not exist, or an error if it can not be opened.
+func (b *Bucket) bucket(name []byte) (*Bucket, error) {
 	if b.buckets != nil {
 		if child := b.buckets[string(name)]; child != nil {
-			return child
+			return child, nil
 		}
 	}
 
@@ -97,23 +142,47 @@ func (b *Bucket) Bucket(name []byte) *Bucket {
 
 	// Return nil if the key doesn't exist or it is not a bucket.
 	if !bytes.Equal(name, k) || (flags&common.BucketLeafFlag) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	// Otherwise create a bucket and cache it.
-	var child = b.openBucket(v)
+	child, err := b.openBucket(v, flags)
+	if err != nil {
+		return nil, err
+	} else if child.ext.Codec != 0 && child.codec == nil {
+		return nil, fmt.Errorf("%w: %d", common.ErrUnknownCodec, child.ext.Codec)
+	} else if child.ext.Comparator != "" && child.cmp == nil {
+		return nil, fmt.Errorf("%w: %q", common.ErrUnknownComparator, child.ext.Comparator)
+	}
 	if b.buckets != nil {
+		child.parent, child.name = b, cloneBytes(name)
 		b.buckets[string(name)] = child
 	}
 
-	return child
+	return child, nil
 }
 
 // Helper method that re-interprets a sub-bucket value
-// from a parent into a Bucket
-func (b *Bucket) openBucket(value []byte) *Bucket {
+// from a parent into a Bucket. The codec of the bucket is nil if it is not
+// registered.
+func (b *Bucket) openBucket(value []byte, flags uint32) (*Bucket, error) {
 	var child = newBucket(b.tx)
 
+	// Read the options following the bucket header.
+	headerSize := common.BucketHeaderSize
+	if flags&common.BucketExtLeafFlag != 0 {
+		ext, err := common.ReadBucketExt(value[headerSize:])
+		if err != nil {
+			return nil, err
+		}
+		child.ext = ext
+		child.codec = lookupCodec(ext.Codec)
+		if ext.Comparator != "" {
+			child.cmp = lookupComparator(ext.Comparator)
+		}
+		headerSize += ext.Size()
+	}
+
 	// Unaligned access requires a copy to be made.
 	const unalignedMask = unsafe.Alignof(struct {
 		common.InBucket
@@ -135,20 +204,28 @@ func (b *Bucket) openBucket(value []byte) *Bucket {
 
 	// Save a reference to the inline page if the bucket is inline.
 	if child.RootPage() == 0 {
-		child.page = (*common.Page)(unsafe.Pointer(&value[common.BucketHeaderSize]))
+		child.page = (*common.Page)(unsafe.Pointer(&value[headerSize]))
 	}
 
-	return &child
+	return &child, nil
 }
 
 // CreateBucket creates a new bucket at the given key and returns the new bucket.
 // Returns an error if the key already exists, if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
+	return b.CreateBucketWithOptions(key, nil)
+}
+
+// CreateBucketWithOptions creates a new bucket with the given options at the
+// given key and returns the new bucket. Default options are used if opts is nil.
+// Returns an error if the key already exists, if the bucket name is blank, or if the bucket name is too long.
+// The bucket instance is only valid for the lifetime of the transaction.
+func (b *Bucket) CreateBucketWithOptions(key []byte, opts *BucketOptions) (*Bucket, error) {
 	if b.tx.db == nil {
 		return nil, common.ErrTxClosed
-	} else if !b.tx.writable {
-		return nil, common.ErrTxNotWritable
+	} else if !b.childWritable(key) {
+		return nil, b.notWritable()
 	} else if len(key) == 0 {
 		return nil, common.ErrBucketNameRequired
 	}
@@ -171,27 +248,50 @@ func (b *Bucket) CreateBucket(key []byte) (*Bucket, error) {
 		rootNode:    &node{isLeaf: true},
 		FillPercent: DefaultFillPercent,
 	}
+	if opts != nil && opts.Codec != nil {
+		if lookupCodec(opts.Codec.ID()) == nil {
+			return nil, fmt.Errorf("%w: %d", common.ErrUnknownCodec, opts.Codec.ID())
+		}
+		bucket.ext.Codec = opts.Codec.ID()
+	}
+	if opts != nil && opts.Comparator != nil {
+		name := opts.Comparator.Name()
+		if len(name) == 0 || len(name) > 255 {
+			return nil, common.ErrInvalidComparator
+		} else if lookupComparator(name) == nil {
+			return nil, fmt.Errorf("%w: %q", common.ErrUnknownComparator, name)
+		}
+		bucket.ext.Comparator = name
+	}
+	if opts != nil {
+		bucket.ext.Counted = opts.Counted
+	}
 	var value = bucket.write()
 
 	// Insert into node.
 	key = cloneBytes(key)
-	c.node().put(key, key, value, 0, common.BucketLeafFlag)
+	c.node().put(key, key, value, 0, bucket.leafFlags())
 
 	// Since subbuckets are not allowed on inline buckets, we need to
 	// dereference the inline page, if it exists. This will cause the bucket
 	// to be treated as a regular, non-inline bucket for the rest of the tx.
 	b.page = nil
 
+	if b.tx.changes != nil {
+		b.recordChange(Change{Type: ChangeCreateBucket, Key: key})
+	}
+	b.watchKey(key, false, true)
+
 	return b.Bucket(key), nil
 }
 
 // CreateBucketIfNotExists creates a new bucket if it doesn't already exist and returns a reference to it.
-// Returns an error if the bucket name is blank, or if the bucket name is too long.
+// Returns an error if the bucket name is blank, if the bucket name is too long, or if the existing bucket can not be opened.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
 	child, err := b.CreateBucket(key)
 	if err == common.ErrBucketExists {
-		return b.Bucket(key), nil
+		return b.bucket(key)
 	} else if err != nil {
 		return nil, err
 	}
@@ -203,8 +303,8 @@ func (b *Bucket) CreateBucketIfNotExists(key []byte) (*Bucket, error) {
 func (b *Bucket) DeleteBucket(key []byte) error {
 	if b.tx.db == nil {
 		return common.ErrTxClosed
-	} else if !b.Writable() {
-		return common.ErrTxNotWritable
+	} else if !b.childWritable(key) {
+		return b.notWritable()
 	}
 
 	// Move cursor to correct position.
@@ -219,8 +319,11 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 	}
 
 	// Recursively delete all child buckets.
-	child := b.Bucket(key)
-	err := child.ForEachBucket(func(k []byte) error {
+	child, err := b.bucket(key)
+	if err != nil {
+		return err
+	}
+	err = child.ForEachBucket(func(k []byte) error {
 		if err := child.DeleteBucket(k); err != nil {
 			return fmt.Errorf("delete bucket: %s", err)
 		}
@@ -241,56 +344,224 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 	// Delete the node if we have a matching key.
 	c.node().del(key)
 
+	if b.tx.changes != nil {
+		b.recordChange(Change{Type: ChangeDeleteBucket, Key: cloneBytes(key)})
+	}
+	b.watchKey(key, true, false)
+	b.tx.watchResync(append(b.path(), cloneBytes(key)))
+
+	// Empty the indexes of the bucket and of the buckets nested in it.
+	if len(b.tx.indexes) > 0 {
+		return b.tx.rebuildIndexes(append(b.path(), key))
+	}
+
+	return nil
+}
+
+// moveBucket moves the nested bucket at key to the bucket dst under the name
+// newName. Only the bucket header, and the root page of an inline bucket, are
+// moved.
+func (b *Bucket) moveBucket(key []byte, dst *Bucket, newName []byte) error {
+	if !b.childWritable(key) || !dst.childWritable(newName) {
+		return b.notWritable()
+	}
+
+	// Move cursor to correct position.
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+
+	// Return an error if bucket doesn't exist or is not a bucket.
+	if !bytes.Equal(key, k) {
+		return common.ErrBucketNotFound
+	} else if (flags & common.BucketLeafFlag) == 0 {
+		return common.ErrIncompatibleValue
+	}
+
+	// Moving a bucket onto itself does nothing.
+	if dst == b && bytes.Equal(key, newName) {
+		return nil
+	}
+
+	// Return an error if the new name is taken.
+	dc := dst.Cursor()
+	if k, _, dflags := dc.seek(newName); bytes.Equal(newName, k) {
+		if (dflags & common.BucketLeafFlag) != 0 {
+			return common.ErrBucketExists
+		}
+		return common.ErrIncompatibleValue
+	}
+
+	child, err := b.bucket(key)
+	if err != nil {
+		return err
+	}
+
+	// Find the entries of the expiry index for the keys of the bucket, which
+	// hold the path of the bucket.
+	oldEntries, newEntries, err := b.tx.movedExpiryEntries(append(b.path(), key), append(dst.path(), newName))
+	if err != nil {
+		return err
+	} else if len(oldEntries) > 0 && b.tx.scope != nil {
+		// The expiry index is not declared by scoped transactions.
+		return common.ErrBucketNotDeclared
+	}
+
+	// Copy the value, which holds the root page of an inline bucket, as the
+	// node it points into is modified below.
+	value := cloneBytes(v)
+	if child.RootPage() == 0 && child.rootNode == nil {
+		child.page = (*common.Page)(unsafe.Pointer(&value[common.BucketHeaderSize+child.ext.Size()]))
+	}
+
+	// Remove the bucket from its parent.
+	delete(b.buckets, string(key))
+	c.node().del(key)
+
+	// Insert it into the new parent.
+	newName = cloneBytes(newName)
+	dc = dst.Cursor()
+	dc.seek(newName)
+	dc.node().put(newName, newName, value, 0, flags)
+	dst.page = nil
+	dst.buckets[string(newName)] = child
+	child.parent, child.name = dst, newName
+
+	if b.tx.changes != nil {
+		b.recordChange(Change{Type: ChangeMoveBucket, Key: cloneBytes(key), Target: child.path()})
+	}
+	b.watchKey(key, true, false)
+	dst.watchKey(newName, false, true)
+	b.tx.watchResync(append(b.path(), cloneBytes(key)))
+	b.tx.watchResync(child.path())
+
+	if err := b.tx.replaceExpiryEntries(oldEntries, newEntries); err != nil {
+		return err
+	}
+
+	// Rebuild the indexes of the buckets which left their old path, or
+	// arrived at their new one.
+	if len(b.tx.indexes) > 0 {
+		if err := b.tx.rebuildIndexes(append(b.path(), key)); err != nil {
+			return err
+		}
+		return b.tx.rebuildIndexes(child.path())
+	}
+
 	return nil
 }
 
 // Get retrieves the value for a key in the bucket.
 // Returns a nil value if the key does not exist or if the key is a nested bucket.
 // The returned value is only valid for the life of the transaction.
+// It panics with a CorruptionError if it reads a corrupted page, which the
+// managed transactions turn into an error; use Lookup to get the error in
+// manually managed transactions.
 func (b *Bucket) Get(key []byte) []byte {
-	k, v, flags := b.Cursor().seek(key)
+	v, err := b.Lookup(key)
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Lookup is like Get, but returns a CorruptionError instead of panicking when
+// it reads a corrupted page.
+func (b *Bucket) Lookup(key []byte) (value []byte, err error) {
+	defer recoverCorruption(&err)
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
 
 	// Return nil if this is a bucket.
 	if (flags & common.BucketLeafFlag) != 0 {
-		return nil
+		return nil, nil
 	}
 
 	// If our target node isn't the same key as what's passed in then return nil.
-	if !bytes.Equal(key, k) {
-		return nil
+	if !bytes.Equal(key, k) || c.hidden(k, v, flags) {
+		return nil, nil
 	}
-	return v
+	return c.value(v, flags), nil
 }
 
 // Put sets the value for a key in the bucket.
 // If the key exist then its previous value will be overwritten.
 // Supplied value must remain valid for the life of the transaction.
+// The value is compressed if the bucket has a codec.
 // Returns an error if the bucket was created from a read-only transaction, if the key is blank, if the key is too large, or if the value is too large.
 func (b *Bucket) Put(key []byte, value []byte) error {
+	return b.put(key, value, 0)
+}
+
+// put sets the value for a key in the bucket, which expires at the given time
+// in nanoseconds since the epoch, unless it is 0.
+func (b *Bucket) put(key []byte, value []byte, expires int64) error {
 	if b.tx.db == nil {
 		return common.ErrTxClosed
 	} else if !b.Writable() {
-		return common.ErrTxNotWritable
+		return b.notWritable()
 	} else if len(key) == 0 {
 		return common.ErrKeyRequired
 	} else if len(key) > MaxKeySize {
 		return common.ErrKeyTooLarge
 	} else if int64(len(value)) > MaxValueSize {
 		return common.ErrValueTooLarge
+	} else if expires != 0 && b.tx.scope != nil {
+		// The expiry index is not declared by scoped transactions.
+		return common.ErrBucketNotDeclared
+	} else if expires != 0 && !b.tx.db.reservedNames {
+		return common.ErrReservedNamesInUse
 	}
 
 	// Move cursor to correct position.
 	c := b.Cursor()
-	k, _, flags := c.seek(key)
+	k, v, flags := c.seek(key)
 
 	// Return an error if there is an existing key with a bucket value.
-	if bytes.Equal(key, k) && (flags&common.BucketLeafFlag) != 0 {
+	exists := bytes.Equal(key, k)
+	if exists && (flags&common.BucketLeafFlag) != 0 {
 		return common.ErrIncompatibleValue
 	}
 
+	// Move the key in the indexes of the bucket to its new value.
+	if defs := b.indexes(); len(defs) > 0 {
+		var old []byte
+		if exists {
+			old = c.value(v, flags)
+		}
+		if err := b.reindex(defs, key, old, exists, value); err != nil {
+			return err
+		}
+	}
+
+	// Compress the value if the bucket has a codec.
+	stored, flags := value, uint32(0)
+	if b.codec != nil {
+		var err error
+		if stored, flags, err = compressValue(b.codec, value); err != nil {
+			return err
+		}
+	}
+
+	// Prefix the value with its expiry time.
+	if expires != 0 {
+		stored = append(binary.BigEndian.AppendUint64(nil, uint64(expires)), stored...)
+		flags |= common.ExpiringLeafFlag
+	}
+
 	// Insert into node.
 	key = cloneBytes(key)
-	c.node().put(key, key, value, 0, 0)
+	c.node().put(key, key, stored, 0, flags)
+
+	if expires != 0 {
+		if err := b.indexExpiry(key, expires); err != nil {
+			return err
+		}
+	}
+
+	if b.tx.changes != nil {
+		b.recordChange(Change{Type: ChangePut, Key: key, Value: cloneBytes(value)})
+	}
+	b.watchKey(key, exists, true)
 
 	return nil
 }
@@ -302,12 +573,12 @@ func (b *Bucket) Delete(key []byte) error {
 	if b.tx.db == nil {
 		return common.ErrTxClosed
 	} else if !b.Writable() {
-		return common.ErrTxNotWritable
+		return b.notWritable()
 	}
 
 	// Move cursor to correct position.
 	c := b.Cursor()
-	k, _, flags := c.seek(key)
+	k, v, flags := c.seek(key)
 
 	// Return nil if the key doesn't exist.
 	if !bytes.Equal(key, k) {
@@ -319,12 +590,380 @@ func (b *Bucket) Delete(key []byte) error {
 		return common.ErrIncompatibleValue
 	}
 
+	// Remove the key from the indexes of the bucket.
+	if defs := b.indexes(); len(defs) > 0 {
+		if err := b.unindex(defs, key, c.value(v, flags)); err != nil {
+			return err
+		}
+	}
+
 	// Delete the node if we have a matching key.
 	c.node().del(key)
 
+	if b.tx.changes != nil {
+		b.recordChange(Change{Type: ChangeDelete, Key: cloneBytes(key)})
+	}
+	b.watchKey(key, true, false)
+
 	return nil
 }
 
+// DeleteRange removes all keys from start up to, but not including, end from
+// the bucket, and returns the number of keys and pages removed. A nil start
+// or end leaves that end of the range open. Nested buckets in the range are
+// deleted along with everything in them; each counts as a single key, while
+// all of their pages are included in the page count.
+//
+// Subtrees of the bucket which lie entirely inside the range are released
+// to the freelist without being read into memory, so that only the pages on
+// the boundaries of the range are rewritten.
+func (b *Bucket) DeleteRange(start, end []byte) (keys, pages int, err error) {
+	if b.tx.db == nil {
+		return 0, 0, common.ErrTxClosed
+	} else if !b.Writable() {
+		return 0, 0, b.notWritable()
+	} else if start != nil && end != nil && b.compare(start, end) >= 0 {
+		return 0, 0, nil
+	}
+
+	// Remove the keys in the range from the indexes of the bucket, including
+	// expired keys which are not swept yet.
+	if defs := b.indexes(); len(defs) > 0 {
+		c := b.Cursor()
+		k, v, flags := c.first()
+		if start != nil {
+			k, v, flags = c.seek(start)
+		}
+		for ; k != nil && (end == nil || b.compare(k, end) < 0); k, v, flags = c.next() {
+			if flags&common.BucketLeafFlag != 0 {
+				continue
+			}
+			if err := b.unindex(defs, k, c.value(v, flags)); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	root := b.node(b.RootPage(), nil)
+	keys, pages, err = b.deleteRange(root, start, end, nil, nil)
+	if err != nil {
+		return keys, pages, err
+	}
+
+	// Collapse a branch root which was left with a single child, and turn
+	// one left without children into an empty leaf.
+	for !root.isLeaf && len(root.inodes) == 1 {
+		child := root.childAt(0)
+		root.isLeaf = child.isLeaf
+		root.inodes = child.inodes[:]
+		root.children = child.children
+		for _, inode := range root.inodes {
+			if n, ok := b.nodes[inode.Pgid()]; ok {
+				n.parent = root
+			}
+		}
+		pages += b.releaseNode(child)
+	}
+	if !root.isLeaf && len(root.inodes) == 0 {
+		root.isLeaf = true
+		root.children = nil
+	}
+
+	if b.tx.changes != nil && keys > 0 {
+		c := Change{Type: ChangeDeleteRange}
+		if start != nil {
+			c.Key = cloneBytes(start)
+		}
+		if end != nil {
+			c.End = cloneBytes(end)
+		}
+		b.recordChange(c)
+	}
+	if keys > 0 {
+		b.tx.watchResync(b.path())
+	}
+
+	return keys, pages, nil
+}
+
+// deleteRange removes the keys in the range [start, end) from node n, whose
+// keys are known to lie in [lo, hi). Children of a branch which are entirely
+// in the range are dropped, and children overlapping it are read into nodes
+// and processed recursively.
+func (b *Bucket) deleteRange(n *node, start, end, lo, hi []byte) (keys, pages int, err error) {
+	var single []*node
+	inodes := n.inodes[:0]
+	for i, inode := range n.inodes {
+		if n.isLeaf {
+			if !b.keyInRange(inode.Key(), start, end) {
+				inodes = append(inodes, inode)
+				continue
+			}
+			if inode.Flags()&common.BucketLeafFlag != 0 {
+				p, err := b.dropBucket(inode.Key(), inode.Value(), inode.Flags())
+				if err != nil {
+					return keys, pages, err
+				}
+				pages += p
+			}
+			b.count(inode.Flags(), inode.Value(), -1)
+			keys++
+			continue
+		}
+
+		// Find the bounds of the keys of the child. Keys smaller than the
+		// first key of a branch may still be stored in its first child.
+		clo, chi := lo, hi
+		if i > 0 {
+			clo = inode.Key()
+		}
+		if i < len(n.inodes)-1 {
+			chi = n.inodes[i+1].Key()
+		}
+
+		switch {
+		case (end != nil && clo != nil && b.compare(clo, end) >= 0) ||
+			(start != nil && chi != nil && b.compare(chi, start) <= 0):
+			// The child is outside of the range.
+			inodes = append(inodes, inode)
+		case (start == nil || (clo != nil && b.compare(clo, start) >= 0)) &&
+			(end == nil || (chi != nil && b.compare(chi, end) <= 0)):
+			// The child is entirely inside of the range.
+			k, p, err := b.dropTree(inode.Pgid())
+			keys, pages = keys+k, pages+p
+			if err != nil {
+				return keys, pages, err
+			}
+		default:
+			child := n.childAt(i)
+			k, p, err := b.deleteRange(child, start, end, clo, chi)
+			keys, pages = keys+k, pages+p
+			if err != nil {
+				return keys, pages, err
+			}
+			if !child.isLeaf && len(child.inodes) == 0 {
+				pages += b.releaseNode(child)
+				continue
+			} else if !child.isLeaf && len(child.inodes) == 1 {
+				single = append(single, child)
+			}
+			inodes = append(inodes, inode)
+		}
+	}
+
+	if len(inodes) != len(n.inodes) {
+		n.inodes = inodes
+		n.unbalanced = true
+	}
+
+	// Branches other than the root must keep at least two children, so the
+	// ones left with a single child are merged into a sibling. A node left
+	// with a single child itself is merged by its parent.
+	if len(n.inodes) > 1 {
+		for _, child := range single {
+			if len(child.inodes) == 1 {
+				pages += b.mergeChild(n, child)
+			}
+		}
+	}
+	return keys, pages, nil
+}
+
+// mergeChild moves the only child of branch node child to a sibling of child,
+// and releases child. It returns the number of pages released.
+func (b *Bucket) mergeChild(n, child *node) int {
+	var index int
+	for index < len(n.inodes) && n.inodes[index].Pgid() != child.pgid {
+		index++
+	}
+	common.Assert(index < len(n.inodes) && len(n.inodes) > 1, "invalid merge of child %d", child.pgid)
+
+	inode := child.inodes[0]
+	var sibling *node
+	if index > 0 {
+		sibling = n.childAt(index - 1)
+		sibling.inodes = append(sibling.inodes, inode)
+	} else {
+		sibling = n.childAt(index + 1)
+		sibling.inodes = append(common.Inodes{inode}, sibling.inodes...)
+	}
+	sibling.unbalanced = true
+	n.inodes = append(n.inodes[:index], n.inodes[index+1:]...)
+	pages := b.releaseNode(child)
+
+	// The moved node may have been left with a single child too.
+	if moved, ok := b.nodes[inode.Pgid()]; ok {
+		moved.parent = sibling
+		sibling.children = append(sibling.children, moved)
+		if !moved.isLeaf && len(moved.inodes) == 1 {
+			pages += b.mergeChild(sibling, moved)
+		}
+	}
+	return pages
+}
+
+// releaseNode removes a node from the node cache and from the children of its
+// parent, and releases its page to the freelist. It returns the number of
+// pages released.
+func (b *Bucket) releaseNode(n *node) int {
+	if n.parent != nil {
+		n.parent.removeChild(n)
+	}
+	if n.pgid == 0 {
+		return 0
+	}
+	delete(b.nodes, n.pgid)
+	pages := int(b.tx.page(n.pgid).Overflow()) + 1
+	n.free()
+	return pages
+}
+
+// dropTree releases the pages of the subtree rooted at pgId, and of the nested
+// buckets stored in it, to the freelist. Nodes of the subtree are removed from
+// the node cache. It returns the number of keys and pages released.
+func (b *Bucket) dropTree(pgId common.Pgid) (keys, pages int, err error) {
+	var tx = b.tx
+	b._forEachPageNode(pgId, 0, func(p *common.Page, n *node, _ int) {
+		if err != nil {
+			return
+		}
+
+		if p != nil {
+			if p.IsLeafPage() {
+				for i := uint16(0); i < p.Count(); i++ {
+					elem := p.LeafPageElement(i)
+					if elem.Flags()&common.BucketLeafFlag != 0 {
+						var np int
+						if np, err = b.dropBucket(elem.Key(), elem.Value(), elem.Flags()); err != nil {
+							return
+						}
+						pages += np
+					}
+					b.count(elem.Flags(), elem.Value(), -1)
+				}
+				keys += int(p.Count())
+			}
+			// Inline pages are part of the value of the bucket.
+			if b.RootPage() != 0 {
+				pages += int(p.Overflow()) + 1
+				tx.free(p)
+			}
+			return
+		}
+
+		if n.isLeaf {
+			for _, inode := range n.inodes {
+				if inode.Flags()&common.BucketLeafFlag != 0 {
+					var np int
+					if np, err = b.dropBucket(inode.Key(), inode.Value(), inode.Flags()); err != nil {
+						return
+					}
+					pages += np
+				}
+				b.count(inode.Flags(), inode.Value(), -1)
+			}
+			keys += len(n.inodes)
+		}
+		pages += b.releaseNode(n)
+	})
+	return keys, pages, err
+}
+
+// dropBucket releases all pages of the nested bucket stored under key with the
+// given value and flags, and returns the number of pages released. The key
+// itself is left in place.
+func (b *Bucket) dropBucket(key, value []byte, flags uint32) (int, error) {
+	child := b.buckets[string(key)]
+	if child == nil {
+		var err error
+		if child, err = b.openBucket(value, flags); err != nil {
+			return 0, err
+		}
+	}
+	delete(b.buckets, string(key))
+
+	_, pages, err := child.dropTree(child.RootPage())
+	child.nodes = nil
+	child.rootNode = nil
+	child.SetRootPage(0)
+	return pages, err
+}
+
+// keyInRange returns whether key lies in the range [start, end), where a nil
+// start or end leaves that end of the range open.
+func (b *Bucket) keyInRange(key, start, end []byte) bool {
+	return (start == nil || b.compare(key, start) >= 0) &&
+		(end == nil || b.compare(key, end) < 0)
+}
+
+// Options returns the options the bucket was created with.
+func (b *Bucket) Options() BucketOptions {
+	return BucketOptions{Codec: b.codec, Comparator: b.cmp, Counted: b.ext.Counted}
+}
+
+// Len returns the number of keys in the bucket, including nested buckets.
+// Buckets created with the Counted option return it without reading their
+// pages. Other buckets are read in full, like Stats does.
+func (b *Bucket) Len() int {
+	if b.ext.Counted {
+		return int(b.ext.KeyN)
+	}
+	keyN, _ := b.countKeys()
+	return keyN
+}
+
+// ValueSize returns the total size of the values in the bucket, as they are
+// stored, so compressed values are counted with their compressed size. Nested
+// buckets are not included. Buckets created with the Counted option return it
+// without reading their pages.
+func (b *Bucket) ValueSize() int64 {
+	if b.ext.Counted {
+		return int64(b.ext.ValueBytes)
+	}
+	_, size := b.countKeys()
+	return size
+}
+
+// countKeys reads the whole bucket and returns the number of its keys and the
+// total size of its values.
+func (b *Bucket) countKeys() (keyN int, valueSize int64) {
+	// The root node of an inline bucket takes precedence over its page.
+	b._forEachPageNode(b.RootPage(), 0, func(p *common.Page, n *node, _ int) {
+		if p != nil {
+			if p.IsLeafPage() {
+				for i := uint16(0); i < p.Count(); i++ {
+					elem := p.LeafPageElement(i)
+					if elem.Flags()&common.BucketLeafFlag == 0 {
+						valueSize += int64(len(elem.Value()))
+					}
+				}
+				keyN += int(p.Count())
+			}
+		} else if n.isLeaf {
+			for _, inode := range n.inodes {
+				if inode.Flags()&common.BucketLeafFlag == 0 {
+					valueSize += int64(len(inode.Value()))
+				}
+			}
+			keyN += len(n.inodes)
+		}
+	})
+	return keyN, valueSize
+}
+
+// count adds delta to the number of keys of the bucket, and delta times the
+// size of value to the total size of its values unless flags mark a nested
+// bucket. It does nothing if the bucket is not counted.
+func (b *Bucket) count(flags uint32, value []byte, delta int64) {
+	if !b.ext.Counted {
+		return
+	}
+	b.ext.KeyN += uint64(delta)
+	if flags&common.BucketLeafFlag == 0 {
+		b.ext.ValueBytes += uint64(delta * int64(len(value)))
+	}
+}
+
 // Sequence returns the current integer for the bucket without incrementing it.
 func (b *Bucket) Sequence() uint64 {
 	return b.InSequence()
@@ -335,7 +974,7 @@ func (b *Bucket) SetSequence(v uint64) error {
 	if b.tx.db == nil {
 		return common.ErrTxClosed
 	} else if !b.Writable() {
-		return common.ErrTxNotWritable
+		return b.notWritable()
 	}
 
 	// Materialize the root node if it hasn't been already so that the
@@ -346,6 +985,9 @@ func (b *Bucket) SetSequence(v uint64) error {
 
 	// Set the sequence.
 	b.SetInSequence(v)
+	if b.tx.changes != nil {
+		b.recordChange(Change{Type: ChangeSequence, Sequence: v})
+	}
 	return nil
 }
 
@@ -354,7 +996,7 @@ func (b *Bucket) NextSequence() (uint64, error) {
 	if b.tx.db == nil {
 		return 0, common.ErrTxClosed
 	} else if !b.Writable() {
-		return 0, common.ErrTxNotWritable
+		return 0, b.notWritable()
 	}
 
 	// Materialize the root node if it hasn't been already so that the
@@ -365,6 +1007,9 @@ func (b *Bucket) NextSequence() (uint64, error) {
 
 	// Increment and return the sequence.
 	b.IncSequence()
+	if b.tx.changes != nil {
+		b.recordChange(Change{Type: ChangeSequence, Sequence: b.Sequence()})
+	}
 	return b.Sequence(), nil
 }
 
@@ -383,7 +1028,7 @@ func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
 			return err
 		}
 	}
-	return nil
+	return c.Err()
 }
 
 func (b *Bucket) ForEachBucket(fn func(k []byte) error) error {
@@ -392,7 +1037,7 @@ func (b *Bucket) ForEachBucket(fn func(k []byte) error) error {
 	}
 	c := b.Cursor()
 	for k, _, flags := c.first(); k != nil; k, _, flags = c.next() {
-		if flags&common.BucketLeafFlag != 0 {
+		if flags&common.BucketLeafFlag != 0 && !c.hidden(k, nil, flags) {
 			if err := fn(k); err != nil {
 				return err
 			}
@@ -429,6 +1074,15 @@ func (b *Bucket) Stats() BucketStats {
 				used += uintptr(lastElement.Pos() + lastElement.Ksize() + lastElement.Vsize())
 			}
 
+			// Add the stored and uncompressed sizes of all values.
+			for i := uint16(0); i < p.Count(); i++ {
+				e := p.LeafPageElement(i)
+				if (e.Flags() & common.BucketLeafFlag) == 0 {
+					s.PhysicalValueBytes += int(e.Vsize())
+					s.LogicalValueBytes += valueSize(e.Value(), e.Flags())
+				}
+			}
+
 			if b.RootPage() == 0 {
 				// For inlined bucket just update the inline stats
 				s.InlineBucketInuse += int(used)
@@ -446,7 +1100,11 @@ func (b *Bucket) Stats() BucketStats {
 					if (e.Flags() & common.BucketLeafFlag) != 0 {
 						// For any bucket element, open the element value
 						// and recursively call Stats on the contained bucket.
-						subStats.Add(b.openBucket(e.Value()).Stats())
+						child, err := b.openBucket(e.Value(), e.Flags())
+						if err != nil {
+							panic(fmt.Sprintf("open bucket %x: %s", e.Key(), err))
+						}
+						subStats.Add(child.Stats())
 					}
 				}
 			}
@@ -533,39 +1191,9 @@ func (b *Bucket) _forEachPageNode(pgId common.Pgid, depth int, fn func(*common.P
 func (b *Bucket) spill() error {
 	// Spill all child buckets first.
 	for name, child := range b.buckets {
-		// If the child bucket is small enough and it has no child buckets then
-		// write it inline into the parent bucket's page. Otherwise spill it
-		// like a normal bucket and make the parent value a pointer to the page.
-		var value []byte
-		if child.inlineable() {
-			child.free()
-			value = child.write()
-		} else {
-			if err := child.spill(); err != nil {
-				return err
-			}
-
-			// Update the child bucket header in this bucket.
-			value = make([]byte, unsafe.Sizeof(common.InBucket{}))
-			var bucket = (*common.InBucket)(unsafe.Pointer(&value[0]))
-			*bucket = *child.InBucket
-		}
-
-		// Skip writing the bucket if there are no materialized nodes.
-		if child.rootNode == nil {
-			continue
-		}
-
-		// Update parent node.
-		var c = b.Cursor()
-		k, _, flags := c.seek([]byte(name))
-		if !bytes.Equal([]byte(name), k) {
-			panic(fmt.Sprintf("misplaced bucket header: %x -> %x", []byte(name), k))
-		}
-		if flags&common.BucketLeafFlag == 0 {
-			panic(fmt.Sprintf("unexpected bucket header flag: %x", flags))
+		if err := b.spillChild(name, child); err != nil {
+			return err
 		}
-		c.node().put([]byte(name), []byte(name), value, 0, common.BucketLeafFlag)
 	}
 
 	// Ignore if there's not a materialized root node.
@@ -588,6 +1216,43 @@ func (b *Bucket) spill() error {
 	return nil
 }
 
+// spillChild spills a child bucket, and writes its header into the bucket.
+func (b *Bucket) spillChild(name string, child *Bucket) error {
+	// If the child bucket is small enough and it has no child buckets then
+	// write it inline into the parent bucket's page. Otherwise spill it
+	// like a normal bucket and make the parent value a pointer to the page.
+	var value []byte
+	if child.inlineable() {
+		child.free()
+		value = child.write()
+	} else {
+		if err := child.spill(); err != nil {
+			return err
+		}
+
+		// Update the child bucket header in this bucket.
+		value = make([]byte, common.BucketHeaderSize+child.ext.Size())
+		child.writeHeader(value)
+	}
+
+	// Skip writing the bucket if there are no materialized nodes.
+	if child.rootNode == nil {
+		return nil
+	}
+
+	// Update parent node.
+	var c = b.Cursor()
+	k, _, flags := c.seek([]byte(name))
+	if !bytes.Equal([]byte(name), k) {
+		panic(fmt.Sprintf("misplaced bucket header: %x -> %x", []byte(name), k))
+	}
+	if flags&common.BucketLeafFlag == 0 {
+		panic(fmt.Sprintf("unexpected bucket header flag: %x", flags))
+	}
+	c.node().put([]byte(name), []byte(name), value, 0, child.leafFlags())
+	return nil
+}
+
 // inlineable returns true if a bucket is small enough to be written inline
 // and if it contains no subbuckets. Otherwise, returns false.
 func (b *Bucket) inlineable() bool {
@@ -623,19 +1288,35 @@ func (b *Bucket) maxInlineBucketSize() uintptr {
 func (b *Bucket) write() []byte {
 	// Allocate the appropriate size.
 	var n = b.rootNode
-	var value = make([]byte, common.BucketHeaderSize+n.size())
+	var headerSize = common.BucketHeaderSize + b.ext.Size()
+	var value = make([]byte, headerSize+n.size())
 
 	// Write a bucket header.
-	var bucket = (*common.InBucket)(unsafe.Pointer(&value[0]))
-	*bucket = *b.InBucket
+	b.writeHeader(value)
 
 	// Convert byte slice to a fake page and write the root node.
-	var p = (*common.Page)(unsafe.Pointer(&value[common.BucketHeaderSize]))
+	var p = (*common.Page)(unsafe.Pointer(&value[headerSize]))
 	n.write(p)
 
 	return value
 }
 
+// writeHeader writes the bucket header to a byte slice, followed by the
+// extension holding the options of the bucket, if any.
+func (b *Bucket) writeHeader(value []byte) {
+	var bucket = (*common.InBucket)(unsafe.Pointer(&value[0]))
+	*bucket = *b.InBucket
+	b.ext.Write(value[common.BucketHeaderSize:])
+}
+
+// leafFlags returns the flags of the element holding the bucket in its parent.
+func (b *Bucket) leafFlags() uint32 {
+	if b.ext.IsZero() {
+		return common.BucketLeafFlag
+	}
+	return common.BucketLeafFlag | common.BucketExtLeafFlag
+}
+
 // rebalance attempts to balance all nodes.
 func (b *Bucket) rebalance() {
 	for _, n := range b.nodes {
@@ -688,7 +1369,7 @@ func (b *Bucket) free() {
 	var tx = b.tx
 	b.forEachPageNode(func(p *common.Page, n *node, _ int) {
 		if p != nil {
-			tx.db.freelist.free(tx.meta.Txid(), p)
+			tx.free(p)
 		} else {
 			n.free()
 		}
@@ -755,6 +1436,10 @@ type BucketStats struct {
 	BucketN           int // total number of buckets including the top bucket
 	InlineBucketN     int // total number on inlined buckets
 	InlineBucketInuse int // bytes used for inlined buckets (also accounted for in LeafInuse)
+
+	// Value size statistics.
+	LogicalValueBytes  int // bytes of values, uncompressed
+	PhysicalValueBytes int // bytes of values as stored, compressed if the bucket has a codec
 }
 
 func (s *BucketStats) Add(other BucketStats) {
@@ -774,6 +1459,9 @@ func (s *BucketStats) Add(other BucketStats) {
 	s.BucketN += other.BucketN
 	s.InlineBucketN += other.InlineBucketN
 	s.InlineBucketInuse += other.InlineBucketInuse
+
+	s.LogicalValueBytes += other.LogicalValueBytes
+	s.PhysicalValueBytes += other.PhysicalValueBytes
 }
 
 // cloneBytes returns a copy of a given slice.
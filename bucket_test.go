@@ -615,6 +615,302 @@ func TestBucket_Delete_Closed(t *testing.T) {
 	}
 }
 
+// Ensure that a range of keys can be deleted without reading the subtrees
+// inside of it into memory.
+func TestBucket_DeleteRange(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%08d", i)) }
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 10000; i++ {
+			require.NoError(t, b.Put(key(i), key(i)))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		keys, pages, err := b.DeleteRange(key(1000), key(9000))
+		require.NoError(t, err)
+		require.Equal(t, 8000, keys)
+		require.Greater(t, pages, 10)
+
+		// Only the nodes along the boundaries of the range are read.
+		stats := tx.Stats()
+		require.Less(t, stats.GetNodeCount(), int64(10))
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 2000, b.Stats().KeyN)
+		require.Equal(t, key(999), b.Get(key(999)))
+		require.Nil(t, b.Get(key(1000)))
+		require.Nil(t, b.Get(key(8999)))
+		require.Equal(t, key(9000), b.Get(key(9000)))
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// Ensure that DeleteRange removes the same keys as deleting them one by one,
+// with open ranges, keys changed in the same transaction and nested buckets.
+func TestBucket_DeleteRange_Random(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	rng := rand.New(rand.NewSource(1))
+
+	key := func(i int) []byte { return []byte(fmt.Sprintf("%06d", i)) }
+	expected := make(map[string]bool)
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 20000; i += 2 {
+			if i%1000 == 0 {
+				child, err := b.CreateBucket(key(i))
+				require.NoError(t, err)
+				for j := 0; j < i/10; j++ {
+					require.NoError(t, child.Put(key(j), make([]byte, 100)))
+				}
+			} else {
+				require.NoError(t, b.Put(key(i), make([]byte, rng.Intn(200))))
+			}
+			expected[string(key(i))] = true
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	for round := 0; round < 20; round++ {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+
+			// Change some keys first so that part of the tree is in nodes.
+			for i := 0; i < 50; i++ {
+				k := key(rng.Intn(20000) | 1)
+				require.NoError(t, b.Put(k, []byte("x")))
+				expected[string(k)] = true
+			}
+
+			var start, end []byte
+			if rng.Intn(5) > 0 {
+				start = key(rng.Intn(20000))
+			}
+			if rng.Intn(5) > 0 {
+				end = key(rng.Intn(20000))
+			}
+			var want int
+			for k := range expected {
+				if (start == nil || k >= string(start)) && (end == nil || k < string(end)) {
+					delete(expected, k)
+					want++
+				}
+			}
+			keys, _, err := b.DeleteRange(start, end)
+			require.NoError(t, err)
+			require.Equal(t, want, keys, "range [%s, %s)", start, end)
+			return nil
+		})
+		require.NoError(t, err)
+
+		err = db.View(func(tx *bolt.Tx) error {
+			var got int
+			err := tx.Bucket([]byte("widgets")).ForEach(func(k, _ []byte) error {
+				require.True(t, expected[string(k)], "unexpected key %s", k)
+				got++
+				return nil
+			})
+			require.Equal(t, len(expected), got)
+			return err
+		})
+		require.NoError(t, err)
+		db.MustCheck()
+	}
+}
+
+// Ensure that a range delete is recorded as a single change.
+func TestBucket_DeleteRange_Changes(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("1")))
+		require.NoError(t, b.Put([]byte("b"), []byte("2")))
+		return nil
+	})
+	require.NoError(t, err)
+
+	var changes []bolt.Change
+	err = db.Update(func(tx *bolt.Tx) error {
+		tx.OnCommitChanges(func(cs *bolt.ChangeSet) { changes = cs.Changes })
+		b := tx.Bucket([]byte("widgets"))
+		keys, pages, err := b.DeleteRange(nil, []byte("b"))
+		require.NoError(t, err)
+		require.Equal(t, 1, keys)
+		require.Equal(t, 0, pages)
+
+		// Nothing is recorded for an empty range.
+		keys, _, err = b.DeleteRange([]byte("x"), nil)
+		require.NoError(t, err)
+		require.Equal(t, 0, keys)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []bolt.Change{
+		{Type: bolt.ChangeDeleteRange, Bucket: [][]byte{[]byte("widgets")}, End: []byte("b")},
+	}, changes)
+}
+
+// Ensure that deleting a range in a read-only or closed transaction returns
+// an error.
+func TestBucket_DeleteRange_ReadOnly(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	})
+	require.NoError(t, err)
+
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	b := tx.Bucket([]byte("widgets"))
+	_, _, err = b.DeleteRange(nil, nil)
+	require.Equal(t, common.ErrTxNotWritable, err)
+	require.NoError(t, tx.Rollback())
+
+	_, _, err = b.DeleteRange(nil, nil)
+	require.Equal(t, common.ErrTxClosed, err)
+}
+
+// Ensure that a counted bucket keeps its key count and value size up to date
+// across all ways of adding and removing keys.
+func TestBucket_Len(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	// valueSize sums up the values of a bucket by iterating over it.
+	valueSize := func(b *bolt.Bucket) (n int64) {
+		require.NoError(t, b.ForEach(func(k, v []byte) error {
+			n += int64(len(v))
+			return nil
+		}))
+		return n
+	}
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Counted: true})
+		require.NoError(t, err)
+		require.True(t, b.Options().Counted)
+		require.Equal(t, 0, b.Len())
+
+		require.NoError(t, b.Put([]byte("foo"), []byte("bar")))
+		require.NoError(t, b.Put([]byte("foo"), []byte("barbaz")))
+		require.NoError(t, b.Put([]byte("baz"), []byte("bat")))
+		require.NoError(t, b.Delete([]byte("missing")))
+		_, err = b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		require.Equal(t, 3, b.Len())
+		require.Equal(t, int64(9), b.ValueSize())
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 3, b.Len())
+		require.Equal(t, int64(9), b.ValueSize())
+
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, i%50)))
+		}
+		require.NoError(t, b.DeleteBucket([]byte("nested")))
+		require.NoError(t, b.Delete([]byte("foo")))
+
+		c := b.Cursor()
+		c.Seek([]byte("0500"))
+		require.NoError(t, c.Delete())
+
+		_, _, err := b.DeleteRange([]byte("0100"), []byte("0400"))
+		require.NoError(t, err)
+		return nil
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 700, b.Len())
+		require.Equal(t, b.Stats().KeyN, b.Len())
+		require.Equal(t, valueSize(b), b.ValueSize())
+		return nil
+	}))
+
+	// Buckets which are not counted are read in full.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("uncounted"))
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value")))
+		}
+		require.False(t, b.Options().Counted)
+		require.Equal(t, 100, b.Len())
+		require.Equal(t, int64(500), b.ValueSize())
+		return nil
+	}))
+}
+
+// Ensure that the counters of a bucket stay in sync with its contents under
+// random changes which are partly rolled back.
+func TestBucket_Len_Random(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	rng := rand.New(rand.NewSource(1))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Counted: true})
+		return err
+	}))
+
+	for round := 0; round < 20; round++ {
+		tx, err := db.Begin(true)
+		require.NoError(t, err)
+		b := tx.Bucket([]byte("widgets"))
+
+		var sp *bolt.Savepoint
+		for i := 0; i < 500; i++ {
+			key := []byte(fmt.Sprintf("%05d", rng.Intn(5000)))
+			switch r := rng.Intn(100); {
+			case r == 0:
+				sp, err = tx.Savepoint()
+				require.NoError(t, err)
+			case r == 1 && sp != nil:
+				require.NoError(t, tx.RollbackTo(sp))
+			case r < 5:
+				_, _, err = b.DeleteRange(key, []byte(fmt.Sprintf("%05d", rng.Intn(5000))))
+				require.NoError(t, err)
+			case r < 30:
+				require.NoError(t, b.Delete(key))
+			default:
+				require.NoError(t, b.Put(key, make([]byte, rng.Intn(200))))
+			}
+		}
+
+		if round%3 == 0 {
+			require.NoError(t, tx.Rollback())
+		} else {
+			require.NoError(t, tx.Commit())
+		}
+		db.MustCheck()
+	}
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, b.Stats().KeyN, b.Len())
+		return nil
+	}))
+}
+
 // Ensure that deleting a bucket causes nested buckets to be deleted.
 func TestBucket_DeleteBucket_Nested(t *testing.T) {
 	db := btesting.MustCreateDB(t)
@@ -1278,9 +1574,11 @@ func TestBucket_Stats(t *testing.T) {
 				501*16 + // leaf elements
 				500*3 + len(bigKey) + // leaf keys
 				1*10 + 2*90 + 3*400 + longKeyLength, // leaf values: 10 * 1digit, 90*2digits, ...
-			BucketN:           1,
-			InlineBucketN:     0,
-			InlineBucketInuse: 0},
+			BucketN:            1,
+			InlineBucketN:      0,
+			InlineBucketInuse:  0,
+			LogicalValueBytes:  1*10 + 2*90 + 3*400 + longKeyLength,
+			PhysicalValueBytes: 1*10 + 2*90 + 3*400 + longKeyLength},
 		16384: {
 			BranchPageN:     1,
 			BranchOverflowN: 0,
@@ -1296,9 +1594,11 @@ func TestBucket_Stats(t *testing.T) {
 				501*16 + // leaf elements
 				500*3 + len(bigKey) + // leaf keys
 				1*10 + 2*90 + 3*400 + longKeyLength, // leaf values: 10 * 1digit, 90*2digits, ...
-			BucketN:           1,
-			InlineBucketN:     0,
-			InlineBucketInuse: 0},
+			BucketN:            1,
+			InlineBucketN:      0,
+			InlineBucketInuse:  0,
+			LogicalValueBytes:  1*10 + 2*90 + 3*400 + longKeyLength,
+			PhysicalValueBytes: 1*10 + 2*90 + 3*400 + longKeyLength},
 	}
 
 	if err := db.View(func(tx *bolt.Tx) error {
@@ -1637,33 +1937,37 @@ func TestBucket_Stats_Large(t *testing.T) {
 
 	pageSize2stats := map[int]bolt.BucketStats{
 		4096: {
-			BranchPageN:       13,
-			BranchOverflowN:   0,
-			LeafPageN:         1196,
-			LeafOverflowN:     0,
-			KeyN:              100000,
-			Depth:             3,
-			BranchAlloc:       53248,
-			BranchInuse:       25257,
-			LeafAlloc:         4898816,
-			LeafInuse:         2596916,
-			BucketN:           1,
-			InlineBucketN:     0,
-			InlineBucketInuse: 0},
+			BranchPageN:        13,
+			BranchOverflowN:    0,
+			LeafPageN:          1196,
+			LeafOverflowN:      0,
+			KeyN:               100000,
+			Depth:              3,
+			BranchAlloc:        53248,
+			BranchInuse:        25257,
+			LeafAlloc:          4898816,
+			LeafInuse:          2596916,
+			BucketN:            1,
+			InlineBucketN:      0,
+			InlineBucketInuse:  0,
+			LogicalValueBytes:  10*1 + 90*2 + 900*3 + 9000*4 + 90000*5,
+			PhysicalValueBytes: 10*1 + 90*2 + 900*3 + 9000*4 + 90000*5},
 		16384: {
-			BranchPageN:       1,
-			BranchOverflowN:   0,
-			LeafPageN:         292,
-			LeafOverflowN:     0,
-			KeyN:              100000,
-			Depth:             2,
-			BranchAlloc:       16384,
-			BranchInuse:       6094,
-			LeafAlloc:         4784128,
-			LeafInuse:         2582452,
-			BucketN:           1,
-			InlineBucketN:     0,
-			InlineBucketInuse: 0},
+			BranchPageN:        1,
+			BranchOverflowN:    0,
+			LeafPageN:          292,
+			LeafOverflowN:      0,
+			KeyN:               100000,
+			Depth:              2,
+			BranchAlloc:        16384,
+			BranchInuse:        6094,
+			LeafAlloc:          4784128,
+			LeafInuse:          2582452,
+			BucketN:            1,
+			InlineBucketN:      0,
+			InlineBucketInuse:  0,
+			LogicalValueBytes:  10*1 + 90*2 + 900*3 + 9000*4 + 90000*5,
+			PhysicalValueBytes: 10*1 + 90*2 + 900*3 + 9000*4 + 90000*5},
 	}
 
 	if err := db.View(func(tx *bolt.Tx) error {
@@ -0,0 +1,307 @@
+package bbolt
+
+import (
+	"bytes"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// BulkLoader builds a new bucket from key/value pairs added in strictly
+// increasing key order. Instead of inserting the keys into nodes which are
+// split when the transaction commits, it packs them into full leaf pages,
+// which are written to disk as soon as they fill up, and builds the branch
+// levels of the bucket from the bottom up. At most two pages per level are held
+// in memory, the page being filled and the last full one, so buckets much
+// larger than memory can be loaded.
+//
+// A BulkLoader is created with Tx.BulkLoad or Bucket.BulkLoad, and the bucket
+// is added to its parent when the loader is closed. It is only valid for the
+// lifetime of the transaction.
+type BulkLoader struct {
+	parent *Bucket
+	name   []byte
+	path   [][]byte
+	levels []*bulkLevel
+	last   []byte
+	keyN   int
+	closed bool
+	err    error
+
+	// runs are the pages written by the loader, which are released if the
+	// load fails.
+	runs []pageRun
+}
+
+// bulkLevel holds the elements of a level of the tree which are not written
+// yet. The last full page of the level is held back, so that the final page
+// of a branch level can take elements from it.
+type bulkLevel struct {
+	isLeaf bool
+	prev   common.Inodes
+	cur    common.Inodes
+	size   int
+}
+
+// BulkLoad starts loading a new bucket in the root of the database.
+// Returns an error if the bucket already exists, if the bucket name is blank,
+// or if the transaction has savepoints.
+func (tx *Tx) BulkLoad(name []byte) (*BulkLoader, error) {
+	if tx.isReservedName(name) {
+		return nil, common.ErrBucketNameReserved
+	}
+	return tx.root.BulkLoad(name)
+}
+
+// BulkLoad starts loading a new nested bucket. The bucket is created when the
+// loader is closed, and must not be created otherwise in the meantime.
+// Returns an error if the bucket already exists, if the bucket name is blank,
+// or if the transaction has savepoints.
+func (b *Bucket) BulkLoad(name []byte) (*BulkLoader, error) {
+	if b.tx.db == nil {
+		return nil, common.ErrTxClosed
+	} else if !b.tx.writable {
+		return nil, common.ErrTxNotWritable
+	} else if b.tx.scope != nil {
+		return nil, common.ErrScopedBulkLoad
+	} else if len(name) == 0 {
+		return nil, common.ErrBucketNameRequired
+	} else if len(b.tx.savepoints) > 0 {
+		return nil, common.ErrSavepointBulkLoad
+	}
+
+	l := &BulkLoader{parent: b, name: cloneBytes(name)}
+	if err := l.checkName(); err != nil {
+		return nil, err
+	}
+	l.path = append(b.path(), l.name)
+	b.tx.loaders = append(b.tx.loaders, l)
+	b.tx.bulkLoaded = true
+
+	if b.tx.changes != nil {
+		b.recordChange(Change{Type: ChangeCreateBucket, Key: l.name})
+	}
+
+	return l, nil
+}
+
+// Put adds a key/value pair to the bucket. The key must be greater than the
+// key added before it. The key and value are copied.
+func (l *BulkLoader) Put(key, value []byte) error {
+	if l.parent.tx.db == nil {
+		return common.ErrTxClosed
+	} else if l.closed {
+		return common.ErrLoaderClosed
+	} else if l.err != nil {
+		return l.err
+	} else if len(key) == 0 {
+		return common.ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return common.ErrKeyTooLarge
+	} else if int64(len(value)) > MaxValueSize {
+		return common.ErrValueTooLarge
+	} else if l.last != nil && bytes.Compare(key, l.last) <= 0 {
+		return common.ErrKeyOutOfOrder
+	}
+
+	var inode common.Inode
+	inode.SetKey(cloneBytes(key))
+	inode.SetValue(cloneBytes(value))
+	if err := l.add(0, inode); err != nil {
+		l.err = err
+		return err
+	}
+	l.last = inode.Key()
+	l.keyN++
+
+	if tx := l.parent.tx; tx.changes != nil {
+		tx.changes.Changes = append(tx.changes.Changes, Change{Type: ChangePut, Bucket: l.path, Key: inode.Key(), Value: inode.Value()})
+	}
+
+	return nil
+}
+
+// Close writes the remaining pages of the bucket and adds the bucket to its
+// parent. Loaders which are still open when the transaction commits are
+// closed by the commit. If Close fails, the pages written by the loader are
+// released and the bucket is not created.
+func (l *BulkLoader) Close() error {
+	tx := l.parent.tx
+	if tx.db == nil {
+		return common.ErrTxClosed
+	} else if l.closed {
+		return common.ErrLoaderClosed
+	}
+	l.closed = true
+	for i, other := range tx.loaders {
+		if other == l {
+			tx.loaders = append(tx.loaders[:i], tx.loaders[i+1:]...)
+			break
+		}
+	}
+
+	err := l.err
+	if err == nil {
+		err = l.checkName()
+	}
+	var root common.Pgid
+	if err == nil && l.keyN > 0 {
+		root, err = l.finish()
+	}
+	if err != nil {
+		l.abort()
+		return err
+	}
+
+	// Empty buckets are stored inline, like the ones created by CreateBucket.
+	var bucket = Bucket{InBucket: &common.InBucket{}}
+	var value []byte
+	if l.keyN == 0 {
+		bucket.rootNode = &node{isLeaf: true}
+		value = bucket.write()
+	} else {
+		bucket.SetRootPage(root)
+		value = make([]byte, common.BucketHeaderSize)
+		bucket.writeHeader(value)
+	}
+
+	// Insert into node.
+	c := l.parent.Cursor()
+	c.seek(l.name)
+	c.node().put(l.name, l.name, value, 0, bucket.leafFlags())
+	l.parent.page = nil
+	l.parent.watchKey(l.name, false, true)
+	tx.watchResync(l.path)
+
+	// Index the keys of the bucket, which were written around its indexes.
+	if len(tx.indexes) > 0 {
+		return tx.rebuildIndexes(l.path)
+	}
+
+	return nil
+}
+
+// checkName returns an error if the key of the bucket exists in its parent.
+func (l *BulkLoader) checkName() error {
+	k, _, flags := l.parent.Cursor().seek(l.name)
+	if bytes.Equal(l.name, k) {
+		if (flags & common.BucketLeafFlag) != 0 {
+			return common.ErrBucketExists
+		}
+		return common.ErrIncompatibleValue
+	}
+	return nil
+}
+
+// add appends an element to a level of the tree. The page being filled is
+// held back once the element does not fit into it anymore, and the page
+// held back before it is written.
+func (l *BulkLoader) add(depth int, inode common.Inode) error {
+	if depth == len(l.levels) {
+		l.levels = append(l.levels, &bulkLevel{isLeaf: depth == 0, size: int(common.PageHeaderSize)})
+	}
+	lvl := l.levels[depth]
+
+	elsz := common.BranchPageElementSize
+	if lvl.isLeaf {
+		elsz = common.LeafPageElementSize
+	}
+	sz := int(elsz) + len(inode.Key()) + len(inode.Value())
+	capacity := l.parent.tx.db.pageSize - l.parent.tx.db.trailerSize()
+	if len(lvl.cur) > 0 && (lvl.size+sz > capacity || len(lvl.cur) == 0xFFFE) {
+		if err := l.flush(depth, lvl.prev); err != nil {
+			return err
+		}
+		lvl.prev, lvl.cur, lvl.size = lvl.cur, nil, int(common.PageHeaderSize)
+	}
+	lvl.cur = append(lvl.cur, inode)
+	lvl.size += sz
+	return nil
+}
+
+// flush writes a page of a level of the tree, and adds it to the level above.
+func (l *BulkLoader) flush(depth int, inodes common.Inodes) error {
+	if len(inodes) == 0 {
+		return nil
+	}
+	id, err := l.write(depth, inodes)
+	if err != nil {
+		return err
+	}
+	var inode common.Inode
+	inode.SetKey(inodes[0].Key())
+	inode.SetPgid(id)
+	return l.add(depth+1, inode)
+}
+
+// finish writes the pages which are held back, level by level, and returns
+// the root page of the bucket.
+func (l *BulkLoader) finish() (common.Pgid, error) {
+	for depth := 0; ; depth++ {
+		lvl := l.levels[depth]
+
+		// The only page of the top level is the root.
+		if depth == len(l.levels)-1 && lvl.prev == nil {
+			return l.write(depth, lvl.cur)
+		}
+
+		// Branch pages other than the root must have at least two
+		// elements, so the last page takes some from the page before it.
+		if !lvl.isLeaf {
+			for len(lvl.cur) < 2 && len(lvl.prev) > 2 {
+				lvl.cur = append(common.Inodes{lvl.prev[len(lvl.prev)-1]}, lvl.cur...)
+				lvl.prev = lvl.prev[:len(lvl.prev)-1]
+			}
+			if len(lvl.cur) < 2 {
+				lvl.prev, lvl.cur = append(lvl.prev, lvl.cur...), nil
+				if depth == len(l.levels)-1 {
+					return l.write(depth, lvl.prev)
+				}
+			}
+		}
+		if err := l.flush(depth, lvl.prev); err != nil {
+			return 0, err
+		}
+		if err := l.flush(depth, lvl.cur); err != nil {
+			return 0, err
+		}
+		lvl.prev, lvl.cur = nil, nil
+	}
+}
+
+// write allocates a page for the elements of a level of the tree and writes
+// it to disk.
+func (l *BulkLoader) write(depth int, inodes common.Inodes) (common.Pgid, error) {
+	tx := l.parent.tx
+	n := &node{isLeaf: depth == 0, inodes: inodes}
+	size := n.size() + tx.db.trailerSize()
+	p, err := tx.allocate((size + tx.db.pageSize - 1) / tx.db.pageSize)
+	if err != nil {
+		return 0, err
+	}
+	n.write(p)
+
+	id, count := p.Id(), uint64(p.Overflow())+1
+	if n := len(l.runs); n > 0 && l.runs[n-1].id+common.Pgid(l.runs[n-1].count) == id {
+		l.runs[n-1].count += count
+	} else {
+		l.runs = append(l.runs, pageRun{id: id, count: count})
+	}
+	if err := tx.flushPage(p); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// abort releases the pages written by the loader.
+func (l *BulkLoader) abort() {
+	tx := l.parent.tx
+	for _, run := range l.runs {
+		for i := uint64(0); i < run.count; i++ {
+			var p common.Page
+			p.SetId(run.id + common.Pgid(i))
+			tx.free(&p)
+		}
+	}
+	l.runs = nil
+	l.levels = nil
+}
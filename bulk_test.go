@@ -0,0 +1,206 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that a bulk loaded bucket holds the loaded keys in packed pages, and
+// can be modified like any other bucket afterwards.
+func TestTx_BulkLoad(t *testing.T) {
+	for name, o := range map[string]*bolt.Options{
+		"default":   nil,
+		"checksums": {FormatVersion: common.VersionChecksums},
+		"encrypted": {Encryption: testKey},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, o)
+
+			key := func(i int) []byte { return []byte(fmt.Sprintf("%08d", i)) }
+			const n = 100000
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				l, err := tx.BulkLoad([]byte("widgets"))
+				require.NoError(t, err)
+				for i := 0; i < n; i++ {
+					require.NoError(t, l.Put(key(i), []byte(fmt.Sprintf("value-%d", i))))
+				}
+				return l.Close()
+			}))
+			db.MustCheck()
+
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.NotNil(t, b)
+				stats := b.Stats()
+				require.Equal(t, n, stats.KeyN)
+				require.Greater(t, float64(stats.LeafInuse)/float64(stats.LeafAlloc), 0.95)
+
+				i := 0
+				c := b.Cursor()
+				for k, v := c.First(); k != nil; k, v = c.Next() {
+					require.Equal(t, key(i), k)
+					require.Equal(t, []byte(fmt.Sprintf("value-%d", i)), v)
+					i++
+				}
+				require.Equal(t, n, i)
+				return nil
+			}))
+
+			// Deletes rebalance the loaded pages.
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				for i := 0; i < n; i += 3 {
+					require.NoError(t, b.Delete(key(i)))
+				}
+				_, _, err := b.DeleteRange(key(n/2), nil)
+				return err
+			}))
+			db.MustCheck()
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				require.Equal(t, 33333, tx.Bucket([]byte("widgets")).Stats().KeyN)
+				return nil
+			}))
+		})
+	}
+}
+
+// Ensure that nested buckets, empty buckets and values larger than a page can
+// be bulk loaded.
+func TestBucket_BulkLoad(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	large := bytes.Repeat([]byte("x"), 3*db.Info().PageSize)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+
+		l, err := b.BulkLoad([]byte("large"))
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, l.Put([]byte(fmt.Sprintf("%04d", i)), large))
+		}
+		require.NoError(t, l.Close())
+
+		l, err = b.BulkLoad([]byte("empty"))
+		require.NoError(t, err)
+		return l.Close()
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 100, b.Bucket([]byte("large")).Stats().KeyN)
+		require.Equal(t, large, b.Bucket([]byte("large")).Get([]byte("0042")))
+		require.Equal(t, 0, b.Bucket([]byte("empty")).Stats().KeyN)
+		return nil
+	}))
+}
+
+// Ensure that keys must be added to a bulk loader in increasing order.
+func TestBulkLoader_Put_OutOfOrder(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		l, err := tx.BulkLoad([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, l.Put([]byte("b"), []byte("1")))
+		require.Equal(t, common.ErrKeyOutOfOrder, l.Put([]byte("a"), []byte("2")))
+		require.Equal(t, common.ErrKeyOutOfOrder, l.Put([]byte("b"), []byte("3")))
+		require.Equal(t, common.ErrKeyRequired, l.Put(nil, []byte("4")))
+
+		// The loader is still usable after rejecting a key.
+		require.NoError(t, l.Put([]byte("c"), []byte("5")))
+		require.NoError(t, l.Close())
+		require.Equal(t, common.ErrLoaderClosed, l.Put([]byte("d"), []byte("6")))
+		require.Equal(t, common.ErrLoaderClosed, l.Close())
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 2, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	}))
+}
+
+// Ensure that a bulk load fails without leaking pages if the bucket is created
+// while it is loaded.
+func TestBulkLoader_Close_BucketExists(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		_, err = tx.BulkLoad([]byte("widgets"))
+		require.Equal(t, common.ErrBucketExists, err)
+
+		l, err := tx.BulkLoad([]byte("gadgets"))
+		require.NoError(t, err)
+		for i := 0; i < 10000; i++ {
+			require.NoError(t, l.Put([]byte(fmt.Sprintf("%08d", i)), make([]byte, 100)))
+		}
+		_, err = tx.CreateBucket([]byte("gadgets"))
+		require.NoError(t, err)
+		require.Equal(t, common.ErrBucketExists, l.Close())
+		return nil
+	}))
+	db.MustCheck()
+}
+
+// Ensure that bulk loaders which are still open are closed by the commit, and
+// that rolling back a bulk load does not leak pages.
+func TestBulkLoader_Commit(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	l, err := tx.BulkLoad([]byte("widgets"))
+	require.NoError(t, err)
+	for i := 0; i < 10000; i++ {
+		require.NoError(t, l.Put([]byte(fmt.Sprintf("%08d", i)), make([]byte, 100)))
+	}
+	require.NoError(t, tx.Rollback())
+	db.MustCheck()
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		l, err := tx.BulkLoad([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 10000; i++ {
+			require.NoError(t, l.Put([]byte(fmt.Sprintf("%08d", i)), make([]byte, 100)))
+		}
+		return nil
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 10000, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	}))
+}
+
+// Ensure that savepoints can not be combined with bulk loads.
+func TestBulkLoader_Savepoint(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Savepoint()
+		require.NoError(t, err)
+		_, err = tx.BulkLoad([]byte("widgets"))
+		require.Equal(t, common.ErrSavepointBulkLoad, err)
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.BulkLoad([]byte("widgets"))
+		require.NoError(t, err)
+		_, err = tx.Savepoint()
+		require.Equal(t, common.ErrSavepointBulkLoad, err)
+		return nil
+	}))
+}
@@ -0,0 +1,124 @@
+package bbolt
+
+// ChangeType is the kind of change recorded in a ChangeSet.
+type ChangeType uint8
+
+const (
+	// ChangePut sets Key to Value in the bucket.
+	ChangePut ChangeType = iota + 1
+
+	// ChangeDelete removes Key from the bucket.
+	ChangeDelete
+
+	// ChangeCreateBucket creates the nested bucket named Key.
+	ChangeCreateBucket
+
+	// ChangeDeleteBucket deletes the nested bucket named Key along with
+	// everything in it.
+	ChangeDeleteBucket
+
+	// ChangeSequence sets the sequence of the bucket to Sequence.
+	ChangeSequence
+
+	// ChangeDeleteRange removes the keys from Key up to, but not including,
+	// End from the bucket, along with the nested buckets among them. A nil
+	// Key or End leaves that end of the range open.
+	ChangeDeleteRange
+
+	// ChangeMoveBucket moves the nested bucket named Key, along with
+	// everything in it, to the path Target.
+	ChangeMoveBucket
+)
+
+// String returns the name of the change type.
+func (t ChangeType) String() string {
+	switch t {
+	case ChangePut:
+		return "put"
+	case ChangeDelete:
+		return "delete"
+	case ChangeCreateBucket:
+		return "create-bucket"
+	case ChangeDeleteBucket:
+		return "delete-bucket"
+	case ChangeSequence:
+		return "sequence"
+	case ChangeDeleteRange:
+		return "delete-range"
+	case ChangeMoveBucket:
+		return "move-bucket"
+	default:
+		return "unknown"
+	}
+}
+
+// Change is a single modification made by a write transaction.
+type Change struct {
+	Type ChangeType
+
+	// Bucket is the path of names from the top level down to the bucket the
+	// change was made in. It is empty for top level buckets created or
+	// deleted in the root of the database.
+	Bucket [][]byte
+
+	// Key is the key that was put or deleted, or the name of the bucket that
+	// was created or deleted. It is the start of the range for
+	// ChangeDeleteRange.
+	Key []byte
+
+	// Value is the new value of the key for ChangePut.
+	Value []byte
+
+	// End is the end of the range for ChangeDeleteRange.
+	End []byte
+
+	// Sequence is the new sequence of the bucket for ChangeSequence.
+	Sequence uint64
+
+	// Target is the path of names of the bucket after a ChangeMoveBucket,
+	// ending with its new name.
+	Target [][]byte
+}
+
+// ChangeSet holds the changes made by a write transaction in the order they
+// were made.
+type ChangeSet struct {
+	Txid    int
+	Changes []Change
+
+	// Incomplete is set if the transaction made changes before they were
+	// tracked, which are missing from Changes.
+	Incomplete bool
+}
+
+// OnCommitChanges adds a handler function to be executed with the changes
+// made by the transaction after it successfully commits. Handlers run after
+// the ones added with OnCommit, and must not modify the ChangeSet.
+//
+// Changes are only tracked from the time the first handler is added, unless
+// DB.TrackChanges was set when the transaction began. If the transaction made
+// changes before, the ChangeSet is marked as Incomplete.
+func (tx *Tx) OnCommitChanges(fn func(*ChangeSet)) {
+	if tx.changes == nil {
+		// Nodes are only materialized by writes, so a transaction without
+		// any made no changes yet.
+		tx.changes = &ChangeSet{Txid: int(tx.meta.Txid()), Incomplete: tx.stats.GetNodeCount() > 0}
+	}
+	tx.changeHandlers = append(tx.changeHandlers, fn)
+}
+
+// recordChange appends a change made in the bucket to the change set of the
+// transaction. Changes must only be recorded while they are being tracked.
+func (b *Bucket) recordChange(c Change) {
+	c.Bucket = b.path()
+	b.tx.changes.Changes = append(b.tx.changes.Changes, c)
+}
+
+// path returns the names of the bucket and its parents, starting from the top
+// level bucket.
+func (b *Bucket) path() [][]byte {
+	if b.parent == nil {
+		return nil
+	}
+	return append(b.parent.path(), b.name)
+}
@@ -0,0 +1,130 @@
+package bbolt_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// Ensure that the changes of a transaction are passed to its handlers in order.
+func TestTx_OnCommitChanges(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("old"), []byte("value")); err != nil {
+			return err
+		}
+		return b.Put([]byte("cursor"), []byte("value"))
+	}))
+
+	var got *bolt.ChangeSet
+	var txid int
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		txid = tx.ID()
+		tx.OnCommitChanges(func(cs *bolt.ChangeSet) { got = cs })
+
+		b := tx.Bucket([]byte("widgets"))
+		value := []byte("bar")
+		require.NoError(t, b.Put([]byte("foo"), value))
+		copy(value, "xxx")
+		require.NoError(t, b.Delete([]byte("old")))
+		require.NoError(t, b.Delete([]byte("missing")))
+		c := b.Cursor()
+		c.Seek([]byte("cursor"))
+		require.NoError(t, c.Delete())
+
+		nested, err := b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		require.NoError(t, nested.Put([]byte("baz"), []byte("bat")))
+		_, err = nested.NextSequence()
+		require.NoError(t, err)
+		require.NoError(t, nested.SetSequence(10))
+		require.NoError(t, tx.MoveBucket([][]byte{[]byte("widgets"), []byte("nested")}, nil, []byte("moved")))
+
+		_, err = tx.CreateBucket([]byte("gadgets"))
+		require.NoError(t, err)
+		return tx.DeleteBucket([]byte("gadgets"))
+	}))
+
+	widgets := [][]byte{[]byte("widgets")}
+	nested := [][]byte{[]byte("widgets"), []byte("nested")}
+	require.Equal(t, &bolt.ChangeSet{
+		Txid: txid,
+		Changes: []bolt.Change{
+			{Type: bolt.ChangePut, Bucket: widgets, Key: []byte("foo"), Value: []byte("bar")},
+			{Type: bolt.ChangeDelete, Bucket: widgets, Key: []byte("old")},
+			{Type: bolt.ChangeDelete, Bucket: widgets, Key: []byte("cursor")},
+			{Type: bolt.ChangeCreateBucket, Bucket: widgets, Key: []byte("nested")},
+			{Type: bolt.ChangePut, Bucket: nested, Key: []byte("baz"), Value: []byte("bat")},
+			{Type: bolt.ChangeSequence, Bucket: nested, Sequence: 1},
+			{Type: bolt.ChangeSequence, Bucket: nested, Sequence: 10},
+			{Type: bolt.ChangeMoveBucket, Bucket: widgets, Key: []byte("nested"), Target: [][]byte{[]byte("moved")}},
+			{Type: bolt.ChangeCreateBucket, Key: []byte("gadgets")},
+			{Type: bolt.ChangeDeleteBucket, Key: []byte("gadgets")},
+		},
+	}, got)
+}
+
+// Ensure that DB.TrackChanges records changes made before a handler is added,
+// and that rolled back changes are not delivered.
+func TestDB_TrackChanges(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{TrackChanges: true})
+
+	var got *bolt.ChangeSet
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+
+		sp, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), []byte("bar")))
+		tx.OnCommitChanges(func(cs *bolt.ChangeSet) { t.Error("unexpected handler") })
+		require.NoError(t, tx.RollbackTo(sp))
+
+		tx.OnCommitChanges(func(cs *bolt.ChangeSet) { got = cs })
+		return nil
+	}))
+	require.Equal(t, []bolt.Change{
+		{Type: bolt.ChangeCreateBucket, Key: []byte("widgets")},
+	}, got.Changes)
+
+	// Handlers are not called when the transaction fails.
+	require.Error(t, db.Update(func(tx *bolt.Tx) error {
+		tx.OnCommitChanges(func(cs *bolt.ChangeSet) { t.Error("unexpected handler") })
+		return tx.DeleteBucket([]byte("missing"))
+	}))
+}
+
+// Ensure that a change set is marked as incomplete if changes were made before
+// the first handler was added.
+func TestTx_OnCommitChanges_Incomplete(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	var got *bolt.ChangeSet
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		tx.OnCommitChanges(func(cs *bolt.ChangeSet) { got = cs })
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+	require.True(t, got.Incomplete)
+	require.Equal(t, []bolt.Change{
+		{Type: bolt.ChangePut, Bucket: [][]byte{[]byte("widgets")}, Key: []byte("foo"), Value: []byte("bar")},
+	}, got.Changes)
+
+	// Reading before adding the handler does not make the set incomplete.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, []byte("bar"), b.Get([]byte("foo")))
+		tx.OnCommitChanges(func(cs *bolt.ChangeSet) { got = cs })
+		return b.Delete([]byte("foo"))
+	}))
+	require.False(t, got.Incomplete)
+	require.Len(t, got.Changes, 1)
+}
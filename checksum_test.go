@@ -0,0 +1,193 @@
+package bbolt_test
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that databases of format versions 3 and 4 can be written and read
+// back.
+func TestDB_PageChecksums(t *testing.T) {
+	for _, version := range []int{common.VersionChecksums, common.VersionFeatures} {
+		t.Run(fmt.Sprintf("version-%d", version), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{FormatVersion: version})
+			require.NoError(t, db.Fill([]byte("widgets"), 5, 1000,
+				func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, key)) },
+				func(tx int, key int) []byte { return make([]byte, 100) },
+			))
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.Bucket([]byte("widgets")).CreateBucket([]byte("nested"))
+				if err != nil {
+					return err
+				}
+				// A value which spans overflow pages.
+				return b.Put([]byte("large"), make([]byte, 3*db.Info().PageSize))
+			}))
+			db.MustCheck()
+			db.MustClose()
+
+			// The format version is kept when the database is reopened, even though
+			// it is not requested again.
+			db.SetOptions(nil)
+			db.MustReopen()
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("widgets")).Delete([]byte("00000000"))
+			}))
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.Equal(t, 5001, b.Stats().KeyN)
+				require.Len(t, b.Bucket([]byte("nested")).Get([]byte("large")), 3*db.Info().PageSize)
+				return nil
+			}))
+		})
+	}
+}
+
+// Ensure that reading a corrupted page returns a corruption error.
+func TestDB_PageChecksums_Corruption(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{FormatVersion: common.VersionChecksums})
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 1000,
+		func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d", key)) },
+		func(tx int, key int) []byte { return make([]byte, 100) },
+	))
+
+	// Find a leaf page of the bucket.
+	var leaf int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		for id := 2; ; id++ {
+			p, err := tx.Page(id)
+			require.NoError(t, err)
+			if p.Type == "leaf" {
+				leaf = id
+				return nil
+			}
+		}
+	}))
+	pageSize := db.Info().PageSize
+	db.MustClose()
+
+	// Flip a bit in the middle of the page.
+	f, err := os.OpenFile(db.Path(), os.O_RDWR, 0)
+	require.NoError(t, err)
+	b := make([]byte, 1)
+	off := int64(leaf*pageSize + pageSize/2)
+	_, err = f.ReadAt(b, off)
+	require.NoError(t, err)
+	b[0] ^= 0x01
+	_, err = f.WriteAt(b, off)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	db.MustReopen()
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error { return nil })
+	})
+	require.ErrorIs(t, err, common.ErrPageChecksum)
+	var cerr *common.CorruptionError
+	require.True(t, errors.As(err, &cerr))
+	require.Equal(t, common.Pgid(leaf), cerr.Pgid)
+
+	// Write transactions are rolled back.
+	err = db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error { return nil })
+	})
+	require.ErrorIs(t, err, common.ErrPageChecksum)
+	err = db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error { return nil })
+	})
+	require.True(t, errors.As(err, &cerr))
+
+	// Outside of them, cursors stop with the error and Lookup returns it.
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	c := tx.Bucket([]byte("widgets")).Cursor()
+	n := 0
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		n++
+	}
+	require.Less(t, n, 1000)
+	require.ErrorIs(t, c.Err(), common.ErrPageChecksum)
+	k, _ := c.First()
+	require.Nil(t, k)
+	var lookupErr error
+	for i := 0; i < 1000 && lookupErr == nil; i++ {
+		_, lookupErr = tx.Bucket([]byte("widgets")).Lookup([]byte(fmt.Sprintf("%04d", i)))
+	}
+	require.True(t, errors.As(lookupErr, &cerr))
+	require.Equal(t, common.Pgid(leaf), cerr.Pgid)
+	require.NoError(t, tx.Rollback())
+
+	// Check reports the corrupted page.
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		require.Len(t, errs, 1)
+		require.ErrorIs(t, errs[0], common.ErrPageChecksum)
+		return nil
+	}))
+
+	// Skip the consistency check of the test cleanup.
+	db.MustClose()
+}
+
+// Ensure that an unsupported format version is rejected.
+func TestOpen_FormatVersion_Unsupported(t *testing.T) {
+	_, err := bolt.Open(t.TempDir()+"/db", 0666, &bolt.Options{FormatVersion: common.VersionFeatures + 1})
+	require.Error(t, err)
+}
+
+// Ensure that writing a bucket with options raises the format version of both
+// meta pages, and keeps the page checksums of version 3.
+func TestDB_FormatVersion_Features(t *testing.T) {
+	for _, version := range []int{common.Version, common.VersionChecksums, common.VersionFeatures} {
+		t.Run(fmt.Sprintf("version-%d", version), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{FormatVersion: version})
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucket([]byte("plain"))
+				return err
+			}))
+			for _, m := range readMetas(t, db) {
+				require.Equal(t, uint32(version), m.Version())
+			}
+
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				_, err := tx.CreateBucketWithOptions([]byte("counted"), &bolt.BucketOptions{Counted: true})
+				return err
+			}))
+			for _, m := range readMetas(t, db) {
+				require.Equal(t, uint32(common.VersionFeatures), m.Version())
+				require.NotZero(t, m.Flags()&common.MetaBucketExtFlag)
+				require.Equal(t, version != common.Version, m.PageChecksums())
+				require.Equal(t, version == common.VersionFeatures, m.PageTxids())
+			}
+
+			db.MustClose()
+			db.MustReopen()
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				require.True(t, tx.Bucket([]byte("counted")).Options().Counted)
+				return nil
+			}))
+		})
+	}
+}
+
+// readMetas reads both meta pages of a database from its file.
+func readMetas(t *testing.T, db *btesting.DB) []*common.Meta {
+	buf, err := os.ReadFile(db.Path())
+	require.NoError(t, err)
+	pageSize := db.Info().PageSize
+	return []*common.Meta{
+		common.LoadPage(buf[:pageSize]).Meta(),
+		common.LoadPage(buf[pageSize : 2*pageSize]).Meta(),
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/guts_cli"
+)
+
+// backupCommand represents the "backup" command execution.
+type backupCommand struct {
+	baseCommand
+}
+
+// newBackupCommand returns a backupCommand.
+func newBackupCommand(m *Main) *backupCommand {
+	c := &backupCommand{}
+	c.baseCommand = m.baseCommand
+	return c
+}
+
+// Run executes the command.
+func (cmd *backupCommand) Run(args ...string) (err error) {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	dstPath := fs.String("o", "", "")
+	since := fs.Int("since", -1, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if *dstPath == "" {
+		return errors.New("output file required")
+	}
+
+	// Require database path.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	// Ensure output file does not exist.
+	if _, err := os.Stat(*dstPath); err == nil {
+		return fmt.Errorf("output file %q already exists", *dstPath)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// Increments only hold the pages which changed if the pages store the
+	// transaction which wrote them.
+	m, err := guts_cli.ReadMeta(path)
+	if err != nil {
+		return err
+	}
+	pageTxids := m.PageTxids()
+
+	// Open database.
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	f, err := os.OpenFile(*dstPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(*dstPath)
+		}
+	}()
+
+	return db.View(func(tx *bolt.Tx) error {
+		if *since < 0 {
+			if _, err := tx.WriteTo(f); err != nil {
+				return err
+			}
+		} else {
+			if !pageTxids && *since < tx.ID() {
+				fmt.Fprintf(cmd.Stderr, "warning: the pages of %s do not store the transaction which wrote them, so the increment holds every page; migrate it to format version 4 to take smaller increments\n", path)
+			}
+			if _, err := tx.WriteIncrementalTo(f, *since); err != nil {
+				return err
+			}
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(cmd.Stdout, "Txid: %d\n", tx.ID())
+		return nil
+	})
+}
+
+// Usage returns the help message.
+func (cmd *backupCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt backup [options] -o DST PATH
+
+Backup writes a copy of the database at PATH to DST and prints the
+transaction id the copy was taken at. By default DST is a full copy of the
+database that can be opened directly.
+
+Additional options include:
+
+	-since TXID
+		Write an increment holding only the pages that changed after
+		the transaction TXID instead of a full copy. Apply it on top
+		of a copy taken at TXID with "bbolt restore".
+
+Increments only hold the pages that changed in databases of format version
+4, whose pages store the transaction which wrote them. In other databases
+page writes are only tracked in memory by the process keeping the database
+open, so an increment taken by this command holds every page. Use "bbolt
+migrate -version 4" to convert a database.
+`, "\n")
+}
+
+// restoreCommand represents the "restore" command execution.
+type restoreCommand struct {
+	baseCommand
+}
+
+// newRestoreCommand returns a restoreCommand.
+func newRestoreCommand(m *Main) *restoreCommand {
+	c := &restoreCommand{}
+	c.baseCommand = m.baseCommand
+	return c
+}
+
+// Run executes the command.
+func (cmd *restoreCommand) Run(args ...string) (err error) {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	dstPath := fs.String("o", "", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if *dstPath == "" {
+		return errors.New("output file required")
+	}
+
+	// Require the path of the full backup.
+	basePath := fs.Arg(0)
+	if basePath == "" {
+		return ErrPathRequired
+	}
+
+	// Start from a copy of the full backup and apply increments in order.
+	if err := copyFile(basePath, *dstPath); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(*dstPath)
+		}
+	}()
+
+	for _, path := range fs.Args()[1:] {
+		if err := applyIncrementFile(*dstPath, path); err != nil {
+			return fmt.Errorf("apply %q: %w", path, err)
+		}
+	}
+
+	// Report the transaction the restored database is at.
+	db, err := bolt.Open(*dstPath, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		fmt.Fprintf(cmd.Stdout, "Txid: %d\n", tx.ID())
+		return nil
+	})
+}
+
+func applyIncrementFile(dbPath, path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ErrFileNotFound
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return bolt.ApplyIncrement(dbPath, f)
+}
+
+// Usage returns the help message.
+func (cmd *restoreCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt restore -o DST BASE [INCREMENT...]
+
+Restore copies the full backup at BASE to DST and applies each INCREMENT
+written by "bbolt backup -since" on top of it, in the order given. Each
+increment must have been taken since the transaction the previous one
+brings the database to.
+
+BASE is left untouched.
+`, "\n")
+}
@@ -0,0 +1,96 @@
+package main_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that "restore" rebuilds a database from the output of "backup",
+// chaining a full copy with increments.
+func TestBackupRestoreCommand_Run(t *testing.T) {
+	for _, version := range []int{common.Version, common.VersionFeatures} {
+		t.Run(fmt.Sprintf("version-%d", version), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{FormatVersion: version})
+			dir := t.TempDir()
+
+			put := func(key string) {
+				require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+					b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+					if err != nil {
+						return err
+					}
+					return b.Put([]byte(key), []byte("value-"+key))
+				}))
+			}
+			backup := func(args ...string) (int, string) {
+				db.MustClose()
+				defer db.MustReopen()
+
+				m := NewMain()
+				require.NoError(t, m.Run(append([]string{"backup"}, append(args, db.Path())...)...))
+				txid, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(m.Stdout.String(), "Txid:")))
+				require.NoError(t, err)
+				return txid, m.Stderr.String()
+			}
+
+			require.NoError(t, db.Fill([]byte("widgets"), 10, 100,
+				func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, key)) },
+				func(tx int, key int) []byte { return make([]byte, 100) },
+			))
+			full := filepath.Join(dir, "full")
+			txid, _ := backup("-o", full)
+
+			var increments []string
+			for i := 0; i < 2; i++ {
+				put(fmt.Sprintf("bar%d", i))
+				inc := filepath.Join(dir, fmt.Sprintf("inc%d", i))
+				var stderr string
+				txid, stderr = backup("-since", strconv.Itoa(txid), "-o", inc)
+				increments = append(increments, inc)
+
+				// Increments only hold the changed pages if the pages store
+				// the transaction which wrote them.
+				fullInfo, err := os.Stat(full)
+				require.NoError(t, err)
+				incInfo, err := os.Stat(inc)
+				require.NoError(t, err)
+				if version == common.VersionFeatures {
+					require.Empty(t, stderr)
+					require.Less(t, incInfo.Size(), fullInfo.Size()/4)
+				} else {
+					require.Contains(t, stderr, "holds every page")
+					require.Greater(t, incInfo.Size(), fullInfo.Size())
+				}
+			}
+
+			dst := filepath.Join(dir, "restored")
+			m := NewMain()
+			require.NoError(t, m.Run(append([]string{"restore", "-o", dst, full}, increments...)...))
+			require.Equal(t, fmt.Sprintf("Txid: %d\n", txid), m.Stdout.String())
+
+			restored := btesting.MustOpenDBWithOption(t, dst, nil)
+			require.NoError(t, restored.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				for _, k := range []string{"00000000", "bar0", "bar1"} {
+					require.NotNil(t, b.Get([]byte(k)), k)
+				}
+				require.Equal(t, []byte("value-bar1"), b.Get([]byte("bar1")))
+				return nil
+			}))
+
+			// Increments must be applied in order.
+			m = NewMain()
+			require.Error(t, m.Run("restore", "-o", filepath.Join(dir, "bad"), full, increments[1]))
+		})
+	}
+}
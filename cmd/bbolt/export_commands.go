@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// exportCommand represents the "export" command execution.
+type exportCommand struct {
+	baseCommand
+}
+
+// newExportCommand returns an exportCommand.
+func newExportCommand(m *Main) *exportCommand {
+	c := &exportCommand{}
+	c.baseCommand = m.baseCommand
+	return c
+}
+
+// Run executes the command.
+func (cmd *exportCommand) Run(args ...string) (err error) {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	dstPath := fs.String("o", "", "")
+	formatName := fs.String("format", "json", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	var format bolt.ExportFormat
+	switch *formatName {
+	case "json":
+		format = bolt.ExportJSON
+	case "binary":
+		format = bolt.ExportBinary
+	default:
+		return fmt.Errorf("unknown format %q", *formatName)
+	}
+
+	// Require database path.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	} else if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	// Open database.
+	db, err := bolt.Open(path, 0666, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Write to stdout unless an output file is given.
+	w := cmd.Stdout
+	if *dstPath != "" {
+		f, err := os.OpenFile(*dstPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				_ = os.Remove(*dstPath)
+			}
+		}()
+		w = f
+	}
+
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.Export(w, format)
+	})
+}
+
+// Usage returns the help message.
+func (cmd *exportCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt export [options] PATH
+
+Export writes a logical dump of the buckets, sequences and key/value pairs
+of the database at PATH, which can be loaded back with "bbolt import". The
+dump does not depend on the data file format and can be read by other
+programs.
+
+Additional options include:
+
+	-format json|binary
+		Writes the dump as JSON lines, with base64 encoded names,
+		keys and values, or in a compact binary format.
+		Defaults to json.
+
+	-o DST
+		Writes the dump to the new file DST instead of stdout.
+`, "\n")
+}
+
+// importCommand represents the "import" command execution.
+type importCommand struct {
+	baseCommand
+}
+
+// newImportCommand returns an importCommand.
+func newImportCommand(m *Main) *importCommand {
+	c := &importCommand{}
+	c.baseCommand = m.baseCommand
+	return c
+}
+
+// Run executes the command.
+func (cmd *importCommand) Run(args ...string) error {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	srcPath := fs.String("i", "", "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	}
+
+	// Require database path.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	}
+
+	// Read from stdin unless an input file is given.
+	r := cmd.Stdin
+	if *srcPath != "" {
+		f, err := os.Open(*srcPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	} else if r == nil {
+		return errors.New("input file required")
+	}
+
+	// Open or create the database.
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return err
+	}
+	if err := db.Import(r); err != nil {
+		_ = db.Close()
+		return err
+	}
+	return db.Close()
+}
+
+// Usage returns the help message.
+func (cmd *importCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bolt import [options] PATH
+
+Import loads a dump written by "bbolt export", in either format, into the
+database at PATH, which is created if it does not exist. Buckets are
+created as needed and the key/value pairs of the dump overwrite existing
+ones.
+
+Additional options include:
+
+	-i SRC
+		Reads the dump from SRC instead of stdin.
+`, "\n")
+}
@@ -0,0 +1,53 @@
+package main_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// Ensure that "import" loads the output of "export" into a new database.
+func TestExportImportCommand_Run(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		if err := b.SetSequence(7); err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+	db.Close()
+	defer requireDBNoChange(t, dbData(t, db.Path()), db.Path())
+
+	for _, format := range []string{"json", "binary"} {
+		dir := t.TempDir()
+		dump := filepath.Join(dir, "dump")
+		m := NewMain()
+		require.NoError(t, m.Run("export", "-format", format, "-o", dump, db.Path()))
+
+		dst := filepath.Join(dir, "imported")
+		m = NewMain()
+		require.NoError(t, m.Run("import", "-i", dump, dst))
+
+		imported := btesting.MustOpenDBWithOption(t, dst, nil)
+		require.NoError(t, imported.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			require.Equal(t, uint64(7), b.Sequence())
+			require.Equal(t, []byte("bar"), b.Get([]byte("foo")))
+			return nil
+		}))
+		imported.Close()
+	}
+
+	// The dump is written to stdout by default.
+	m := NewMain()
+	require.NoError(t, m.Run("export", db.Path()))
+	require.Contains(t, m.Stdout.String(), `"type":"kv"`)
+}
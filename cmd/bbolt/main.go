@@ -101,6 +101,8 @@ func (m *Main) Run(args ...string) error {
 	case "help":
 		fmt.Fprintln(m.Stderr, m.Usage())
 		return ErrUsage
+	case "backup":
+		return newBackupCommand(m).Run(args[1:]...)
 	case "bench":
 		return newBenchCommand(m).Run(args[1:]...)
 	case "buckets":
@@ -111,18 +113,26 @@ func (m *Main) Run(args ...string) error {
 		return newCompactCommand(m).Run(args[1:]...)
 	case "dump":
 		return newDumpCommand(m).Run(args[1:]...)
+	case "export":
+		return newExportCommand(m).Run(args[1:]...)
 	case "page-item":
 		return newPageItemCommand(m).Run(args[1:]...)
 	case "get":
 		return newGetCommand(m).Run(args[1:]...)
+	case "import":
+		return newImportCommand(m).Run(args[1:]...)
 	case "info":
 		return newInfoCommand(m).Run(args[1:]...)
 	case "keys":
 		return newKeysCommand(m).Run(args[1:]...)
+	case "migrate":
+		return newMigrateCommand(m).Run(args[1:]...)
 	case "page":
 		return newPageCommand(m).Run(args[1:]...)
 	case "pages":
 		return newPagesCommand(m).Run(args[1:]...)
+	case "restore":
+		return newRestoreCommand(m).Run(args[1:]...)
 	case "stats":
 		return newStatsCommand(m).Run(args[1:]...)
 	case "surgery":
@@ -143,18 +153,23 @@ Usage:
 
 The commands are:
 
+    backup      write a full or incremental backup of a bbolt database
     bench       run synthetic benchmark against bbolt
     buckets     print a list of buckets
     check       verifies integrity of bbolt database
     compact     copies a bbolt database, compacting it in the process
     dump        print a hexadecimal dump of a single page
+    export      write a logical dump of a bbolt database
     get         print the value of a key in a bucket
+    import      load a logical dump into a bbolt database
     info        print basic info
     keys        print a list of keys in a bucket
     help        print this screen
+    migrate     copies a bbolt database into another data file format version
     page        print one or more pages in human readable format
     pages       print list of pages with their types
     page-item   print the key and value of a page item.
+    restore     restore a database from a full backup and its increments
     stats       iterate over all pages and generate usage stats
     surgery     perform surgery on bbolt database
 
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// migrateCommand represents the "migrate" command execution.
+type migrateCommand struct {
+	baseCommand
+}
+
+// newMigrateCommand returns a migrateCommand.
+func newMigrateCommand(m *Main) *migrateCommand {
+	c := &migrateCommand{}
+	c.baseCommand = m.baseCommand
+	return c
+}
+
+// Run executes the command.
+func (cmd *migrateCommand) Run(args ...string) (err error) {
+	// Parse flags.
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	help := fs.Bool("h", false, "")
+	dstPath := fs.String("o", "", "")
+	version := fs.Int("version", common.VersionChecksums, "")
+	txMaxSize := fs.Int64("tx-max-size", 65536, "")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *help {
+		fmt.Fprintln(cmd.Stderr, cmd.Usage())
+		return ErrUsage
+	} else if *dstPath == "" {
+		return errors.New("output file required")
+	}
+
+	// Require database path.
+	path := fs.Arg(0)
+	if path == "" {
+		return ErrPathRequired
+	}
+	fi, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return ErrFileNotFound
+	} else if err != nil {
+		return err
+	}
+
+	// Ensure output file does not exist.
+	if _, err := os.Stat(*dstPath); err == nil {
+		return fmt.Errorf("output file %q already exists", *dstPath)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// Open source database.
+	src, err := bolt.Open(path, 0444, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Create the destination database in the requested format version, with
+	// the page size of the source.
+	dst, err := bolt.Open(*dstPath, fi.Mode(), &bolt.Options{
+		FormatVersion: *version,
+		PageSize:      src.Info().PageSize,
+	})
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := dst.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			_ = os.Remove(*dstPath)
+		}
+	}()
+
+	// Copy all buckets and keys.
+	if err := bolt.Compact(dst, src, *txMaxSize); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.Stdout, "migrated %s to format version %d at %s\n", path, *version, *dstPath)
+	return nil
+}
+
+// Usage returns the help message.
+func (cmd *migrateCommand) Usage() string {
+	return strings.TrimLeft(`
+usage: bbolt migrate [options] -o DST SRC
+
+Migrate copies the database at SRC path to a new database at DST path, which
+is created in another data file format version. Format version 3 stores a
+checksum in every page so that corrupted pages are detected when they are
+read, and format version 4 also stores the transaction which wrote every
+page, so that "bbolt backup -since" only copies the pages that changed;
+format version 2 is readable by older versions of bbolt. Databases
+using features which older versions of bbolt can not read, such as buckets
+with options, are written in format version 4 instead, with checksums if
+version 3 is requested.
+
+The original database is left untouched.
+
+Additional options include:
+
+	-version NUM
+		Specifies the format version of the new database, 2, 3 or 4.
+		Defaults to 3.
+
+	-tx-max-size NUM
+		Specifies the maximum size of individual transactions.
+		Defaults to 64KB.
+`, "\n")
+}
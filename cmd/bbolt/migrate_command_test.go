@@ -0,0 +1,51 @@
+package main_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+	"go.etcd.io/bbolt/internal/guts_cli"
+)
+
+// Ensure that "migrate" copies a database between format versions.
+func TestMigrateCommand_Run(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+	db.Close()
+	defer requireDBNoChange(t, dbData(t, db.Path()), db.Path())
+
+	dir := t.TempDir()
+	v3 := filepath.Join(dir, "v3")
+	m := NewMain()
+	require.NoError(t, m.Run("migrate", "-o", v3, db.Path()))
+	v2 := filepath.Join(dir, "v2")
+	m = NewMain()
+	require.NoError(t, m.Run("migrate", "-version", "2", "-o", v2, v3))
+
+	for path, version := range map[string]int{v3: common.VersionChecksums, v2: common.Version} {
+		_, buf, err := guts_cli.ReadPage(path, 0)
+		require.NoError(t, err)
+		require.Equal(t, uint32(version), common.LoadPageMeta(buf).Version())
+
+		mdb := btesting.MustOpenDBWithOption(t, path, nil)
+		require.NoError(t, mdb.View(func(tx *bolt.Tx) error {
+			require.Equal(t, []byte("bar"), tx.Bucket([]byte("widgets")).Get([]byte("foo")))
+			return nil
+		}))
+	}
+
+	// The output file must not exist.
+	m = NewMain()
+	require.Error(t, m.Run("migrate", "-o", v2, db.Path()))
+}
@@ -0,0 +1,191 @@
+package bbolt
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Codec compresses the values of a bucket. The codec of a bucket is set when
+// the bucket is created, with BucketOptions, and is identified by its ID in
+// the bucket header. A codec must be registered with RegisterCodec before a
+// bucket using it can be opened.
+type Codec interface {
+	// ID identifies the codec in the headers of the buckets using it. It
+	// must be non-zero and must never change once data is written with
+	// the codec. IDs up to 127 are reserved for codecs provided by bbolt.
+	ID() uint8
+
+	// Compress appends the compressed form of src to dst and returns the
+	// extended buffer.
+	Compress(dst, src []byte) ([]byte, error)
+
+	// Decompress appends the decompressed form of src to dst and returns
+	// the extended buffer.
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+var (
+	// FlateCodec compresses values with DEFLATE, at the default compression
+	// level.
+	FlateCodec Codec = flateCodec{}
+
+	// GzipCodec compresses values in the gzip format, at the default
+	// compression level.
+	GzipCodec Codec = gzipCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[uint8]Codec{
+		FlateCodec.ID(): FlateCodec,
+		GzipCodec.ID():  GzipCodec,
+	}
+)
+
+// RegisterCodec makes a codec available to the buckets using it. It panics if
+// the ID of the codec is zero or already registered.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	id := c.ID()
+	if id == 0 {
+		panic("bbolt: codec id must be non-zero")
+	} else if _, ok := codecs[id]; ok {
+		panic(fmt.Sprintf("bbolt: codec %d registered twice", id))
+	}
+	codecs[id] = c
+}
+
+// lookupCodec returns the registered codec with the given id, or nil.
+func lookupCodec(id uint8) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	return codecs[id]
+}
+
+// compressValue compresses a value with codec c. Compressed values are
+// prefixed with their uncompressed size. The value is returned unchanged,
+// with zero flags, if it does not get smaller.
+func compressValue(c Codec, v []byte) ([]byte, uint32, error) {
+	if len(v) == 0 {
+		return v, 0, nil
+	}
+	buf := binary.AppendUvarint(make([]byte, 0, len(v)), uint64(len(v)))
+	buf, err := c.Compress(buf, v)
+	if err != nil {
+		return nil, 0, err
+	} else if len(buf) >= len(v) {
+		return v, 0, nil
+	}
+	return buf, common.CompressedLeafFlag, nil
+}
+
+// decompressValue returns the uncompressed form of a value compressed by
+// compressValue.
+func decompressValue(c Codec, v []byte) ([]byte, error) {
+	size, n := binary.Uvarint(v)
+	if n <= 0 || size > MaxValueSize {
+		return nil, fmt.Errorf("invalid compressed value size")
+	}
+	buf, err := c.Decompress(make([]byte, 0, size), v[n:])
+	if err != nil {
+		return nil, err
+	} else if uint64(len(buf)) != size {
+		return nil, fmt.Errorf("decompressed value size %d, expected %d", len(buf), size)
+	}
+	return buf, nil
+}
+
+// valueSize returns the uncompressed size of a value with the given flags,
+// without the expiry time of an expiring value.
+func valueSize(v []byte, flags uint32) int {
+	if flags&common.ExpiringLeafFlag != 0 {
+		v = v[8:]
+	}
+	if flags&common.CompressedLeafFlag == 0 {
+		return len(v)
+	}
+	size, _ := binary.Uvarint(v)
+	return int(size)
+}
+
+type flateCodec struct{}
+
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+func (flateCodec) ID() uint8 { return 1 }
+
+func (flateCodec) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decompress(dst, src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return readAll(dst, r)
+}
+
+type gzipCodec struct{}
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+func (gzipCodec) ID() uint8 { return 2 }
+
+func (gzipCodec) Compress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(dst, src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readAll(dst, r)
+}
+
+// readAll appends everything read from r to dst.
+func readAll(dst []byte, r io.Reader) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
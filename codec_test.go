@@ -0,0 +1,132 @@
+package bbolt_test
+
+import (
+	"crypto/rand"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that values of a bucket with a codec are compressed, and read back
+// decompressed.
+func TestBucket_Codec(t *testing.T) {
+	for _, codec := range []bolt.Codec{bolt.FlateCodec, bolt.GzipCodec} {
+		t.Run(fmt.Sprintf("codec-%d", codec.ID()), func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+
+			random := make([]byte, 200)
+			_, err := rand.Read(random)
+			require.NoError(t, err)
+			values := map[string][]byte{
+				"empty":  {},
+				"random": random,
+			}
+			for i := 0; i < 100; i++ {
+				values[fmt.Sprintf("json%03d", i)] = []byte(strings.Repeat(fmt.Sprintf(`{"id":%d,"name":"widget"},`, i), 50))
+			}
+
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Codec: codec})
+				if err != nil {
+					return err
+				}
+				require.Equal(t, codec, b.Options().Codec)
+				for k, v := range values {
+					if err := b.Put([]byte(k), v); err != nil {
+						return err
+					}
+				}
+				require.Equal(t, values["json000"], b.Get([]byte("json000")))
+
+				// Nested buckets are not compressed.
+				_, err = b.CreateBucket([]byte("sub"))
+				return err
+			}))
+			db.MustCheck()
+			db.MustClose()
+			db.MustReopen()
+
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.Equal(t, codec, b.Options().Codec)
+				for k, v := range values {
+					require.Equal(t, v, b.Get([]byte(k)), k)
+				}
+				require.NotNil(t, b.Bucket([]byte("sub")))
+
+				var n int
+				c := b.Cursor()
+				for k, v := c.First(); k != nil; k, v = c.Next() {
+					if string(k) != "sub" {
+						require.Equal(t, values[string(k)], v, string(k))
+					}
+					n++
+				}
+				require.Equal(t, len(values)+1, n)
+				k, v := c.Seek([]byte("json050"))
+				require.Equal(t, []byte("json050"), k)
+				require.Equal(t, values["json050"], v)
+
+				var logical int
+				for _, v := range values {
+					logical += len(v)
+				}
+				s := b.Stats()
+				require.Equal(t, logical, s.LogicalValueBytes)
+				require.Less(t, s.PhysicalValueBytes, logical/5)
+				return nil
+			}))
+		})
+	}
+}
+
+// Ensure that buckets can only be created with registered codecs.
+func TestBucket_CreateBucketWithOptions_UnregisteredCodec(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Codec: testCodec{}})
+		require.ErrorIs(t, err, common.ErrUnknownCodec)
+		return nil
+	}))
+}
+
+// Ensure that Compact keeps the codec of the buckets.
+func TestCompact_Codec(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	value := []byte(strings.Repeat("widget", 100))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Codec: bolt.FlateCodec})
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), value)
+	}))
+
+	dst, err := bolt.Open(filepath.Join(t.TempDir(), "compacted"), 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, bolt.Compact(dst, db.DB, 0))
+
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, bolt.FlateCodec, b.Options().Codec)
+		require.Equal(t, value, b.Get([]byte("foo")))
+		require.Less(t, b.Stats().PhysicalValueBytes, len(value))
+		return nil
+	}))
+}
+
+type testCodec struct{}
+
+func (testCodec) ID() uint8 { return 200 }
+
+func (testCodec) Compress(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+
+func (testCodec) Decompress(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
@@ -1,7 +1,12 @@
 package bbolt
 
+import (
+	"go.etcd.io/bbolt/internal/common"
+)
+
 // Compact will create a copy of the source DB and in the destination DB. This may
-// reclaim space that the source database no longer has use for. txMaxSize can be
+// reclaim space that the source database no longer has use for. The entries of
+// the indexes and the expiry times of the keys are copied. txMaxSize can be
 // used to limit the transactions size of this process and may trigger intermittent
 // commits. A value of zero will ignore transaction sizes.
 // TODO: merge with: https://github.com/etcd-io/etcd/blob/b7f0f52a16dbf83f18ca1d803f7892d750366a94/mvcc/backend/backend.go#L349
@@ -18,7 +23,15 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 		}
 	}()
 
-	if err := walk(src, func(keys [][]byte, k, v []byte, seq uint64) error {
+	// A database created by an earlier version which has buckets using the
+	// names of the internal buckets is compacted into one which does not
+	// reserve them either.
+	if !src.reservedNames && dst.reservedNames {
+		dst.reservedNames = false
+		tx.meta.SetFlags(tx.meta.Flags() &^ common.MetaReservedNamesFlag)
+	}
+
+	if err := walk(src, func(keys [][]byte, k, v []byte, expires int64, seq uint64, opts BucketOptions) error {
 		// On each key/value, check if we have exceeded tx size.
 		sz := int64(len(k) + len(v))
 		if size+sz > txMaxSize && txMaxSize != 0 {
@@ -37,9 +50,11 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 		size += sz
 
 		// Create bucket on the root transaction if this is the first level.
+		// The buckets holding the indexes are created in the root bucket
+		// directly, as their names are reserved.
 		nk := len(keys)
 		if nk == 0 {
-			bkt, err := tx.CreateBucket(k)
+			bkt, err := tx.root.CreateBucketWithOptions(k, &opts)
 			if err != nil {
 				return err
 			}
@@ -50,7 +65,7 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 		}
 
 		// Create buckets on subsequent levels, if necessary.
-		b := tx.Bucket(keys[0])
+		b := tx.root.Bucket(keys[0])
 		if nk > 1 {
 			for _, k := range keys[1:] {
 				b = b.Bucket(k)
@@ -62,7 +77,7 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 
 		// If there is no value then this is a bucket call.
 		if v == nil {
-			bkt, err := b.CreateBucket(k)
+			bkt, err := b.CreateBucketWithOptions(k, &opts)
 			if err != nil {
 				return err
 			}
@@ -72,8 +87,8 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 			return nil
 		}
 
-		// Otherwise treat it as a key/value pair.
-		return b.Put(k, v)
+		// Otherwise treat it as a key/value pair, which keeps its expiry time.
+		return b.put(k, v, expires)
 	}); err != nil {
 		return err
 	}
@@ -84,21 +99,42 @@ func Compact(dst, src *DB, txMaxSize int64) error {
 
 // walkFunc is the type of the function called for keys (buckets and "normal"
 // values) discovered by Walk. keys is the list of keys to descend to the bucket
-// owning the discovered key/value pair k/v.
-type walkFunc func(keys [][]byte, k, v []byte, seq uint64) error
+// owning the discovered key/value pair k/v, and expires its expiry time in
+// nanoseconds since the epoch, or 0 if it has none. Expired keys which are not
+// swept yet are included, as the indexes hold them too. For buckets, seq and
+// opts are the sequence and options of the bucket.
+type walkFunc func(keys [][]byte, k, v []byte, expires int64, seq uint64, opts BucketOptions) error
 
 // walk walks recursively the bolt database db, calling walkFn for each key it finds.
 func walk(db *DB, walkFn walkFunc) error {
 	return db.View(func(tx *Tx) error {
-		return tx.ForEach(func(name []byte, b *Bucket) error {
-			return walkBucket(b, nil, name, nil, b.Sequence(), walkFn)
-		})
+		return walkTx(tx, walkFn)
 	})
 }
 
-func walkBucket(b *Bucket, keypath [][]byte, k, v []byte, seq uint64, fn walkFunc) error {
+// walkTx walks recursively the buckets of tx, calling walkFn for each key it
+// finds. The buckets holding the indexes are walked like the other buckets, but
+// not the expiry index, which is rebuilt from the expiry times of the keys.
+func walkTx(tx *Tx, walkFn walkFunc) error {
+	c := tx.root.Cursor()
+	for k, _, flags := c.first(); k != nil; k, _, flags = c.next() {
+		if flags&common.BucketLeafFlag == 0 || tx.isReservedName(k) && !isIndexBucket(k) {
+			continue
+		}
+		b, err := tx.root.bucket(k)
+		if err != nil {
+			return err
+		}
+		if err := walkBucket(b, nil, k, nil, 0, b.Sequence(), walkFn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkBucket(b *Bucket, keypath [][]byte, k, v []byte, expires int64, seq uint64, fn walkFunc) error {
 	// Execute callback.
-	if err := fn(keypath, k, v, seq); err != nil {
+	if err := fn(keypath, k, v, expires, seq, b.Options()); err != nil {
 		return err
 	}
 
@@ -107,13 +143,29 @@ func walkBucket(b *Bucket, keypath [][]byte, k, v []byte, seq uint64, fn walkFun
 		return nil
 	}
 
-	// Iterate over each child key/value.
+	// Iterate over each child key/value, reading the expiry times which
+	// the public cursor methods strip from the values.
 	keypath = append(keypath, k)
-	return b.ForEach(func(k, v []byte) error {
-		if v == nil {
-			bkt := b.Bucket(k)
-			return walkBucket(bkt, keypath, k, nil, bkt.Sequence(), fn)
+	c := b.Cursor()
+	for k, v, flags := c.first(); k != nil; k, v, flags = c.next() {
+		if flags&common.BucketLeafFlag != 0 {
+			bkt, err := b.bucket(k)
+			if err != nil {
+				return err
+			}
+			if err := walkBucket(bkt, keypath, k, nil, 0, bkt.Sequence(), fn); err != nil {
+				return err
+			}
+			continue
 		}
-		return walkBucket(b, keypath, k, v, b.Sequence(), fn)
-	})
+
+		var expires int64
+		if flags&common.ExpiringLeafFlag != 0 {
+			expires = expiresAt(v)
+		}
+		if err := walkBucket(b, keypath, k, c.value(v, flags), expires, b.Sequence(), fn); err != nil {
+			return err
+		}
+	}
+	return nil
 }
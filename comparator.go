@@ -0,0 +1,63 @@
+package bbolt
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
+// Comparator orders the keys of a bucket. The comparator of a bucket is set
+// when the bucket is created, with BucketOptions, and is identified by its
+// name in the bucket header. A comparator must be registered with
+// RegisterComparator before a bucket using it can be opened. Creating a bucket
+// with a comparator raises the format version of the database to 4, so that
+// older versions of bbolt, which order every bucket with bytes.Compare, refuse
+// to open it.
+type Comparator interface {
+	// Name identifies the comparator in the headers of the buckets using
+	// it. It must be between 1 and 255 bytes long, and the order of the
+	// comparator must never change once data is written with it.
+	Name() string
+
+	// Compare returns a negative number if a sorts before b, a positive
+	// number if a sorts after b, and zero if a and b are the same key. It
+	// must return zero only if a and b are equal byte slices.
+	Compare(a, b []byte) int
+}
+
+var (
+	comparatorsMu sync.RWMutex
+	comparators   = map[string]Comparator{}
+)
+
+// RegisterComparator makes a comparator available to the buckets using it. It
+// panics if the name of the comparator is invalid or already registered.
+func RegisterComparator(c Comparator) {
+	comparatorsMu.Lock()
+	defer comparatorsMu.Unlock()
+
+	name := c.Name()
+	if len(name) == 0 || len(name) > 255 {
+		panic(fmt.Sprintf("bbolt: invalid comparator name %q", name))
+	} else if _, ok := comparators[name]; ok {
+		panic(fmt.Sprintf("bbolt: comparator %q registered twice", name))
+	}
+	comparators[name] = c
+}
+
+// lookupComparator returns the registered comparator with the given name, or
+// nil.
+func lookupComparator(name string) Comparator {
+	comparatorsMu.RLock()
+	defer comparatorsMu.RUnlock()
+	return comparators[name]
+}
+
+// compare orders two keys of the bucket, with the comparator of the bucket or
+// bytes.Compare if it has none.
+func (b *Bucket) compare(x, y []byte) int {
+	if b.cmp == nil {
+		return bytes.Compare(x, y)
+	}
+	return b.cmp.Compare(x, y)
+}
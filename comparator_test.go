@@ -0,0 +1,120 @@
+package bbolt_test
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+func init() {
+	bolt.RegisterComparator(uint64Comparator{})
+}
+
+// Ensure that the keys of a bucket with a comparator are ordered by it through
+// inserts, splits, deletes and reopening the database.
+func TestBucket_Comparator(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	rng := rand.New(rand.NewSource(1))
+
+	key := func(v uint64) []byte {
+		return binary.LittleEndian.AppendUint64(nil, v)
+	}
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Comparator: uint64Comparator{}})
+		require.NoError(t, err)
+		require.Equal(t, uint64Comparator{}, b.Options().Comparator)
+		for _, i := range rng.Perm(10000) {
+			require.NoError(t, b.Put(key(uint64(i)*1000), []byte("value")))
+		}
+		for i := 0; i < 10000; i += 2 {
+			require.NoError(t, b.Delete(key(uint64(i)*1000)))
+		}
+		_, _, err = b.DeleteRange(key(5000*1000), key(6000*1000))
+		return err
+	}))
+	db.MustCheck()
+
+	// Older versions of bbolt, which would search the bucket with
+	// bytes.Compare, refuse to open the database.
+	for _, m := range readMetas(t, db) {
+		require.Equal(t, uint32(common.VersionFeatures), m.Version())
+		require.NotZero(t, m.Flags()&common.MetaBucketExtFlag)
+	}
+	db.MustClose()
+	db.MustReopen()
+
+	check := func(db *bolt.DB) {
+		require.NoError(t, db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			require.Equal(t, uint64Comparator{}, b.Options().Comparator)
+			require.Equal(t, []byte("value"), b.Get(key(1000)))
+			require.Nil(t, b.Get(key(5001*1000)))
+
+			var n int
+			prev := uint64(0)
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				v := binary.LittleEndian.Uint64(k)
+				require.True(t, v > prev || n == 0, "%d after %d", v, prev)
+				prev = v
+				n++
+			}
+			require.Equal(t, 4500, n)
+
+			// Keys which are not in the bucket are sought by the comparator.
+			k, _ := c.Seek(key(5000*1000 + 1))
+			require.Equal(t, key(6001*1000), k)
+			return nil
+		}))
+	}
+	check(db.DB)
+
+	// Compact keeps the comparator of the buckets.
+	dst, err := bolt.Open(filepath.Join(t.TempDir(), "compacted"), 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, bolt.Compact(dst, db.DB, 0))
+	check(dst)
+}
+
+// Ensure that a bucket can not be created with a comparator which is not
+// registered.
+func TestBucket_CreateBucketWithOptions_UnregisteredComparator(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketWithOptions([]byte("widgets"), &bolt.BucketOptions{Comparator: reverseComparator{}})
+		require.ErrorIs(t, err, common.ErrUnknownComparator)
+		return nil
+	}))
+}
+
+// uint64Comparator orders keys holding little endian uint64 values.
+type uint64Comparator struct{}
+
+func (uint64Comparator) Name() string { return "test-uint64le" }
+
+func (uint64Comparator) Compare(a, b []byte) int {
+	x, y := binary.LittleEndian.Uint64(a), binary.LittleEndian.Uint64(b)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// reverseComparator is never registered.
+type reverseComparator struct{}
+
+func (reverseComparator) Name() string { return "test-reverse" }
+
+func (reverseComparator) Compare(a, b []byte) int { return -1 }
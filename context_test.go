@@ -0,0 +1,141 @@
+package bbolt_test
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// Ensure that OpenContext stops waiting for the file lock when ctx is done.
+func TestOpenContext_Timeout(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := bolt.OpenContext(ctx, db.Path(), 0666, nil)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+// Ensure that a write transaction stops waiting for the writer lock when ctx
+// is done.
+func TestDB_BeginContext_Writer(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = db.BeginContext(ctx, true)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorIs(t, db.UpdateContext(ctx, func(*bolt.Tx) error {
+		t.Fatal("unexpected call")
+		return nil
+	}), context.DeadlineExceeded)
+	require.NoError(t, tx.Rollback())
+
+	// A cancelled context never starts a transaction.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, db.ViewContext(ctx, func(*bolt.Tx) error {
+		t.Fatal("unexpected call")
+		return nil
+	}), context.Canceled)
+}
+
+// Ensure that UpdateContext does not commit once ctx is done.
+func TestDB_UpdateContext_Cancel(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.ErrorIs(t, db.UpdateContext(ctx, func(tx *bolt.Tx) error {
+		require.Equal(t, ctx, tx.Context())
+		_, err := tx.CreateBucket([]byte("widgets"))
+		cancel()
+		return err
+	}), context.Canceled)
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("widgets")))
+		return nil
+	}))
+}
+
+// Ensure that cursors stop at a page boundary when ctx is done.
+func TestCursor_Context(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 10000,
+		func(tx int, key int) []byte { return []byte(fmt.Sprintf("%08d", key)) },
+		func(tx int, key int) []byte { return make([]byte, 100) },
+	))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, db.ViewContext(ctx, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+
+		var n int
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if n++; n == 10 {
+				cancel()
+			}
+		}
+		require.ErrorIs(t, c.Err(), context.Canceled)
+		require.Greater(t, n, 10)
+		require.Less(t, n, 10000)
+
+		k, v := c.Seek([]byte("00000000"))
+		require.Nil(t, k)
+		require.Nil(t, v)
+
+		require.ErrorIs(t, b.ForEach(func(k, v []byte) error { return nil }), context.Canceled)
+		return nil
+	}))
+}
+
+// Ensure that a batch does not run a function whose caller gave up waiting.
+func TestDB_BatchContext_Abandoned(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}))
+
+	// Hold the writer lock so the batch can not run yet.
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var called int32
+	err = db.BatchContext(ctx, func(tx *bolt.Tx) error {
+		atomic.StoreInt32(&called, 1)
+		return nil
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	done := make(chan error)
+	go func() {
+		done <- db.Batch(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+		})
+	}()
+	require.NoError(t, tx.Rollback())
+	require.NoError(t, <-done)
+	require.Zero(t, atomic.LoadInt32(&called))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("bar"), tx.Bucket([]byte("widgets")).Get([]byte("foo")))
+		return nil
+	}))
+}
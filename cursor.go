@@ -1,7 +1,6 @@
 package bbolt
 
 import (
-	"bytes"
 	"fmt"
 	"sort"
 
@@ -14,13 +13,20 @@ import (
 // Cursors can be obtained from a transaction and are valid as long as the transaction is open.
 //
 // Keys and values returned from the cursor are only valid for the life of the transaction.
+// Values of buckets with a codec are decompressed into newly allocated slices.
 //
 // Changing data while traversing with a cursor may cause it to be invalidated
 // and return unexpected keys and/or values. You must reposition your cursor
 // after mutating data.
+//
+// If the transaction was started with a context, the cursor stops at the next
+// page boundary once the context is done. It then returns a nil key and value,
+// and Err returns the context's error. Likewise, a cursor which reads a
+// corrupted page stops, and Err returns the CorruptionError.
 type Cursor struct {
 	bucket *Bucket
 	stack  []elemRef
+	err    error
 }
 
 // Bucket returns the bucket that this cursor was created from.
@@ -28,16 +34,82 @@ func (c *Cursor) Bucket() *Bucket {
 	return c.bucket
 }
 
+// Err returns the error that stopped the cursor, if any.
+func (c *Cursor) Err() error {
+	return c.err
+}
+
+// recoverCorruption recovers from the panic raised when the cursor reads a
+// corrupted page, and stops the cursor with the CorruptionError. Other panics
+// are propagated.
+func (c *Cursor) recoverCorruption() {
+	if p := recover(); p != nil {
+		cerr, ok := p.(*common.CorruptionError)
+		if !ok {
+			panic(p)
+		}
+		c.err = cerr
+		c.stack = c.stack[:0]
+	}
+}
+
+// panicOnCorruption panics again with the CorruptionError which stopped the
+// cursor, for the callers which have no way to return it.
+func (c *Cursor) panicOnCorruption() {
+	if cerr, ok := c.err.(*common.CorruptionError); ok {
+		panic(cerr)
+	}
+}
+
+// interrupted reports whether the cursor has been stopped, stopping it if the
+// context of the transaction is done.
+func (c *Cursor) interrupted() bool {
+	if c.err != nil {
+		return true
+	}
+	ctx := c.bucket.tx.ctx
+	if ctx == nil || ctx.Done() == nil {
+		return false
+	}
+	select {
+	case <-ctx.Done():
+		c.err = ctx.Err()
+		return true
+	default:
+		return false
+	}
+}
+
+// atPageEdge reports whether moving the cursor forward or backward leaves the
+// page it is on.
+func (c *Cursor) atPageEdge(forward bool) bool {
+	if len(c.stack) == 0 {
+		return false
+	}
+	ref := &c.stack[len(c.stack)-1]
+	if forward {
+		return ref.index >= ref.count()-1
+	}
+	return ref.index <= 0
+}
+
 // First moves the cursor to the first item in the bucket and returns its key and value.
 // If the bucket is empty then a nil key and value are returned.
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) First() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	defer c.recoverCorruption()
+	if c.interrupted() {
+		return nil, nil
+	}
 	k, v, flags := c.first()
+	for k != nil && c.hidden(k, v, flags) {
+		k, v, flags = c.next()
+	}
 	if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
 	}
-	return k, v
+	return k, c.value(v, flags)
 }
 
 func (c *Cursor) first() (key []byte, value []byte, flags uint32) {
@@ -64,6 +136,10 @@ func (c *Cursor) first() (key []byte, value []byte, flags uint32) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Last() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	defer c.recoverCorruption()
+	if c.interrupted() {
+		return nil, nil
+	}
 	c.stack = c.stack[:0]
 	p, n := c.bucket.pageNode(c.bucket.RootPage())
 	ref := elemRef{page: p, node: n}
@@ -82,10 +158,13 @@ func (c *Cursor) Last() (key []byte, value []byte) {
 	}
 
 	k, v, flags := c.keyValue()
+	for k != nil && c.hidden(k, v, flags) {
+		k, v, flags = c.prev()
+	}
 	if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
 	}
-	return k, v
+	return k, c.value(v, flags)
 }
 
 // Next moves the cursor to the next item in the bucket and returns its key and value.
@@ -93,11 +172,18 @@ func (c *Cursor) Last() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Next() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	defer c.recoverCorruption()
+	if c.err != nil || c.atPageEdge(true) && c.interrupted() {
+		return nil, nil
+	}
 	k, v, flags := c.next()
+	for k != nil && c.hidden(k, v, flags) {
+		k, v, flags = c.next()
+	}
 	if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
 	}
-	return k, v
+	return k, c.value(v, flags)
 }
 
 // Prev moves the cursor to the previous item in the bucket and returns its key and value.
@@ -105,11 +191,18 @@ func (c *Cursor) Next() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Prev() (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	defer c.recoverCorruption()
+	if c.err != nil || c.atPageEdge(false) && c.interrupted() {
+		return nil, nil
+	}
 	k, v, flags := c.prev()
+	for k != nil && c.hidden(k, v, flags) {
+		k, v, flags = c.prev()
+	}
 	if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
 	}
-	return k, v
+	return k, c.value(v, flags)
 }
 
 // Seek moves the cursor to a given key using a b-tree search and returns it.
@@ -118,6 +211,10 @@ func (c *Cursor) Prev() (key []byte, value []byte) {
 // The returned key and value are only valid for the life of the transaction.
 func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
 	common.Assert(c.bucket.tx.db != nil, "tx closed")
+	defer c.recoverCorruption()
+	if c.interrupted() {
+		return nil, nil
+	}
 
 	k, v, flags := c.seek(seek)
 
@@ -125,31 +222,47 @@ func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
 	if ref := &c.stack[len(c.stack)-1]; ref.index >= ref.count() {
 		k, v, flags = c.next()
 	}
+	for k != nil && c.hidden(k, v, flags) {
+		k, v, flags = c.next()
+	}
 
 	if k == nil {
 		return nil, nil
 	} else if (flags & uint32(common.BucketLeafFlag)) != 0 {
 		return k, nil
 	}
-	return k, v
+	return k, c.value(v, flags)
 }
 
 // Delete removes the current key/value under the cursor from the bucket.
 // Delete fails if current key/value is a bucket or if the transaction is not writable.
-func (c *Cursor) Delete() error {
+func (c *Cursor) Delete() (err error) {
+	defer recoverCorruption(&err)
 	if c.bucket.tx.db == nil {
 		return common.ErrTxClosed
 	} else if !c.bucket.Writable() {
-		return common.ErrTxNotWritable
+		return c.bucket.notWritable()
 	}
 
-	key, _, flags := c.keyValue()
+	key, value, flags := c.keyValue()
 	// Return an error if current value is a bucket.
 	if (flags & common.BucketLeafFlag) != 0 {
 		return common.ErrIncompatibleValue
 	}
+
+	// Remove the key from the indexes of the bucket.
+	if defs := c.bucket.indexes(); len(defs) > 0 {
+		if err := c.bucket.unindex(defs, key, c.value(value, flags)); err != nil {
+			return err
+		}
+	}
 	c.node().del(key)
 
+	if c.bucket.tx.changes != nil {
+		c.bucket.recordChange(Change{Type: ChangeDelete, Key: cloneBytes(key)})
+	}
+	c.bucket.watchKey(key, true, false)
+
 	return nil
 }
 
@@ -296,11 +409,11 @@ func (c *Cursor) searchNode(key []byte, n *node) {
 	index := sort.Search(len(n.inodes), func(i int) bool {
 		// TODO(benbjohnson): Optimize this range search. It's a bit hacky right now.
 		// sort.Search() finds the lowest index where f() != -1 but we need the highest index.
-		ret := bytes.Compare(n.inodes[i].Key(), key)
+		ret := c.bucket.compare(n.inodes[i].Key(), key)
 		if ret == 0 {
 			exact = true
 		}
-		return ret != -1
+		return ret >= 0
 	})
 	if !exact && index > 0 {
 		index--
@@ -319,11 +432,11 @@ func (c *Cursor) searchPage(key []byte, p *common.Page) {
 	index := sort.Search(int(p.Count()), func(i int) bool {
 		// TODO(benbjohnson): Optimize this range search. It's a bit hacky right now.
 		// sort.Search() finds the lowest index where f() != -1 but we need the highest index.
-		ret := bytes.Compare(inodes[i].Key(), key)
+		ret := c.bucket.compare(inodes[i].Key(), key)
 		if ret == 0 {
 			exact = true
 		}
-		return ret != -1
+		return ret >= 0
 	})
 	if !exact && index > 0 {
 		index--
@@ -342,7 +455,7 @@ func (c *Cursor) nsearch(key []byte) {
 	// If we have a node then search its inodes.
 	if n != nil {
 		index := sort.Search(len(n.inodes), func(i int) bool {
-			return bytes.Compare(n.inodes[i].Key(), key) != -1
+			return c.bucket.compare(n.inodes[i].Key(), key) >= 0
 		})
 		e.index = index
 		return
@@ -351,7 +464,7 @@ func (c *Cursor) nsearch(key []byte) {
 	// If we have a page then search its leaf elements.
 	inodes := p.LeafPageElements()
 	index := sort.Search(int(p.Count()), func(i int) bool {
-		return bytes.Compare(inodes[i].Key(), key) != -1
+		return c.bucket.compare(inodes[i].Key(), key) >= 0
 	})
 	e.index = index
 }
@@ -376,6 +489,28 @@ func (c *Cursor) keyValue() ([]byte, []byte, uint32) {
 	return elem.Key(), elem.Value(), elem.Flags()
 }
 
+// value returns the value v of the element under the cursor, without its
+// expiry time, and decompressed if flags marks it as compressed.
+func (c *Cursor) value(v []byte, flags uint32) []byte {
+	if (flags & common.ExpiringLeafFlag) != 0 {
+		v = v[8:]
+	}
+	if (flags & common.CompressedLeafFlag) == 0 {
+		return v
+	}
+	v, err := decompressValue(c.bucket.codec, v)
+	if err != nil {
+		var pgid common.Pgid
+		if ref := &c.stack[len(c.stack)-1]; ref.page != nil {
+			pgid = ref.page.Id()
+		} else {
+			pgid = ref.node.pgid
+		}
+		panic(&common.CorruptionError{Pgid: pgid, Err: fmt.Errorf("decompress value: %w", err)})
+	}
+	return v
+}
+
 // node returns the node that the cursor is currently positioned on.
 func (c *Cursor) node() *node {
 	common.Assert(len(c.stack) > 0, "accessing a node with a zero-length cursor stack")
@@ -1,13 +1,17 @@
 package bbolt
 
 import (
+	"context"
+	"crypto/cipher"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"runtime"
+	"runtime/debug"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 
@@ -17,6 +21,10 @@ import (
 // The time elapsed between consecutive file locking attempts.
 const flockRetryTimeout = 50 * time.Millisecond
 
+// The longest time elapsed between consecutive attempts to acquire a lock
+// while waiting for a context to be done.
+const lockRetryMaxDelay = time.Millisecond
+
 // DB represents a collection of buckets persisted to a file on disk.
 // All data access is performed through transactions which can be obtained through the DB.
 // All the functions on DB will return a ErrDatabaseNotOpen if accessed before Open() is called.
@@ -27,6 +35,11 @@ type DB struct {
 	// debugging purposes.
 	StrictMode bool
 
+	// When enabled, write transactions record the changes they make from the
+	// moment they begin so they can be passed to the handlers added with
+	// Tx.OnCommitChanges.
+	TrackChanges bool
+
 	// Setting the NoSync flag will cause the database to skip fsync()
 	// calls after each commit. This can be useful when bulk loading data
 	// into a database and you can restart the bulk load in the event of
@@ -97,14 +110,9 @@ type DB struct {
 
 	path     string
 	openFile func(string, int, os.FileMode) (*os.File, error)
-	file     *os.File
-	// `dataref` isn't used at all on Windows, and the golangci-lint
-	// always fails on Windows platform.
-	//nolint
-	dataref  []byte // mmap'ed readonly, write throws SEGV
-	data     *[maxMapSize]byte
-	datasz   int
-	filesz   int // current on disk file size
+	storage  Storage
+	mapping  *mapping // current memory map of the data file
+	filesz   int      // current on disk file size
 	meta0    *common.Meta
 	meta1    *common.Meta
 	pageSize int
@@ -113,9 +121,29 @@ type DB struct {
 	txs      []*Tx
 	stats    Stats
 
+	// pageChecksums is set for data files of format version 3, and of version
+	// 4 with checksums, in which every page but the meta pages ends with a
+	// checksum.
+	pageChecksums bool
+
+	// pageTxids is set for data files created with format version 4, in which
+	// every page but the meta pages stores the id of the transaction which
+	// wrote it before its checksum.
+	pageTxids bool
+
+	// reservedNames is set if the names starting with reservedPrefix in the
+	// root bucket are reserved for internal buckets. It's only unset for the
+	// databases created by earlier versions which have buckets using them.
+	reservedNames bool
+
+	// cipher seals the pages of an encrypted database.
+	cipher cipher.AEAD
+
 	freelist     *freelist
 	freelistLoad sync.Once
 
+	writes pageWrites
+
 	pagePool sync.Pool
 
 	batchMu sync.Mutex
@@ -123,16 +151,63 @@ type DB struct {
 
 	rwlock   sync.Mutex   // Allows only one writer at a time.
 	metalock sync.Mutex   // Protects meta page access.
-	mmaplock sync.RWMutex // Protects mmap access during remapping.
+	mmaplock sync.RWMutex // Held by read transactions so that Close waits for them.
 	statlock sync.RWMutex // Protects stats access.
 
 	ops struct {
 		writeAt func(b []byte, off int64) (n int, err error)
+
+		// writevAt is nil unless the storage supports vectored writes.
+		writevAt func(bufs [][]byte, off int64) (calls int, err error)
 	}
 
 	// Read only mode.
 	// When true, Update() and Begin(true) return ErrDatabaseReadOnly immediately.
 	readOnly bool
+
+	// expiryStop stops the goroutine sweeping expired keys, which closes
+	// expiryDone when it returns.
+	expiryStop     chan struct{}
+	expiryDone     chan struct{}
+	expiryStopOnce sync.Once
+
+	// indexes are the declared indexes, sorted by name. The slice is
+	// replaced, not modified, when an index is declared.
+	indexes []*indexDef
+
+	// watchers receive the changes made by write transactions. It is nil
+	// once the database is closed.
+	watchMu  sync.Mutex
+	watchers map[*watcher]struct{}
+
+	// logger and hook receive the messages and lifecycle events of the
+	// database.
+	logger Logger
+	hook   EventHook
+
+	// maxReadTxAge and txStacks are set from Options.MaxReadTxAge and
+	// Options.TxStacks.
+	maxReadTxAge time.Duration
+	txStacks     bool
+
+	// scopelock is held for reading by the transactions begun with
+	// BeginBuckets, and for writing by the other write transactions. scoped
+	// holds the top-level buckets declared by the open scoped transactions,
+	// and scopeFreed is closed when some of them are released.
+	scopelock  sync.RWMutex
+	scopeMu    sync.Mutex
+	scoped     map[string]struct{}
+	scopeFreed chan struct{}
+
+	// scopeTxid is the last id given to a scoped transaction when its commit
+	// began, and scopeTxids are the ids of those which have not finished
+	// committing, in order. scopeTurn is signaled when one of them finishes.
+	// scopePgid is the high water mark of the pages claimed by scoped
+	// transactions since the last commit, or 0. They are held by rwlock.
+	scopeTxid  common.Txid
+	scopeTxids []common.Txid
+	scopeTurn  *sync.Cond
+	scopePgid  common.Pgid
 }
 
 // Path returns the path to currently open database file.
@@ -154,9 +229,16 @@ func (db *DB) String() string {
 // If the file does not exist then it will be created automatically.
 // Passing in nil options will cause Bolt to open the database with the default options.
 func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
+	return OpenContext(context.Background(), path, mode, options)
+}
+
+// OpenContext is like Open, but it stops waiting for the lock on the database
+// file and returns the context's error when ctx is done.
+func OpenContext(ctx context.Context, path string, mode os.FileMode, options *Options) (*DB, error) {
 	db := &DB{
 		opened: true,
 	}
+	db.watchers = make(map[*watcher]struct{})
 	// Set default options if no options are provided.
 	if options == nil {
 		options = DefaultOptions
@@ -168,6 +250,35 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	db.PreLoadFreelist = options.PreLoadFreelist
 	db.FreelistType = options.FreelistType
 	db.Mlock = options.Mlock
+	db.TrackChanges = options.TrackChanges
+
+	db.logger, db.hook = options.Logger, options.EventHook
+	if db.logger == nil {
+		db.logger = discardLogger{}
+	}
+	if db.hook == nil {
+		db.hook = nopHook{}
+	}
+	db.maxReadTxAge = options.MaxReadTxAge
+	db.txStacks = options.TxStacks
+
+	switch options.FormatVersion {
+	case 0, common.Version:
+	case common.VersionChecksums:
+		db.pageChecksums = true
+	case common.VersionFeatures:
+		db.pageChecksums, db.pageTxids = true, true
+	default:
+		return nil, fmt.Errorf("unsupported format version: %d", options.FormatVersion)
+	}
+
+	if options.Encryption != nil {
+		c, err := newPageCipher(options.Encryption)
+		if err != nil {
+			return nil, err
+		}
+		db.cipher = c
+	}
 
 	// Set default values for later DB operations.
 	db.MaxBatchSize = common.DefaultMaxBatchSize
@@ -188,13 +299,19 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		db.openFile = os.OpenFile
 	}
 
-	// Open data file and separate sync handler for metadata writes.
-	var err error
-	if db.file, err = db.openFile(path, flag|os.O_CREATE, mode); err != nil {
-		_ = db.close()
-		return nil, err
+	// Open data file unless a storage is provided.
+	if options.Storage != nil {
+		db.storage = options.Storage
+	} else {
+		f, err := db.openFile(path, flag|os.O_CREATE, mode)
+		if err != nil {
+			_ = db.close()
+			return nil, err
+		}
+		db.storage = &fileStorage{file: f, readOnly: db.readOnly}
+		path = f.Name()
 	}
-	db.path = db.file.Name()
+	db.path = path
 
 	// Lock file so that other processes using Bolt in read-write mode cannot
 	// use the database  at the same time. This would cause corruption since
@@ -203,13 +320,19 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	// if !options.ReadOnly.
 	// The database file is locked using the shared lock (more than one process may
 	// hold a lock at the same time) otherwise (options.ReadOnly is set).
-	if err := flock(db, !db.readOnly, options.Timeout); err != nil {
+	if err := db.storage.Lock(ctx, !db.readOnly, options.Timeout); err != nil {
+		// The lock is not held, so the storage must not be unlocked.
+		_ = db.storage.Close()
+		db.storage = nil
 		_ = db.close()
 		return nil, err
 	}
 
 	// Default values for test hooks
-	db.ops.writeAt = db.file.WriteAt
+	db.ops.writeAt = db.storage.WriteAt
+	if vw, ok := db.storage.(vectorWriter); ok {
+		db.ops.writevAt = vw.writevAt
+	}
 
 	if db.pageSize = options.PageSize; db.pageSize == 0 {
 		// Set the default page size to the OS page size.
@@ -217,10 +340,10 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	}
 
 	// Initialize the database if it doesn't exist.
-	if info, err := db.file.Stat(); err != nil {
+	if size, err := db.storage.Size(); err != nil {
 		_ = db.close()
 		return nil, err
-	} else if info.Size() == 0 {
+	} else if size == 0 {
 		// Initialize new files with meta pages.
 		if err := db.init(); err != nil {
 			// clean up file descriptor on initialization fail
@@ -249,10 +372,53 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		_ = db.close()
 		return nil, err
 	}
+	db.pageChecksums = db.meta().PageChecksums()
+	db.pageTxids = db.meta().PageTxids()
+
+	// Check that the database is encrypted if and only if a key is given, and
+	// that the key decrypts the root page.
+	if encrypted := db.meta().Flags()&common.MetaEncryptedFlag != 0; encrypted && db.cipher == nil {
+		_ = db.close()
+		return nil, common.ErrEncrypted
+	} else if !encrypted && db.cipher != nil {
+		_ = db.close()
+		return nil, common.ErrNotEncrypted
+	} else if encrypted {
+		if _, err := db.readPage(db.mapping, db.meta().RootBucket().RootPage()); err != nil {
+			_ = db.close()
+			if errors.Is(err, common.ErrDecryption) {
+				return nil, common.ErrEncryptionKey
+			}
+			return nil, err
+		}
+	}
+
+	// Verify the freelist page, which is read outside of transactions.
+	if db.hasSyncedFreelist() {
+		if _, err := db.readPage(db.mapping, db.meta().Freelist()); err != nil {
+			_ = db.close()
+			return nil, err
+		}
+	}
+
+	// Reserve the names of the internal buckets, unless the database was
+	// created by an earlier version and has buckets using them. Write
+	// transactions persist the reservation.
+	if err := db.reserveNames(); err != nil {
+		_ = db.close()
+		return nil, err
+	}
+
+	// Start tracking page writes for incremental backups, unless the pages
+	// store the transaction which wrote them.
+	if !db.pageTxids {
+		db.writes.reset(db.meta().Txid())
+	}
 
 	if db.PreLoadFreelist {
 		db.loadFreelist()
 	}
+	db.logger.Info("database opened", "path", db.path, "pageSize", db.pageSize, "readOnly", db.readOnly)
 
 	if db.readOnly {
 		return db, nil
@@ -271,6 +437,10 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		}
 	}
 
+	if options.ExpireSweepInterval > 0 {
+		db.startExpirySweeper(options.ExpireSweepInterval)
+	}
+
 	// Mark the database as opened and return.
 	return db, nil
 }
@@ -318,7 +488,7 @@ func (db *DB) getPageSize() (int, error) {
 func (db *DB) getPageSizeFromFirstMeta() (int, bool, error) {
 	var buf [0x1000]byte
 	var metaCanRead bool
-	if bw, err := db.file.ReadAt(buf[:], 0); err == nil && bw == len(buf) {
+	if bw, err := db.storage.ReadAt(buf[:], 0); err == nil && bw == len(buf) {
 		metaCanRead = true
 		if m := db.pageInBuffer(buf[:], 0).Meta(); m.Validate() == nil {
 			return int(m.PageSize()), metaCanRead, nil
@@ -335,10 +505,10 @@ func (db *DB) getPageSizeFromSecondMeta() (int, bool, error) {
 	)
 
 	// get the db file size
-	if info, err := db.file.Stat(); err != nil {
+	if size, err := db.storage.Size(); err != nil {
 		return 0, metaCanRead, err
 	} else {
-		fileSize = info.Size()
+		fileSize = size
 	}
 
 	// We need to read the second meta page, so we should skip the first page;
@@ -353,7 +523,7 @@ func (db *DB) getPageSizeFromSecondMeta() (int, bool, error) {
 		if pos >= fileSize-1024 {
 			break
 		}
-		bw, err := db.file.ReadAt(buf[:], pos)
+		bw, err := db.storage.ReadAt(buf[:], pos)
 		if (err == nil && bw == len(buf)) || (err == io.EOF && int64(bw) == (fileSize-pos)) {
 			metaCanRead = true
 			if m := db.pageInBuffer(buf[:], 0).Meta(); m.Validate() == nil {
@@ -370,15 +540,21 @@ func (db *DB) getPageSizeFromSecondMeta() (int, bool, error) {
 // concurrent accesses being made to the freelist.
 func (db *DB) loadFreelist() {
 	db.freelistLoad.Do(func() {
+		start := time.Now()
 		db.freelist = newFreelist(db.FreelistType)
-		if !db.hasSyncedFreelist() {
+		synced := db.hasSyncedFreelist()
+		if !synced {
 			// Reconstruct free list by scanning the DB.
 			db.freelist.readIDs(db.freepages())
 		} else {
 			// Read free list from freelist page.
-			db.freelist.read(db.page(db.meta().Freelist()))
+			db.freelist.read(db.mustReadPage(db.meta().Freelist()))
 		}
 		db.stats.FreePageN = db.freelist.free_count()
+
+		d := time.Since(start)
+		db.hook.OnEvent(Event{Type: EventFreelistLoad, Duration: d, Size: db.stats.FreePageN})
+		db.logger.Info("freelist loaded", "freePages", db.stats.FreePageN, "scanned", !synced, "duration", d)
 	})
 }
 
@@ -388,20 +564,26 @@ func (db *DB) hasSyncedFreelist() bool {
 
 // mmap opens the underlying memory-mapped file and initializes the meta references.
 // minsz is the minimum size that the new mmap can be.
-func (db *DB) mmap(minsz int) error {
-	db.mmaplock.Lock()
-	defer db.mmaplock.Unlock()
+//
+// The previous mapping, if any, is released. Read transactions which still use
+// it keep it mapped until they close, so mmap does not wait for them.
+func (db *DB) mmap(minsz int) (err error) {
+	start := time.Now()
+	var size int
+	defer func() {
+		db.event(Event{Type: EventMmap, Duration: time.Since(start), Size: size, Err: err}, "mmap", "size", size)
+	}()
 
-	info, err := db.file.Stat()
+	sz, err := db.storage.Size()
 	if err != nil {
 		return fmt.Errorf("mmap stat error: %s", err)
-	} else if int(info.Size()) < db.pageSize*2 {
+	} else if int(sz) < db.pageSize*2 {
 		return fmt.Errorf("file size too small")
 	}
 
 	// Ensure the size is at least the minimum size.
-	fileSize := int(info.Size())
-	var size = fileSize
+	fileSize := int(sz)
+	size = fileSize
 	if size < minsz {
 		size = minsz
 	}
@@ -417,23 +599,40 @@ func (db *DB) mmap(minsz int) error {
 		}
 	}
 
-	// Dereference all mmap references before unmapping.
+	// Dereference all mmap references before the previous mapping is
+	// released.
 	if db.rwtx != nil {
 		db.rwtx.root.dereference()
 	}
 
-	// Unmap existing data before continuing.
-	if err := db.munmap(); err != nil {
-		return err
-	}
-
 	// Memory-map the data file as a byte slice.
 	// gofail: var mapError string
 	// return errors.New(mapError)
-	if err := mmap(db, size); err != nil {
+	b, err := db.storage.Mmap(size, db.MmapFlags)
+	if err != nil {
 		return err
 	}
 
+	// Replace the current mapping and the references to the meta pages
+	// while holding the meta lock, so that new read transactions begin with
+	// the new mapping.
+	db.metalock.Lock()
+	prev := db.mapping
+	db.mapping = newMapping(b)
+	db.meta0 = db.page(0).Meta()
+	db.meta1 = db.page(1).Meta()
+	db.metalock.Unlock()
+
+	if prev != nil {
+		if err := db.release(prev); err != nil {
+			return fmt.Errorf("unmap error: " + err.Error())
+		}
+	}
+
+	db.statlock.Lock()
+	db.stats.MmapSize, db.stats.FileSize = size, fileSize
+	db.statlock.Unlock()
+
 	if db.Mlock {
 		// Don't allow swapping of data file
 		if err := db.mlock(fileSize); err != nil {
@@ -441,10 +640,6 @@ func (db *DB) mmap(minsz int) error {
 		}
 	}
 
-	// Save references to the meta pages.
-	db.meta0 = db.page(0).Meta()
-	db.meta1 = db.page(1).Meta()
-
 	// Validate the meta pages. We only return an error if both meta pages fail
 	// validation, since meta0 failing validation means that it wasn't saved
 	// properly -- but we can recover using meta1. And vice-versa.
@@ -458,21 +653,25 @@ func (db *DB) mmap(minsz int) error {
 }
 
 func (db *DB) invalidate() {
-	db.dataref = nil
-	db.data = nil
-	db.datasz = 0
+	db.mapping = nil
 
 	db.meta0 = nil
 	db.meta1 = nil
 }
 
-// munmap unmaps the data file from memory.
+// munmap releases the current mapping of the data file. It is unmapped once
+// no read transaction uses it anymore.
 func (db *DB) munmap() error {
 	defer db.invalidate()
 
 	// gofail: var unmapError string
 	// return errors.New(unmapError)
-	if err := munmap(db); err != nil {
+
+	// Ignore the unmap if we have no mapped data.
+	if db.mapping == nil {
+		return nil
+	}
+	if err := db.release(db.mapping); err != nil {
 		return fmt.Errorf("unmap error: " + err.Error())
 	}
 
@@ -552,8 +751,20 @@ func (db *DB) init() error {
 		// Initialize the meta page.
 		m := p.Meta()
 		m.SetMagic(common.Magic)
-		m.SetVersion(common.Version)
+		flags := uint32(common.MetaReservedNamesFlag)
+		if db.pageTxids {
+			m.SetVersion(common.VersionFeatures)
+			flags |= common.MetaChecksumsFlag | common.MetaPageTxidsFlag
+		} else if db.pageChecksums {
+			m.SetVersion(common.VersionChecksums)
+		} else {
+			m.SetVersion(common.Version)
+		}
 		m.SetPageSize(uint32(db.pageSize))
+		if db.cipher != nil {
+			flags |= common.MetaEncryptedFlag
+		}
+		m.SetFlags(flags)
 		m.SetFreelist(2)
 		m.SetRootBucket(common.NewInBucket(3, 0))
 		m.SetPgid(4)
@@ -573,11 +784,23 @@ func (db *DB) init() error {
 	p.SetFlags(common.LeafPageFlag)
 	p.SetCount(0)
 
+	for id := common.Pgid(2); id <= 3; id++ {
+		p := db.pageInBuffer(buf, id)
+		if db.cipher != nil {
+			if err := db.encryptPage(p, 0); err != nil {
+				return err
+			}
+		}
+		if db.pageChecksums {
+			p.SetChecksum(db.pageSize)
+		}
+	}
+
 	// Write the buffer to our data file.
 	if _, err := db.ops.writeAt(buf, 0); err != nil {
 		return err
 	}
-	if err := fdatasync(db); err != nil {
+	if err := db.storage.Sync(); err != nil {
 		return err
 	}
 	db.filesz = len(buf)
@@ -589,6 +812,12 @@ func (db *DB) init() error {
 // It will block waiting for any open transactions to finish
 // before closing the database and returning.
 func (db *DB) Close() error {
+	db.stopExpirySweeper()
+	db.stopWatchers()
+
+	db.scopelock.Lock()
+	defer db.scopelock.Unlock()
+
 	db.rwlock.Lock()
 	defer db.rwlock.Unlock()
 
@@ -607,11 +836,13 @@ func (db *DB) close() error {
 	}
 
 	db.opened = false
+	start, path := time.Now(), db.path
 
 	db.freelist = nil
 
 	// Clear ops.
 	db.ops.writeAt = nil
+	db.ops.writevAt = nil
 
 	var errs []error
 	// Close the mmap.
@@ -619,29 +850,33 @@ func (db *DB) close() error {
 		errs = append(errs, err)
 	}
 
-	// Close file handles.
-	if db.file != nil {
-		// No need to unlock read-only file.
-		if !db.readOnly {
-			// Unlock the file.
-			if err := funlock(db); err != nil {
-				errs = append(errs, fmt.Errorf("bolt.Close(): funlock error: %w", err))
-			}
+	// Release the storage.
+	if db.storage != nil {
+		// Unlock the storage.
+		if err := db.storage.Unlock(); err != nil {
+			errs = append(errs, fmt.Errorf("bolt.Close(): funlock error: %w", err))
 		}
 
-		// Close the file descriptor.
-		if err := db.file.Close(); err != nil {
+		// Close the storage.
+		if err := db.storage.Close(); err != nil {
 			errs = append(errs, fmt.Errorf("db file close: %w", err))
 		}
-		db.file = nil
+		db.storage = nil
 	}
 
 	db.path = ""
 
+	var err error
 	if len(errs) > 0 {
-		return errs[0]
+		err = errs[0]
 	}
-	return nil
+	db.hook.OnEvent(Event{Type: EventClose, Duration: time.Since(start), Err: err})
+	if err != nil {
+		db.logger.Error("close failed", "path", path, "error", err)
+	} else {
+		db.logger.Info("database closed", "path", path)
+	}
+	return err
 }
 
 // Begin starts a new transaction.
@@ -650,34 +885,46 @@ func (db *DB) close() error {
 // will cause the calls to block and be serialized until the current write
 // transaction finishes.
 //
-// Transactions should not be dependent on one another. Opening a read
-// transaction and a write transaction in the same goroutine can cause the
-// writer to deadlock because the database periodically needs to re-mmap itself
-// as it grows and it cannot do that while a read transaction is open.
-//
-// If a long running read transaction (for example, a snapshot transaction) is
-// needed, you might want to set DB.InitialMmapSize to a large enough value
-// to avoid potential blocking of write transaction.
+// Transactions should not be dependent on one another. The database maps its
+// file again as it grows without waiting for open read transactions, which keep
+// reading from the previous mapping until they close, but a read transaction
+// opened while another one is open in the same goroutine can deadlock with
+// Close.
 //
 // IMPORTANT: You must close read-only transactions after you are finished or
 // else the database will not reclaim old pages.
 func (db *DB) Begin(writable bool) (*Tx, error) {
+	return db.BeginContext(context.Background(), writable)
+}
+
+// BeginContext is like Begin, but it stops waiting for the locks needed to
+// start the transaction and returns the context's error when ctx is done.
+//
+// The context is kept by the transaction. Cursors stop at the next page
+// boundary once it is done, see Cursor.Err.
+func (db *DB) BeginContext(ctx context.Context, writable bool) (*Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	if writable {
-		return db.beginRWTx()
+		return db.beginRWTx(ctx)
 	}
-	return db.beginTx()
+	return db.beginTx(ctx)
 }
 
-func (db *DB) beginTx() (*Tx, error) {
+func (db *DB) beginTx(ctx context.Context) (*Tx, error) {
 	// Lock the meta pages while we initialize the transaction. We obtain
 	// the meta lock before the mmap lock because that's the order that the
 	// write transaction will obtain them.
 	db.metalock.Lock()
 
-	// Obtain a read-only lock on the mmap. When the mmap is remapped it will
-	// obtain a write lock so all transactions must finish before it can be
-	// remapped.
-	db.mmaplock.RLock()
+	// Obtain a read-only lock on the mmap. When the database is closed it
+	// will obtain a write lock so all transactions must finish before it can
+	// be unmapped.
+	if err := lockContext(ctx, db.mmaplock.TryRLock, db.mmaplock.RLock); err != nil {
+		db.metalock.Unlock()
+		return nil, err
+	}
 
 	// Exit if the database is not open yet.
 	if !db.opened {
@@ -687,15 +934,21 @@ func (db *DB) beginTx() (*Tx, error) {
 	}
 
 	// Exit if the database is not correctly mapped.
-	if db.data == nil {
+	if db.mapping == nil {
 		db.mmaplock.RUnlock()
 		db.metalock.Unlock()
 		return nil, common.ErrInvalidMapping
 	}
 
-	// Create a transaction associated with the database.
-	t := &Tx{}
+	// Create a transaction associated with the database. It reads from the
+	// current mapping until it closes, even if the file is mapped again.
+	t := &Tx{ctx: ctx, mapping: db.mapping}
+	t.mapping.acquire()
 	t.init(db)
+	t.start = time.Now()
+	if db.txStacks {
+		t.stack = debug.Stack()
+	}
 
 	// Keep track of transaction until it closes.
 	db.txs = append(db.txs, t)
@@ -713,15 +966,24 @@ func (db *DB) beginTx() (*Tx, error) {
 	return t, nil
 }
 
-func (db *DB) beginRWTx() (*Tx, error) {
+func (db *DB) beginRWTx(ctx context.Context) (*Tx, error) {
 	// If the database was opened with Options.ReadOnly, return an error.
 	if db.readOnly {
 		return nil, common.ErrDatabaseReadOnly
 	}
 
+	// Wait for the transactions begun with BeginBuckets, and keep new ones
+	// from starting until the transaction closes.
+	if err := lockContext(ctx, db.scopelock.TryLock, db.scopelock.Lock); err != nil {
+		return nil, err
+	}
+
 	// Obtain writer lock. This is released by the transaction when it closes.
 	// This enforces only one writer transaction at a time.
-	db.rwlock.Lock()
+	if err := lockContext(ctx, db.rwlock.TryLock, db.rwlock.Lock); err != nil {
+		db.scopelock.Unlock()
+		return nil, err
+	}
 
 	// Once we have the writer lock then we can lock the meta pages so that
 	// we can set up the transaction.
@@ -731,23 +993,57 @@ func (db *DB) beginRWTx() (*Tx, error) {
 	// Exit if the database is not open yet.
 	if !db.opened {
 		db.rwlock.Unlock()
+		db.scopelock.Unlock()
 		return nil, common.ErrDatabaseNotOpen
 	}
 
 	// Exit if the database is not correctly mapped.
-	if db.data == nil {
+	if db.mapping == nil {
 		db.rwlock.Unlock()
+		db.scopelock.Unlock()
 		return nil, common.ErrInvalidMapping
 	}
 
 	// Create a transaction associated with the database.
-	t := &Tx{writable: true}
+	t := &Tx{writable: true, ctx: ctx}
 	t.init(db)
 	db.rwtx = t
+	db.raiseHighWaterMark(t.meta)
 	db.freePages()
+	db.reportLongReadTxs()
 	return t, nil
 }
 
+// lockContext acquires a lock with lock, or by polling tryLock when ctx can be
+// done. It returns the context's error if ctx is done before the lock is
+// acquired.
+func lockContext(ctx context.Context, tryLock func() bool, lock func()) error {
+	if ctx.Done() == nil {
+		lock()
+		return nil
+	}
+
+	delay := 10 * time.Microsecond
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if tryLock() {
+			return nil
+		}
+
+		// Back off so a long wait does not keep the CPU busy.
+		if delay < lockRetryMaxDelay {
+			delay *= 2
+		}
+		timer.Reset(delay)
+	}
+}
+
 // freePages releases any pages associated with closed read-only transactions.
 func (db *DB) freePages() {
 	// Free all pending pages prior to earliest open transaction.
@@ -776,7 +1072,9 @@ func (t txsById) Less(i, j int) bool { return t[i].meta.Txid() < t[j].meta.Txid(
 
 // removeTx removes a transaction from the database.
 func (db *DB) removeTx(tx *Tx) {
-	// Release the read lock on the mmap.
+	// Release the mapping of the transaction, unmapping it if it has been
+	// replaced, before the read lock on the mmap so that Close waits for it.
+	_ = db.release(tx.mapping)
 	db.mmaplock.RUnlock()
 
 	// Use the meta lock to restrict access to the DB object.
@@ -808,42 +1106,69 @@ func (db *DB) removeTx(tx *Tx) {
 // If no error is returned from the function then the transaction is committed.
 // If an error is returned then the entire transaction is rolled back.
 // Any error that is returned from the function or returned from the commit is
-// returned from the Update() method.
+// returned from the Update() method. If the transaction reads a page which
+// fails its checksum, it is rolled back and a corruption error is returned.
 //
 // Attempting to manually commit or rollback within the function will cause a panic.
 func (db *DB) Update(fn func(*Tx) error) error {
-	t, err := db.Begin(true)
+	return db.UpdateContext(context.Background(), fn)
+}
+
+// UpdateContext is like Update, but the transaction is started with
+// BeginContext. The transaction is rolled back and the context's error is
+// returned if ctx is done before it is committed.
+func (db *DB) UpdateContext(ctx context.Context, fn func(*Tx) error) (err error) {
+	t, err := db.BeginContext(ctx, true)
 	if err != nil {
 		return err
 	}
+	return t.update(ctx, fn)
+}
 
+// update runs fn in the write transaction, and commits it unless fn fails or
+// ctx is done, for UpdateContext and UpdateBucketsContext.
+func (tx *Tx) update(ctx context.Context, fn func(*Tx) error) (err error) {
 	// Make sure the transaction rolls back in the event of a panic.
 	defer func() {
-		if t.db != nil {
-			t.rollback()
+		if tx.db != nil {
+			tx.rollback()
 		}
 	}()
+	defer recoverCorruption(&err)
 
 	// Mark as a managed tx so that the inner function cannot manually commit.
-	t.managed = true
+	tx.managed = true
 
 	// If an error is returned from the function then rollback and return error.
-	err = fn(t)
-	t.managed = false
+	err = fn(tx)
+	tx.managed = false
 	if err != nil {
-		_ = t.Rollback()
+		_ = tx.Rollback()
+		return err
+	}
+
+	// Do not commit if the context is done.
+	if err := ctx.Err(); err != nil {
+		_ = tx.Rollback()
 		return err
 	}
 
-	return t.Commit()
+	return tx.Commit()
 }
 
 // View executes a function within the context of a managed read-only transaction.
 // Any error that is returned from the function is returned from the View() method.
+// If the transaction reads a page which fails its checksum, a corruption error
+// is returned.
 //
 // Attempting to manually rollback within the function will cause a panic.
 func (db *DB) View(fn func(*Tx) error) error {
-	t, err := db.Begin(false)
+	return db.ViewContext(context.Background(), fn)
+}
+
+// ViewContext is like View, but the transaction is started with BeginContext.
+func (db *DB) ViewContext(ctx context.Context, fn func(*Tx) error) (err error) {
+	t, err := db.BeginContext(ctx, false)
 	if err != nil {
 		return err
 	}
@@ -854,6 +1179,7 @@ func (db *DB) View(fn func(*Tx) error) error {
 			t.rollback()
 		}
 	}()
+	defer recoverCorruption(&err)
 
 	// Mark as a managed tx so that the inner function cannot manually rollback.
 	t.managed = true
@@ -887,7 +1213,15 @@ func (db *DB) View(fn func(*Tx) error) error {
 //
 // Batch is only useful when there are multiple goroutines calling it.
 func (db *DB) Batch(fn func(*Tx) error) error {
+	return db.BatchContext(context.Background(), fn)
+}
+
+// BatchContext is like Batch, but it gives up and returns the context's error
+// when ctx is done before the batch containing fn starts to run it. Once fn has
+// been called, BatchContext waits for the outcome of the batch.
+func (db *DB) BatchContext(ctx context.Context, fn func(*Tx) error) error {
 	errCh := make(chan error, 1)
+	c := &call{fn: fn, err: errCh}
 
 	db.batchMu.Lock()
 	if (db.batch == nil) || (db.batch != nil && len(db.batch.calls) >= db.MaxBatchSize) {
@@ -897,30 +1231,60 @@ func (db *DB) Batch(fn func(*Tx) error) error {
 		}
 		db.batch.timer = time.AfterFunc(db.MaxBatchDelay, db.batch.trigger)
 	}
-	db.batch.calls = append(db.batch.calls, call{fn: fn, err: errCh})
+	db.batch.calls = append(db.batch.calls, c)
 	if len(db.batch.calls) >= db.MaxBatchSize {
 		// wake up batch, it's ready to run
 		go db.batch.trigger()
 	}
 	db.batchMu.Unlock()
 
-	err := <-errCh
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		if c.abandon() {
+			return ctx.Err()
+		}
+		// The batch already runs fn; wait for its outcome.
+		err = <-errCh
+	}
 	if err == trySolo {
-		err = db.Update(fn)
+		err = db.UpdateContext(ctx, fn)
 	}
 	return err
 }
 
+// The states of a call in a batch.
+const (
+	callPending int32 = iota
+	callStarted
+	callAbandoned
+)
+
 type call struct {
-	fn  func(*Tx) error
-	err chan<- error
+	fn    func(*Tx) error
+	err   chan<- error
+	state int32
+}
+
+// start marks the call as started and reports whether it should be run.
+// Calls that were abandoned by their caller are not run.
+func (c *call) start() bool {
+	return atomic.CompareAndSwapInt32(&c.state, callPending, callStarted) ||
+		atomic.LoadInt32(&c.state) == callStarted
+}
+
+// abandon marks the call as abandoned and reports whether it succeeded. A call
+// can not be abandoned once the batch has started to run it.
+func (c *call) abandon() bool {
+	return atomic.CompareAndSwapInt32(&c.state, callPending, callAbandoned)
 }
 
 type batch struct {
 	db    *DB
 	timer *time.Timer
 	start sync.Once
-	calls []call
+	calls []*call
 }
 
 // trigger runs the batch if it hasn't already been run.
@@ -945,6 +1309,9 @@ retry:
 		var failIdx = -1
 		err := b.db.Update(func(tx *Tx) error {
 			for i, c := range b.calls {
+				if !c.start() {
+					continue
+				}
 				if err := safelyCall(c.fn, tx); err != nil {
 					failIdx = i
 					return err
@@ -988,9 +1355,28 @@ func (p panicked) Error() string {
 	return fmt.Sprintf("panic: %v", p.reason)
 }
 
+// recoverCorruption recovers from the panic raised when a transaction reads a
+// corrupted page, and returns the CorruptionError through err. Other panics
+// are propagated.
+func recoverCorruption(err *error) {
+	if p := recover(); p != nil {
+		cerr, ok := p.(*common.CorruptionError)
+		if !ok {
+			panic(p)
+		}
+		*err = cerr
+	}
+}
+
+// safelyCall calls fn, turning its panics into errors. The CorruptionError
+// raised when it reads a corrupted page is returned as is.
 func safelyCall(fn func(*Tx) error, tx *Tx) (err error) {
 	defer func() {
 		if p := recover(); p != nil {
+			if cerr, ok := p.(*common.CorruptionError); ok {
+				err = cerr
+				return
+			}
 			err = panicked{p}
 		}
 	}()
@@ -1001,7 +1387,12 @@ func safelyCall(fn func(*Tx) error, tx *Tx) (err error) {
 //
 // This is not necessary under normal operation, however, if you use NoSync
 // then it allows you to force the database file to sync against the disk.
-func (db *DB) Sync() error { return fdatasync(db) }
+func (db *DB) Sync() error {
+	if db.storage == nil {
+		return common.ErrDatabaseNotOpen
+	}
+	return db.storage.Sync()
+}
 
 // Stats retrieves ongoing performance stats for the database.
 // This is only updated when a transaction closes.
@@ -1014,14 +1405,58 @@ func (db *DB) Stats() Stats {
 // This is for internal access to the raw data bytes from the C cursor, use
 // carefully, or not at all.
 func (db *DB) Info() *Info {
-	common.Assert(db.data != nil, "database file isn't correctly mapped")
-	return &Info{uintptr(unsafe.Pointer(&db.data[0])), db.pageSize}
+	common.Assert(db.mapping != nil, "database file isn't correctly mapped")
+	return &Info{uintptr(unsafe.Pointer(&db.mapping.data[0])), db.pageSize}
 }
 
-// page retrieves a page reference from the mmap based on the current page size.
+// page retrieves a page reference from the current mmap based on the current
+// page size.
 func (db *DB) page(id common.Pgid) *common.Page {
-	pos := id * common.Pgid(db.pageSize)
-	return (*common.Page)(unsafe.Pointer(&db.data[pos]))
+	return db.mapping.page(id, db.pageSize)
+}
+
+// checkPage verifies the checksum of a page in a mapping, if the database
+// stores page checksums. It returns a CorruptionError if the page is corrupted.
+func (db *DB) checkPage(m *mapping, id common.Pgid) error {
+	// The meta pages have checksums of their own.
+	if !db.pageChecksums || id <= 1 {
+		return nil
+	}
+	p := m.page(id, db.pageSize)
+	if (int(id)+int(p.Overflow())+1)*db.pageSize > m.size {
+		// The overflow count is corrupted, so the checksum can not be found.
+		return &common.CorruptionError{Pgid: id, Err: common.ErrPageChecksum}
+	} else if err := p.VerifyChecksum(db.pageSize); err != nil {
+		return &common.CorruptionError{Pgid: id, Err: err}
+	}
+	return nil
+}
+
+// mustReadPage is like readPage, but panics if the page can not be read. It
+// is used for pages which were verified when the database was opened, or
+// written by this process.
+func (db *DB) mustReadPage(id common.Pgid) *common.Page {
+	p, err := db.readPage(db.mapping, id)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// trailerSize returns the number of bytes reserved at the end of every page
+// but the meta pages.
+func (db *DB) trailerSize() int {
+	var sz int
+	if db.pageChecksums {
+		sz += common.PageChecksumSize
+	}
+	if db.pageTxids {
+		sz += common.PageTxidSize
+	}
+	if db.cipher != nil {
+		sz += encryptionTrailerSize
+	}
+	return sz
 }
 
 // pageInBuffer retrieves a page reference from a given byte array based on the current page size.
@@ -1055,15 +1490,7 @@ func (db *DB) meta() *common.Meta {
 
 // allocate returns a contiguous block of memory starting at a given page.
 func (db *DB) allocate(txid common.Txid, count int) (*common.Page, error) {
-	// Allocate a temporary buffer for the page.
-	var buf []byte
-	if count == 1 {
-		buf = db.pagePool.Get().([]byte)
-	} else {
-		buf = make([]byte, count*db.pageSize)
-	}
-	p := (*common.Page)(unsafe.Pointer(&buf[0]))
-	p.SetOverflow(uint32(count - 1))
+	p := db.pageBuffer(count)
 
 	// Use pages from the freelist if they are available.
 	p.SetId(db.freelist.allocate(txid, count))
@@ -1074,7 +1501,7 @@ func (db *DB) allocate(txid common.Txid, count int) (*common.Page, error) {
 	// Resize mmap() if we're at the end.
 	p.SetId(db.rwtx.meta.Pgid())
 	var minsz = int((p.Id()+common.Pgid(count))+1) * db.pageSize
-	if minsz >= db.datasz {
+	if minsz >= db.mapping.size {
 		if err := db.mmap(minsz); err != nil {
 			return nil, fmt.Errorf("mmap allocate error: %s", err)
 		}
@@ -1087,17 +1514,53 @@ func (db *DB) allocate(txid common.Txid, count int) (*common.Page, error) {
 	return p, nil
 }
 
+// pageBuffer returns a temporary buffer for a dirty page of count pages.
+func (db *DB) pageBuffer(count int) *common.Page {
+	var buf []byte
+	if count == 1 {
+		buf = db.pagePool.Get().([]byte)
+	} else {
+		buf = make([]byte, count*db.pageSize)
+	}
+	p := (*common.Page)(unsafe.Pointer(&buf[0]))
+	p.SetOverflow(uint32(count - 1))
+	return p
+}
+
+// releasePageBuffer puts the buffer of a dirty page which has been written
+// back to the page pool.
+func (db *DB) releasePageBuffer(p *common.Page) {
+	// Ignore page sizes over 1 page.
+	// These are allocated using make() instead of the page pool.
+	if int(p.Overflow()) != 0 {
+		return
+	}
+
+	buf := common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, db.pageSize)
+
+	// See https://go.googlesource.com/go/+/f03c9202c43e0abb130669852082117ca50aa9b1
+	for i := range buf {
+		buf[i] = 0
+	}
+	db.pagePool.Put(buf) //nolint:staticcheck
+}
+
 // grow grows the size of the database to the given sz.
-func (db *DB) grow(sz int) error {
+func (db *DB) grow(sz int) (err error) {
 	// Ignore if the new size is less than available file size.
 	if sz <= db.filesz {
 		return nil
 	}
 
+	start, from := time.Now(), db.filesz
+	defer func() {
+		db.event(Event{Type: EventGrow, Duration: time.Since(start), Size: sz, Err: err}, "grow", "from", from, "size", sz)
+	}()
+
 	// If the data is smaller than the alloc size then only allocate what's needed.
 	// Once it goes over the allocation size then allocate in chunks.
-	if db.datasz <= db.AllocSize {
-		sz = db.datasz
+	if db.mapping.size <= db.AllocSize {
+		sz = db.mapping.size
 	} else {
 		sz += db.AllocSize
 	}
@@ -1106,11 +1569,11 @@ func (db *DB) grow(sz int) error {
 	// https://github.com/boltdb/bolt/issues/284
 	if !db.NoGrowSync && !db.readOnly {
 		if runtime.GOOS != "windows" {
-			if err := db.file.Truncate(int64(sz)); err != nil {
+			if err := db.storage.Truncate(int64(sz)); err != nil {
 				return fmt.Errorf("file resize error: %s", err)
 			}
 		}
-		if err := db.file.Sync(); err != nil {
+		if err := db.storage.Sync(); err != nil {
 			return fmt.Errorf("file sync error: %s", err)
 		}
 		if db.Mlock {
@@ -1122,6 +1585,10 @@ func (db *DB) grow(sz int) error {
 	}
 
 	db.filesz = sz
+
+	db.statlock.Lock()
+	db.stats.FileSize = sz
+	db.statlock.Unlock()
 	return nil
 }
 
@@ -1130,7 +1597,7 @@ func (db *DB) IsReadOnly() bool {
 }
 
 func (db *DB) freepages() []common.Pgid {
-	tx, err := db.beginTx()
+	tx, err := db.beginTx(context.Background())
 	defer func() {
 		err = tx.Rollback()
 		if err != nil {
@@ -1197,9 +1664,9 @@ type Options struct {
 	MmapFlags int
 
 	// InitialMmapSize is the initial mmap size of the database
-	// in bytes. Read transactions won't block write transaction
-	// if the InitialMmapSize is large enough to hold database mmap
-	// size. (See DB.Begin for more information)
+	// in bytes. The file is mapped again each time the database
+	// outgrows its mapping, so a large enough InitialMmapSize
+	// saves remapping it.
 	//
 	// If <=0, the initial map size is 0.
 	// If initialMmapSize is smaller than the previous database size,
@@ -1218,10 +1685,66 @@ type Options struct {
 	// is useful for writing hermetic tests.
 	OpenFile func(string, int, os.FileMode) (*os.File, error)
 
+	// Storage holds the database instead of the file at the path passed to
+	// Open, which is then only used as the name of the database. OpenFile
+	// is not used to open the database when it is set. See MemStorage for a
+	// storage that keeps the database in memory.
+	Storage Storage
+
 	// Mlock locks database file in memory when set to true.
 	// It prevents potential page faults, however
 	// used memory can't be reclaimed. (UNIX only)
 	Mlock bool
+
+	// TrackChanges sets the initial value of DB.TrackChanges.
+	TrackChanges bool
+
+	// FormatVersion is the data file format version of a newly created
+	// database. It defaults to 2. Version 3 stores a checksum in every page,
+	// which is verified when the page is read, so that corrupted pages are
+	// detected. Version 4 also stores the id of the transaction which wrote
+	// every page, so that Tx.WriteIncrementalTo finds the pages changed since
+	// any transaction, in any process. It has no effect when opening an existing database; use
+	// Compact to copy a database into a new file of another version. The
+	// version is raised to 4 once the database uses features which older
+	// versions of bbolt can not read, such as buckets with options.
+	FormatVersion int
+
+	// Encryption encrypts the database at rest with the key it provides.
+	// Every page but the two meta pages is encrypted with AES-GCM when it
+	// is written, and decrypted into memory when a transaction first reads
+	// it, instead of being read straight from the mmap. A database is
+	// encrypted if it was created with Encryption set, and can only be
+	// opened with the same key. Use Compact to copy a database into a new
+	// file encrypted with another key, or not encrypted at all.
+	Encryption KeyProvider
+
+	// ExpireSweepInterval starts a goroutine which deletes expired keys with
+	// DB.ExpireSweep at this interval, until the database is closed. If it
+	// is zero, expired keys are only deleted by calls to ExpireSweep. It has
+	// no effect in read-only mode.
+	ExpireSweepInterval time.Duration
+
+	// Logger receives messages about the operations of the database, such
+	// as growing the data file, remapping it and committing transactions.
+	// Messages are discarded if it is nil.
+	Logger Logger
+
+	// EventHook receives the lifecycle events of the database, with their
+	// durations and sizes. Events are ignored if it is nil.
+	EventHook EventHook
+
+	// MaxReadTxAge is the age after which an open read transaction is
+	// reported, once, to the logger and as an EventLongReadTx event. Long
+	// read transactions keep the pages freed since they began from being
+	// reused, which grows the data file. Transactions are checked when a
+	// write transaction begins. It is disabled if zero.
+	MaxReadTxAge time.Duration
+
+	// TxStacks records the stack of the goroutine which begins each read
+	// transaction, for DB.OpenTxs and MaxReadTxAge reports. It makes
+	// beginning a transaction much slower, so it is meant for debugging.
+	TxStacks bool
 }
 
 // DefaultOptions represent the options used if nil options are passed into Open().
@@ -1244,6 +1767,10 @@ type Stats struct {
 	TxN     int // total number of started read transactions
 	OpenTxN int // number of currently open read transactions
 
+	// Size stats
+	MmapSize int // size of the memory map in bytes
+	FileSize int // size of the data file in bytes, as of the last remap or growth
+
 	TxStats TxStats // global, ongoing stats.
 }
 
@@ -1260,6 +1787,8 @@ func (s *Stats) Sub(other *Stats) Stats {
 	diff.FreeAlloc = s.FreeAlloc
 	diff.FreelistInuse = s.FreelistInuse
 	diff.TxN = s.TxN - other.TxN
+	diff.MmapSize = s.MmapSize
+	diff.FileSize = s.FileSize
 	diff.TxStats = s.TxStats.Sub(&other.TxStats)
 	return diff
 }
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 	"unsafe"
@@ -163,11 +164,11 @@ func TestOpen_ErrVersionMismatch(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Rewrite meta pages.
+	// Rewrite meta pages with a version that is not supported.
 	meta0 := (*meta)(unsafe.Pointer(&buf[pageHeaderSize]))
-	meta0.version++
+	meta0.version = common.VersionFeatures + 1
 	meta1 := (*meta)(unsafe.Pointer(&buf[pageSize+pageHeaderSize]))
-	meta1.version++
+	meta1.version = common.VersionFeatures + 1
 	if err := os.WriteFile(path, buf, 0666); err != nil {
 		t.Fatal(err)
 	}
@@ -509,6 +510,63 @@ func TestDB_Open_InitialMmapSize(t *testing.T) {
 	}
 }
 
+// Ensure that a write transaction which grows the database maps it again
+// without waiting for the open read transactions, which keep reading from the
+// previous mapping until they close.
+func TestDB_Remap_OpenReadTx(t *testing.T) {
+	var munmaps int32
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{
+		EventHook: bolt.EventHookFunc(func(e bolt.Event) {
+			if e.Type == bolt.EventMunmap {
+				atomic.AddInt32(&munmaps, 1)
+			}
+		}),
+	})
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+
+	rtx, err := db.Begin(false)
+	require.NoError(t, err)
+	mmapSize := db.Stats().MmapSize
+
+	done := make(chan error, 1)
+	go func() {
+		done <- db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			if err := b.Put([]byte("foo"), []byte("baz")); err != nil {
+				return err
+			}
+			return b.Put([]byte("big"), make([]byte, 16<<20))
+		})
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("the read transaction blocks the writer")
+	}
+	require.Greater(t, db.Stats().MmapSize, mmapSize)
+
+	// The reader still sees its snapshot, and its mapping is unmapped once
+	// it closes.
+	b := rtx.Bucket([]byte("widgets"))
+	require.Equal(t, []byte("bar"), b.Get([]byte("foo")))
+	require.Nil(t, b.Get([]byte("big")))
+	n := atomic.LoadInt32(&munmaps)
+	require.NoError(t, rtx.Rollback())
+	require.Equal(t, n+1, atomic.LoadInt32(&munmaps))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Len(t, tx.Bucket([]byte("widgets")).Get([]byte("big")), 16<<20)
+		return nil
+	}))
+}
+
 // TestDB_Open_ReadOnly checks a database in read only mode can read but not write.
 func TestDB_Open_ReadOnly(t *testing.T) {
 	// Create a writable db, write k-v and close it.
@@ -1321,16 +1379,9 @@ func TestDBUnmap(t *testing.T) {
 
 	require.NoError(t, db.DB.Close())
 
-	// Ignore the following error:
-	// Error: copylocks: call of reflect.ValueOf copies lock value: go.etcd.io/bbolt.DB contains sync.Once contains sync.Mutex (govet)
-	//nolint:govet
-	v := reflect.ValueOf(*db.DB)
-	dataref := v.FieldByName("dataref")
-	data := v.FieldByName("data")
-	datasz := v.FieldByName("datasz")
-	assert.True(t, dataref.IsNil())
-	assert.True(t, data.IsNil())
-	assert.True(t, datasz.IsZero())
+	v := reflect.ValueOf(db.DB).Elem()
+	mapping := v.FieldByName("mapping")
+	assert.True(t, mapping.IsNil())
 
 	// Set db.DB to nil to prevent MustCheck from panicking.
 	db.DB = nil
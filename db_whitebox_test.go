@@ -1,8 +1,15 @@
 package bbolt
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -112,6 +119,26 @@ func TestMethodPage(t *testing.T) {
 	}
 }
 
+// Ensure that a read transaction stops waiting for the mmap lock when its
+// context is done.
+func TestDB_BeginContext_MmapLock(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	db.mmaplock.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = db.BeginContext(ctx, false)
+	db.mmaplock.Unlock()
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The meta lock was released, so transactions can still be started.
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+	require.NoError(t, tx.Rollback())
+}
+
 func prepareData(t *testing.T) (string, error) {
 	fileName := filepath.Join(t.TempDir(), "db")
 	db, err := Open(fileName, 0666, nil)
@@ -124,3 +151,497 @@ func prepareData(t *testing.T) (string, error) {
 
 	return fileName, nil
 }
+
+// Ensure that a bucket whose codec is not registered can not be opened.
+func TestBucket_UnknownCodec(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	RegisterCodec(nopCodec{})
+	defer func() {
+		codecsMu.Lock()
+		delete(codecs, nopCodec{}.ID())
+		codecsMu.Unlock()
+	}()
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &BucketOptions{Codec: nopCodec{}})
+		if err != nil {
+			return err
+		}
+		nested, err := b.CreateBucketWithOptions([]byte("nested"), &BucketOptions{Codec: nopCodec{}})
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := nested.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+
+	codecsMu.Lock()
+	delete(codecs, nopCodec{}.ID())
+	codecsMu.Unlock()
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.Nil(t, tx.Bucket([]byte("widgets")))
+		_, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+		require.ErrorIs(t, err, common.ErrUnknownCodec)
+
+		// ForEach stops at the bucket instead of passing a nil bucket.
+		err = tx.ForEach(func(name []byte, b *Bucket) error {
+			require.NotNil(t, b)
+			return nil
+		})
+		require.ErrorIs(t, err, common.ErrUnknownCodec)
+
+		// Stats do not need the codec.
+		require.Equal(t, 1003, tx.root.Stats().KeyN)
+		return nil
+	}))
+
+	// Shrink stops at the bucket instead of panicking.
+	require.ErrorIs(t, db.Shrink(), common.ErrUnknownCodec)
+}
+
+// Ensure that Check reports counters of a bucket which do not match its
+// contents.
+func TestTx_Check_BucketCounters(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &BucketOptions{Counted: true})
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			return err
+		}
+		b.ext.KeyN, b.ext.ValueBytes = 5, 1
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		var errs []string
+		for err := range tx.Check() {
+			errs = append(errs, err.Error())
+		}
+		require.Equal(t, []string{
+			"bucket 77696467657473: key count 5 does not match 1 keys",
+			"bucket 77696467657473: value size 1 does not match 3 bytes",
+		}, errs)
+		return nil
+	}))
+}
+
+// Ensure that a bucket whose comparator is not registered can not be opened.
+func TestBucket_UnknownComparator(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	RegisterComparator(nopComparator{})
+	unregister := func() {
+		comparatorsMu.Lock()
+		delete(comparators, nopComparator{}.Name())
+		comparatorsMu.Unlock()
+	}
+	defer unregister()
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &BucketOptions{Comparator: nopComparator{}})
+		if err != nil {
+			return err
+		}
+		nested, err := b.CreateBucket([]byte("nested"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+			if err := nested.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	unregister()
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		require.Nil(t, tx.Bucket([]byte("widgets")))
+		_, err := tx.LookupBucket([]byte("widgets"))
+		require.ErrorIs(t, err, common.ErrUnknownComparator)
+		_, err = tx.CreateBucketIfNotExists([]byte("widgets"))
+		require.ErrorIs(t, err, common.ErrUnknownComparator)
+		require.ErrorIs(t, tx.DeleteBucket([]byte("widgets")), common.ErrUnknownComparator)
+		return nil
+	}))
+
+	// Check reports the comparator instead of the pages of the bucket.
+	require.NoError(t, db.View(func(tx *Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		require.Len(t, errs, 1)
+		require.ErrorIs(t, errs[0], common.ErrUnknownComparator)
+		return nil
+	}))
+}
+
+// Ensure that Shrink returns an error instead of panicking when a bucket's
+// comparator is not registered.
+func TestDB_Shrink_UnknownComparator(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	RegisterComparator(nopComparator{})
+	unregister := func() {
+		comparatorsMu.Lock()
+		delete(comparators, nopComparator{}.Name())
+		comparatorsMu.Unlock()
+	}
+	defer unregister()
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucketWithOptions([]byte("widgets"), &BucketOptions{Comparator: nopComparator{}})
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	unregister()
+	require.ErrorIs(t, db.Shrink(), common.ErrUnknownComparator)
+
+	// The transaction was rolled back, so the bucket is still intact.
+	RegisterComparator(nopComparator{})
+	require.NoError(t, db.View(func(tx *Tx) error {
+		require.Equal(t, 1000, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	}))
+}
+
+type nopComparator struct{}
+
+func (nopComparator) Name() string { return "nop" }
+
+func (nopComparator) Compare(a, b []byte) int { return bytes.Compare(a, b) }
+
+type nopCodec struct{}
+
+func (nopCodec) ID() uint8 { return 201 }
+
+func (nopCodec) Compress(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+
+func (nopCodec) Decompress(dst, src []byte) ([]byte, error) { return append(dst, src...), nil }
+
+// Ensure that the buckets of databases created by earlier versions whose
+// names start with the prefix of the internal buckets stay usable, and that
+// the other databases reserve the prefix once written.
+func TestOpen_ReservedNames(t *testing.T) {
+	for _, legacyBucket := range []bool{true, false} {
+		path := filepath.Join(t.TempDir(), "db")
+		db, err := Open(path, 0600, nil)
+		require.NoError(t, err)
+		require.True(t, db.reservedNames)
+
+		// Write the database like an earlier version.
+		db.reservedNames = false
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			tx.meta.SetFlags(tx.meta.Flags() &^ common.MetaReservedNamesFlag)
+			if !legacyBucket {
+				return nil
+			}
+			b, err := tx.CreateBucket([]byte("\x00bbolt.legacy"))
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("foo"), []byte("bar"))
+		}))
+		require.NoError(t, db.Close())
+
+		db, err = Open(path, 0600, nil)
+		require.NoError(t, err)
+		require.Equal(t, !legacyBucket, db.reservedNames)
+		require.NoError(t, db.Update(func(tx *Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			require.NoError(t, err)
+			if !legacyBucket {
+				return b.PutWithTTL([]byte("foo"), []byte("bar"), time.Hour)
+			}
+
+			require.Equal(t, []byte("bar"), tx.Bucket([]byte("\x00bbolt.legacy")).Get([]byte("foo")))
+			_, err = tx.CreateBucket([]byte("\x00bbolt.other"))
+			require.NoError(t, err)
+			require.Equal(t, common.ErrReservedNamesInUse, b.PutWithTTL([]byte("foo"), []byte("bar"), time.Hour))
+			return nil
+		}))
+		if legacyBucket {
+			require.Equal(t, common.ErrReservedNamesInUse, db.DeclareIndex("tags", [][]byte{[]byte("widgets")}, func(k, v []byte) [][]byte { return nil }))
+
+			dstPath := filepath.Join(t.TempDir(), "compacted")
+			dst, err := Open(dstPath, 0600, nil)
+			require.NoError(t, err)
+			require.NoError(t, Compact(dst, db, 0))
+			require.NoError(t, dst.View(func(tx *Tx) error {
+				require.Equal(t, []byte("bar"), tx.Bucket([]byte("\x00bbolt.legacy")).Get([]byte("foo")))
+				return nil
+			}))
+			require.NoError(t, dst.Close())
+			dst, err = Open(dstPath, 0600, nil)
+			require.NoError(t, err)
+			require.False(t, dst.reservedNames)
+			require.NoError(t, dst.Close())
+		} else {
+			require.NotZero(t, db.meta().Flags()&common.MetaReservedNamesFlag)
+		}
+		require.NoError(t, db.Close())
+	}
+}
+
+// Ensure that page writes beyond the tracking limit forget the oldest writes
+// and fall back to full copies for transactions before them.
+func TestPageWrites_Forget(t *testing.T) {
+	var pw pageWrites
+	pw.reset(1)
+	pw.limit = 4
+
+	for txid := common.Txid(2); txid <= 6; txid++ {
+		p := &common.Page{}
+		p.SetId(common.Pgid(txid))
+		pw.record(txid, common.Pages{p})
+	}
+
+	require.Equal(t, common.Txid(4), pw.base)
+	require.Equal(t, []pageRun{{id: 2, count: 8}}, pw.changedSince(3, 10))
+	require.Equal(t, []pageRun{{id: 5, count: 2}}, pw.changedSince(4, 10))
+}
+
+// Ensure that commits write runs of pages through the vectored write hook,
+// counting the buffers written, and fail with its error.
+func TestTx_WritePages_Writev(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var bufs, calls int
+	db.ops.writevAt = func(b [][]byte, off int64) (int, error) {
+		for _, buf := range b {
+			if _, err := db.ops.writeAt(buf, off); err != nil {
+				return calls, err
+			}
+			off += int64(len(buf))
+		}
+		bufs += len(b)
+		calls++
+		return 1, nil
+	}
+	tx, err := db.Begin(true)
+	require.NoError(t, err)
+	b, err := tx.CreateBucket([]byte("widgets"))
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, b.Put([]byte{byte(i)}, make([]byte, 100)))
+	}
+	require.NoError(t, tx.Commit())
+
+	// The meta page is written on its own.
+	stats := tx.Stats()
+	require.Positive(t, calls)
+	require.Equal(t, int64(bufs), stats.GetWrite()-1)
+	require.Equal(t, int64(calls), stats.GetWriteCalls()-1)
+
+	errWrite := errors.New("write failed")
+	db.ops.writevAt = func(b [][]byte, off int64) (int, error) {
+		return 1, errWrite
+	}
+	err = db.Update(func(tx *Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	})
+	require.ErrorIs(t, err, errWrite)
+}
+
+// Ensure that the free pages trimmed by Shrink are given back to the freelist
+// when the transaction is rolled back.
+func TestTx_Shrink_Rollback(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("large"), make([]byte, 10*db.pageSize))
+	}))
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.DeleteBucket([]byte("widgets"))
+	}))
+
+	var free []common.Pgid
+	errRollback := errors.New("rollback")
+	require.ErrorIs(t, db.Update(func(tx *Tx) error {
+		free = append(free, db.freelist.getFreePageIDs()...)
+		trimmed, _, err := tx.shrink()
+		require.NoError(t, err)
+		require.Positive(t, trimmed)
+		return errRollback
+	}), errRollback)
+	require.Equal(t, free, db.freelist.getFreePageIDs())
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+		return nil
+	}))
+}
+
+// Ensure that a scoped transaction writes the pages of its buckets without
+// holding the writer lock, and gives the pages it claimed back to the freelist
+// when writing them fails.
+func TestDB_BeginBuckets_WriteUnlocked(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}))
+
+	// Count the writes made with and without the writer lock.
+	var locked, unlocked int
+	var errWrite error
+	writeAt := db.ops.writeAt
+	db.ops.writevAt = nil
+	db.ops.writeAt = func(b []byte, off int64) (int, error) {
+		if !db.rwlock.TryLock() {
+			locked++
+			return writeAt(b, off)
+		}
+		db.rwlock.Unlock()
+		unlocked++
+		if errWrite != nil {
+			return 0, errWrite
+		}
+		return writeAt(b, off)
+	}
+	put := func() error {
+		return db.UpdateBuckets([][]byte{[]byte("widgets")}, func(tx *Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			for i := 0; i < 1000; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	require.NoError(t, put())
+
+	// Only the root bucket, the freelist and the meta page are written while
+	// holding the lock.
+	require.Positive(t, unlocked)
+	require.Equal(t, 3, locked)
+
+	errWrite = errors.New("write failed")
+	require.ErrorIs(t, put(), errWrite)
+	errWrite = nil
+	require.Empty(t, db.freelist.claimed)
+	require.Empty(t, db.scopeTxids)
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	}))
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+		require.Equal(t, 1001, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	}))
+}
+
+// Ensure that scoped transactions write their buckets while another one is
+// committing, and that their commits are applied in the order they began.
+func TestDB_BeginBuckets_CommitOrder(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "db"), 0666, nil)
+	require.NoError(t, err)
+	defer db.Close()
+	require.NoError(t, db.Update(func(tx *Tx) error {
+		for _, name := range []string{"a", "b"} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	put := func(name string) (txid int, err error) {
+		err = db.UpdateBuckets([][]byte{[]byte(name)}, func(tx *Tx) error {
+			tx.OnCommitChanges(func(cs *ChangeSet) { txid = cs.Txid })
+			b := tx.Bucket([]byte(name))
+			for i := 0; i < 1000; i++ {
+				if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		return txid, err
+	}
+
+	// The first write of "a" waits until "b" wrote its pages, and is waiting
+	// for "a" to commit.
+	var writes atomic.Int64
+	var once sync.Once
+	var txidB int
+	var errB error
+	done := make(chan struct{})
+	writeAt := db.ops.writeAt
+	db.ops.writevAt = nil
+	db.ops.writeAt = func(b []byte, off int64) (int, error) {
+		writes.Add(1)
+		once.Do(func() {
+			go func() {
+				defer close(done)
+				txidB, errB = put("b")
+			}()
+			for start := time.Now(); writes.Load() == 1; time.Sleep(time.Millisecond) {
+				if time.Since(start) > 5*time.Second {
+					t.Error("b did not write while a was committing")
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			select {
+			case <-done:
+				t.Error("b committed before a")
+			default:
+			}
+		})
+		return writeAt(b, off)
+	}
+	txidA, err := put("a")
+	require.NoError(t, err)
+	<-done
+	require.NoError(t, errB)
+	require.Equal(t, txidA+1, txidB)
+
+	require.NoError(t, db.View(func(tx *Tx) error {
+		for err := range tx.Check() {
+			return err
+		}
+		require.Equal(t, 1000, tx.Bucket([]byte("a")).Stats().KeyN)
+		require.Equal(t, 1000, tx.Bucket([]byte("b")).Stats().KeyN)
+		return nil
+	}))
+}
@@ -0,0 +1,148 @@
+package bbolt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// KeyProvider provides the key which encrypts a database. See
+// Options.Encryption.
+type KeyProvider interface {
+	// Key returns the AES key of the database, which is 16, 24 or 32 bytes
+	// long. It is called once, when the database is opened.
+	Key() ([]byte, error)
+}
+
+// StaticKey is a KeyProvider which returns itself as the key.
+type StaticKey []byte
+
+// Key returns the key.
+func (k StaticKey) Key() ([]byte, error) {
+	return k, nil
+}
+
+// Every page but the meta pages of an encrypted database is sealed with
+// AES-GCM, except for its header. The page ends with a trailer holding the tag,
+// the random nonce, and the id of the transaction which wrote the page,
+// followed by the trailer of the page in format versions 3 and 4. The header and
+// the transaction id are authenticated as additional data, so that a page can
+// not be moved to another id, or replaced by an older version of itself
+// without being detected.
+const (
+	encryptionTagSize     = 16
+	encryptionNonceSize   = 12
+	encryptionTrailerSize = encryptionTagSize + encryptionNonceSize + 8
+)
+
+// newPageCipher returns the AEAD sealing the pages of a database, with the key
+// of a key provider.
+func newPageCipher(kp KeyProvider) (cipher.AEAD, error) {
+	key, err := kp.Key()
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+	return cipher.NewGCMWithNonceSize(block, encryptionNonceSize)
+}
+
+// encryptPage encrypts a page in place, before it is written by transaction
+// txid. The checksum of the page, if any, must be set afterwards.
+func (db *DB) encryptPage(p *common.Page, txid common.Txid) error {
+	body, trailer := db.splitEncryptedPage(p)
+	nonce := trailer[encryptionTagSize : encryptionTagSize+encryptionNonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("encrypt page %d: %w", p.Id(), err)
+	}
+	binary.LittleEndian.PutUint64(trailer[encryptionTagSize+encryptionNonceSize:], uint64(txid))
+
+	// The tag is appended to the encrypted body, at the start of the trailer.
+	db.cipher.Seal(body[:0], nonce, body, pageAdditionalData(p, trailer))
+	return nil
+}
+
+// decryptPage returns a decrypted copy of an encrypted page of a mapping.
+func (db *DB) decryptPage(m *mapping, p *common.Page) (*common.Page, error) {
+	id := p.Id()
+	sz := (int(p.Overflow()) + 1) * db.pageSize
+	if int(id)*db.pageSize+sz > m.size {
+		// The overflow count is corrupted, so the trailer can not be found.
+		return nil, &common.CorruptionError{Pgid: id, Err: common.ErrDecryption}
+	}
+	buf := make([]byte, sz)
+	copy(buf, common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, sz))
+
+	dp := (*common.Page)(unsafe.Pointer(&buf[0]))
+	body, trailer := db.splitEncryptedPage(dp)
+	nonce := trailer[encryptionTagSize : encryptionTagSize+encryptionNonceSize]
+	sealed := buf[common.PageHeaderSize : len(body)+int(common.PageHeaderSize)+encryptionTagSize]
+	if _, err := db.cipher.Open(body[:0], nonce, sealed, pageAdditionalData(dp, trailer)); err != nil {
+		return nil, &common.CorruptionError{Pgid: id, Err: common.ErrDecryption}
+	}
+	return dp, nil
+}
+
+// splitEncryptedPage returns the encrypted body and the encryption trailer of
+// a page.
+func (db *DB) splitEncryptedPage(p *common.Page) (body, trailer []byte) {
+	end := (int(p.Overflow()) + 1) * db.pageSize
+	if db.pageChecksums {
+		end -= common.PageChecksumSize
+	}
+	if db.pageTxids {
+		end -= common.PageTxidSize
+	}
+	buf := common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, end)
+	start := end - encryptionTrailerSize
+	return buf[common.PageHeaderSize:start], buf[start:end]
+}
+
+// pageAdditionalData returns the data authenticated along a page: its header
+// and the id of the transaction which wrote it.
+func pageAdditionalData(p *common.Page, trailer []byte) []byte {
+	ad := make([]byte, 0, common.PageHeaderSize+8)
+	ad = append(ad, common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, int(common.PageHeaderSize))...)
+	return append(ad, trailer[encryptionTagSize+encryptionNonceSize:]...)
+}
+
+// readPage returns the page with the given id from a mapping after verifying
+// its checksum, if any. Pages of encrypted databases are decrypted into a new
+// buffer.
+func (db *DB) readPage(m *mapping, id common.Pgid) (*common.Page, error) {
+	if err := db.checkPage(m, id); err != nil {
+		return nil, err
+	}
+	p := m.page(id, db.pageSize)
+	if db.cipher == nil || id <= 1 {
+		return p, nil
+	}
+	return db.decryptPage(m, p)
+}
+
+// decryptedPage returns a page of an encrypted database, decrypting it into
+// the page cache of the transaction the first time it is read.
+func (tx *Tx) decryptedPage(id common.Pgid) (*common.Page, error) {
+	tx.decryptedMu.Lock()
+	defer tx.decryptedMu.Unlock()
+
+	if p, ok := tx.decrypted[id]; ok {
+		return p, nil
+	}
+	p, err := tx.db.readPage(tx.view(), id)
+	if err != nil {
+		return nil, err
+	}
+	if tx.decrypted == nil {
+		tx.decrypted = make(map[common.Pgid]*common.Page)
+	}
+	tx.decrypted[id] = p
+	return p, nil
+}
@@ -0,0 +1,158 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+var testKey = bolt.StaticKey(bytes.Repeat([]byte{0x42}, 32))
+
+// Ensure that an encrypted database can be written and read back, and that
+// its data file does not contain the plaintext.
+func TestDB_Encryption(t *testing.T) {
+	for _, version := range []int{common.Version, common.VersionChecksums, common.VersionFeatures} {
+		t.Run(fmt.Sprintf("version-%d", version), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{Encryption: testKey, FormatVersion: version})
+			require.NoError(t, db.Fill([]byte("widgets"), 5, 1000,
+				func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, key)) },
+				func(tx int, key int) []byte { return []byte("secret-value") },
+			))
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.Bucket([]byte("widgets")).CreateBucket([]byte("nested"))
+				if err != nil {
+					return err
+				}
+				// A value which spans overflow pages.
+				return b.Put([]byte("large"), bytes.Repeat([]byte("secret-value"), db.Info().PageSize))
+			}))
+			db.MustCheck()
+			db.MustClose()
+
+			data, err := os.ReadFile(db.Path())
+			require.NoError(t, err)
+			require.False(t, bytes.Contains(data, []byte("secret-value")))
+			require.False(t, bytes.Contains(data, []byte("widgets")))
+
+			db.MustReopen()
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				return tx.Bucket([]byte("widgets")).Delete([]byte("00000000"))
+			}))
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.Equal(t, 5001, b.Stats().KeyN)
+				require.Equal(t, []byte("secret-value"), b.Get([]byte("00010001")))
+				require.Len(t, b.Bucket([]byte("nested")).Get([]byte("large")), 12*db.Info().PageSize)
+				return nil
+			}))
+		})
+	}
+}
+
+// Ensure that an encrypted database can only be opened with its key.
+func TestOpen_Encryption_Key(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db, err := bolt.Open(path, 0600, &bolt.Options{Encryption: testKey})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = bolt.Open(path, 0600, nil)
+	require.ErrorIs(t, err, common.ErrEncrypted)
+	_, err = bolt.Open(path, 0600, &bolt.Options{Encryption: bolt.StaticKey(bytes.Repeat([]byte{0x43}, 32))})
+	require.ErrorIs(t, err, common.ErrEncryptionKey)
+	_, err = bolt.Open(path, 0600, &bolt.Options{Encryption: bolt.StaticKey("short")})
+	require.Error(t, err)
+
+	plainPath := filepath.Join(t.TempDir(), "plain")
+	db, err = bolt.Open(plainPath, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	_, err = bolt.Open(plainPath, 0600, &bolt.Options{Encryption: testKey})
+	require.ErrorIs(t, err, common.ErrNotEncrypted)
+}
+
+// Ensure that reading a modified page of an encrypted database returns a
+// corruption error.
+func TestDB_Encryption_Tampering(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Encryption: testKey})
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 1000,
+		func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d", key)) },
+		func(tx int, key int) []byte { return make([]byte, 100) },
+	))
+
+	// Find a leaf page of the bucket.
+	var leaf int
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		for id := 4; ; id++ {
+			p, err := tx.Page(id)
+			require.NoError(t, err)
+			if p.Type == "leaf" {
+				leaf = id
+				return nil
+			}
+		}
+	}))
+	pageSize := db.Info().PageSize
+	db.MustClose()
+
+	// Flip a bit in the middle of the page.
+	f, err := os.OpenFile(db.Path(), os.O_RDWR, 0)
+	require.NoError(t, err)
+	b := make([]byte, 1)
+	off := int64(leaf*pageSize + pageSize/2)
+	_, err = f.ReadAt(b, off)
+	require.NoError(t, err)
+	b[0] ^= 0x01
+	_, err = f.WriteAt(b, off)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	db.MustReopen()
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).ForEach(func(k, v []byte) error { return nil })
+	})
+	require.ErrorIs(t, err, common.ErrDecryption)
+	var cerr *common.CorruptionError
+	require.True(t, errors.As(err, &cerr))
+	require.Equal(t, common.Pgid(leaf), cerr.Pgid)
+
+	// Skip the consistency check of the test cleanup.
+	db.MustClose()
+}
+
+// Ensure that the key of a database can be rotated with Compact.
+func TestCompact_Encryption(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Encryption: testKey})
+	require.NoError(t, db.Fill([]byte("widgets"), 2, 1000,
+		func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, key)) },
+		func(tx int, key int) []byte { return []byte("secret-value") },
+	))
+
+	newKey := bolt.StaticKey(bytes.Repeat([]byte{0x43}, 16))
+	path := filepath.Join(t.TempDir(), "rotated")
+	dst, err := bolt.Open(path, 0600, &bolt.Options{Encryption: newKey})
+	require.NoError(t, err)
+	require.NoError(t, bolt.Compact(dst, db.DB, 0))
+	require.NoError(t, dst.Close())
+
+	_, err = bolt.Open(path, 0600, &bolt.Options{Encryption: testKey})
+	require.ErrorIs(t, err, common.ErrEncryptionKey)
+	dst, err = bolt.Open(path, 0600, &bolt.Options{Encryption: newKey})
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 2000, b.Stats().KeyN)
+		require.Equal(t, []byte("secret-value"), b.Get([]byte("00010999")))
+		return nil
+	}))
+}
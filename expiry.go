@@ -0,0 +1,335 @@
+package bbolt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// reservedPrefix starts the names of the internal buckets in the root of the
+// database. They are hidden from cursors, and names starting with it can not
+// be used for other buckets in the root, unless the database was created by an
+// earlier version which did not reserve them and has buckets using them.
+var reservedPrefix = []byte("\x00bbolt.")
+
+// expiryBucketName is the name of the bucket in the root of the database which
+// indexes the keys with an expiry time.
+var expiryBucketName = []byte("\x00bbolt.expiry")
+
+// isReservedName returns whether name is the name of an internal bucket, which
+// can not be used for the buckets in the root of the database.
+func (db *DB) isReservedName(name []byte) bool {
+	return db.reservedNames && bytes.HasPrefix(name, reservedPrefix)
+}
+
+// isReservedName is like DB.isReservedName. It returns false once the
+// transaction is closed.
+func (tx *Tx) isReservedName(name []byte) bool {
+	return tx.db != nil && tx.db.isReservedName(name)
+}
+
+// reserveNames sets whether the database reserves the names of the internal
+// buckets, which the databases created by earlier versions do unless they have
+// buckets using them.
+func (db *DB) reserveNames() error {
+	if db.meta().Flags()&common.MetaReservedNamesFlag != 0 {
+		db.reservedNames = true
+		return nil
+	}
+	return db.View(func(tx *Tx) error {
+		k, _, _ := tx.root.Cursor().seek(reservedPrefix)
+		if bytes.HasPrefix(k, reservedPrefix) {
+			db.logger.Warn("reserved bucket names in use, TTLs and indexes disabled", "bucket", string(k))
+			return nil
+		}
+		db.reservedNames = true
+		return nil
+	})
+}
+
+// expireSweepTxSize is the number of expired keys deleted by each transaction
+// of ExpireSweep.
+const expireSweepTxSize = 1000
+
+// PutWithTTL sets the value for a key in the bucket, like Put, and makes the
+// key expire once ttl has passed. Expired keys are hidden from Get and cursors,
+// and are deleted by DB.ExpireSweep. Putting the key again without a TTL
+// removes its expiry time. Putting a key with a TTL raises the format version of
+// the database to 4, which older versions of bbolt refuse to open.
+// Returns an error if ttl is not positive, if the database was created by an
+// earlier version and has buckets using the names of the internal buckets, or
+// for the same reasons as Put.
+func (b *Bucket) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return common.ErrInvalidTTL
+	}
+	return b.put(key, value, time.Now().Add(ttl).UnixNano())
+}
+
+// indexExpiry adds a key of the bucket, which expires at the given time, to
+// the expiry index.
+func (b *Bucket) indexExpiry(key []byte, expires int64) error {
+	k := binary.BigEndian.AppendUint64(nil, uint64(expires))
+	k = appendPath(k, b.path())
+	k = append(k, key...)
+	if len(k) > MaxKeySize {
+		return common.ErrKeyTooLarge
+	}
+
+	return b.tx.untracked(func() error {
+		index, err := b.tx.root.CreateBucketIfNotExists(expiryBucketName)
+		if err != nil {
+			return err
+		}
+		return index.Put(k, nil)
+	})
+}
+
+// movedExpiryEntries returns the entries of the expiry index for the keys of
+// the bucket at the path from and of the buckets nested in it, and the entries
+// replacing them once the bucket is moved to the path to.
+func (tx *Tx) movedExpiryEntries(from, to [][]byte) (oldEntries, newEntries [][]byte, err error) {
+	index := tx.root.Bucket(expiryBucketName)
+	if index == nil || !tx.db.reservedNames {
+		return nil, nil, nil
+	}
+
+	c := index.Cursor()
+	for k, _, _ := c.first(); k != nil; k, _, _ = c.next() {
+		path, key, err := readExpiryEntry(k)
+		if err != nil {
+			return nil, nil, err
+		} else if len(path) < len(from) || !pathHasPrefix(path, from) {
+			continue
+		}
+
+		entry := append([]byte(nil), k[:8]...)
+		entry = appendPath(entry, append(to[:len(to):len(to)], path[len(from):]...))
+		entry = append(entry, key...)
+		if len(entry) > MaxKeySize {
+			return nil, nil, common.ErrKeyTooLarge
+		}
+		oldEntries = append(oldEntries, cloneBytes(k))
+		newEntries = append(newEntries, entry)
+	}
+	return oldEntries, newEntries, nil
+}
+
+// replaceExpiryEntries replaces entries of the expiry index, after the bucket
+// holding their keys was moved.
+func (tx *Tx) replaceExpiryEntries(oldEntries, newEntries [][]byte) error {
+	if len(oldEntries) == 0 {
+		return nil
+	}
+	return tx.untracked(func() error {
+		index := tx.root.Bucket(expiryBucketName)
+		for _, k := range oldEntries {
+			if err := index.Delete(k); err != nil {
+				return err
+			}
+		}
+		for _, k := range newEntries {
+			if err := index.Put(k, nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// expiresAt returns the expiry time of a value stored with ExpiringLeafFlag.
+func expiresAt(v []byte) int64 {
+	return int64(binary.BigEndian.Uint64(v))
+}
+
+// now returns the time, in nanoseconds since the epoch, against which the
+// transaction checks if keys have expired. It is fixed when it is first
+// needed, so that keys do not disappear in the middle of a transaction.
+func (tx *Tx) now() int64 {
+	if tx.expiryNow == 0 {
+		tx.expiryNow = time.Now().UnixNano()
+	}
+	return tx.expiryNow
+}
+
+// untracked runs fn without recording its changes in the change set of the
+// transaction, nor for the watchers.
+func (tx *Tx) untracked(fn func() error) error {
+	changes, watching := tx.changes, tx.watching
+	tx.changes, tx.watching = nil, false
+	defer func() { tx.changes, tx.watching = changes, watching }()
+	return fn()
+}
+
+// hidden reports whether an element of the bucket of the cursor is hidden from
+// the users of the cursor, because it has expired or is an internal bucket.
+func (c *Cursor) hidden(key, value []byte, flags uint32) bool {
+	if flags&common.ExpiringLeafFlag != 0 {
+		return expiresAt(value) <= c.bucket.tx.now()
+	}
+	return flags&common.BucketLeafFlag != 0 && c.bucket == &c.bucket.tx.root && c.bucket.tx.isReservedName(key)
+}
+
+// ExpireSweep deletes up to limit expired keys, or all of them if limit is not
+// positive, and returns the number of keys deleted. Keys are deleted by write
+// transactions of bounded size, so that a sweep does not hold the writer lock
+// for long. If an error occurs, the keys deleted by the transactions committed
+// before it stay deleted.
+func (db *DB) ExpireSweep(limit int) (int, error) {
+	var n int
+	for limit <= 0 || n < limit {
+		size := expireSweepTxSize
+		if limit > 0 && limit-n < size {
+			size = limit - n
+		}
+
+		var deleted int
+		var done bool
+		if err := db.Update(func(tx *Tx) error {
+			var err error
+			deleted, done, err = tx.expireSweep(size)
+			return err
+		}); err != nil {
+			return n, err
+		}
+		n += deleted
+		if done {
+			break
+		}
+	}
+	return n, nil
+}
+
+// startExpirySweeper starts a goroutine which deletes expired keys every
+// interval until the database is closed.
+func (db *DB) startExpirySweeper(interval time.Duration) {
+	db.expiryStop, db.expiryDone = make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(db.expiryDone)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-db.expiryStop:
+				return
+			case <-t.C:
+			}
+
+			// Sweep one transaction at a time, so that closing the
+			// database does not wait for a large sweep.
+			for {
+				n, err := db.ExpireSweep(expireSweepTxSize)
+				if err != nil {
+					db.logger.Error("expire sweep failed", "error", err)
+					break
+				} else if n == 0 {
+					break
+				}
+				select {
+				case <-db.expiryStop:
+					return
+				default:
+				}
+			}
+		}
+	}()
+}
+
+// stopExpirySweeper stops the goroutine started by startExpirySweeper, if
+// any, and waits for it to return.
+func (db *DB) stopExpirySweeper() {
+	db.expiryStopOnce.Do(func() {
+		if db.expiryStop != nil {
+			close(db.expiryStop)
+			<-db.expiryDone
+		}
+	})
+}
+
+// expireSweep deletes up to limit expired keys. It returns the number of keys
+// deleted, and whether no expired keys are left.
+func (tx *Tx) expireSweep(limit int) (n int, done bool, err error) {
+	index := tx.root.Bucket(expiryBucketName)
+	if index == nil || !tx.db.reservedNames {
+		return 0, true, nil
+	}
+
+	// Collect the expired entries of the index first, since the cursor
+	// can not be used while the index is modified.
+	var entries [][]byte
+	c := index.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		if len(entries) == limit {
+			break
+		} else if expiresAt(k) > tx.now() {
+			done = true
+			break
+		}
+		entries = append(entries, k)
+	}
+	if err := c.Err(); err != nil {
+		return 0, false, err
+	}
+	if len(entries) < limit {
+		done = true
+	}
+
+	for _, k := range entries {
+		deleted, err := tx.expire(k)
+		if err != nil {
+			return n, false, err
+		}
+		if deleted {
+			n++
+		}
+		if err := tx.untracked(func() error { return index.Delete(k) }); err != nil {
+			return n, false, err
+		}
+	}
+	return n, done, nil
+}
+
+// expire deletes the key of an entry of the expiry index, unless it was
+// deleted or written again since the entry was added. It returns whether the
+// key was deleted.
+func (tx *Tx) expire(entry []byte) (bool, error) {
+	path, key, err := readExpiryEntry(entry)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := tx.bucketAt(path)
+	if err == common.ErrBucketNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	k, v, flags := b.Cursor().seek(key)
+	if !bytes.Equal(k, key) || flags&common.ExpiringLeafFlag == 0 || expiresAt(v) != expiresAt(entry) {
+		return false, nil
+	}
+	return true, b.Delete(key)
+}
+
+// readExpiryEntry returns the bucket path and key of an entry of the expiry
+// index.
+func readExpiryEntry(entry []byte) (path [][]byte, key []byte, err error) {
+	b := entry[8:]
+	n, sz := binary.Uvarint(b)
+	if sz <= 0 {
+		return nil, nil, common.ErrInvalidExpiryIndex
+	}
+	b = b[sz:]
+	for i := uint64(0); i < n; i++ {
+		l, sz := binary.Uvarint(b)
+		if sz <= 0 || uint64(len(b)-sz) < l {
+			return nil, nil, common.ErrInvalidExpiryIndex
+		}
+		path = append(path, b[sz:sz+int(l)])
+		b = b[sz+int(l):]
+	}
+	return path, b, nil
+}
@@ -0,0 +1,262 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that expired keys are hidden from reads and deleted by sweeps, and
+// that keys which are written again without a TTL do not expire.
+func TestBucket_PutWithTTL(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	var got *bolt.ChangeSet
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		tx.OnCommitChanges(func(cs *bolt.ChangeSet) { got = cs })
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("plain")))
+		require.NoError(t, b.PutWithTTL([]byte("b"), []byte("short"), 50*time.Millisecond))
+		require.NoError(t, b.PutWithTTL([]byte("c"), []byte("long"), time.Hour))
+		require.NoError(t, b.PutWithTTL([]byte("d"), []byte("short"), 50*time.Millisecond))
+		require.NoError(t, b.PutWithTTL([]byte("e"), []byte("rewritten"), 50*time.Millisecond))
+		require.NoError(t, b.Put([]byte("e"), []byte("rewritten")))
+
+		gadgets, err := tx.CreateBucketWithOptions([]byte("gadgets"), &bolt.BucketOptions{Codec: bolt.FlateCodec})
+		require.NoError(t, err)
+		require.NoError(t, gadgets.PutWithTTL([]byte("x"), make([]byte, 1000), 50*time.Millisecond))
+		require.Equal(t, make([]byte, 1000), gadgets.Get([]byte("x")))
+
+		require.Equal(t, common.ErrInvalidTTL, b.PutWithTTL([]byte("f"), nil, 0))
+		require.Equal(t, []byte("short"), b.Get([]byte("b")))
+		return nil
+	}))
+	db.MustCheck()
+
+	// Writes to the expiry index are not recorded.
+	require.Len(t, got.Changes, 9)
+	for _, c := range got.Changes {
+		require.NotEqual(t, []byte("\x00bbolt.expiry"), c.Key)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Nil(t, b.Get([]byte("b")))
+		require.Equal(t, []byte("long"), b.Get([]byte("c")))
+		require.Nil(t, tx.Bucket([]byte("gadgets")).Get([]byte("x")))
+
+		var keys []string
+		require.NoError(t, b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		}))
+		require.Equal(t, []string{"a", "c", "e"}, keys)
+
+		c := b.Cursor()
+		k, _ := c.Seek([]byte("b"))
+		require.Equal(t, []byte("c"), k)
+		k, _ = c.Next()
+		require.Equal(t, []byte("e"), k)
+		k, _ = c.Prev()
+		require.Equal(t, []byte("c"), k)
+		k, _ = c.Prev()
+		require.Equal(t, []byte("a"), k)
+		k, _ = c.Last()
+		require.Equal(t, []byte("e"), k)
+
+		// Expired keys take up space until they are swept.
+		require.Equal(t, 5, b.Stats().KeyN)
+		return nil
+	}))
+
+	n, err := db.ExpireSweep(0)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 3, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	}))
+}
+
+// Ensure that sweeps delete at most the given number of keys.
+func TestDB_ExpireSweep_Limit(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 2500; i++ {
+			require.NoError(t, b.PutWithTTL([]byte(fmt.Sprintf("%04d", i)), []byte("value"), time.Millisecond))
+		}
+		return nil
+	}))
+	time.Sleep(10 * time.Millisecond)
+
+	n, err := db.ExpireSweep(1200)
+	require.NoError(t, err)
+	require.Equal(t, 1200, n)
+	n, err = db.ExpireSweep(0)
+	require.NoError(t, err)
+	require.Equal(t, 1300, n)
+	n, err = db.ExpireSweep(0)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	db.MustCheck()
+}
+
+// Ensure that expired keys are deleted in the background when a sweep interval
+// is set.
+func TestOpen_ExpireSweepInterval(t *testing.T) {
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{ExpireSweepInterval: 10 * time.Millisecond})
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		return b.PutWithTTL([]byte("foo"), []byte("bar"), time.Millisecond)
+	}))
+
+	require.Eventually(t, func() bool {
+		var keyN int
+		require.NoError(t, db.View(func(tx *bolt.Tx) error {
+			keyN = tx.Bucket([]byte("widgets")).Stats().KeyN
+			return nil
+		}))
+		return keyN == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// Ensure that the expiry index is hidden and can not be replaced.
+func TestTx_ExpiryIndex_Hidden(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	name := []byte("\x00bbolt.expiry")
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.PutWithTTL([]byte("foo"), []byte("bar"), time.Hour))
+
+		require.Nil(t, tx.Bucket(name))
+		_, err = tx.CreateBucket(name)
+		require.Equal(t, common.ErrBucketNameReserved, err)
+		require.Equal(t, common.ErrBucketNotFound, tx.DeleteBucket(name))
+		require.Equal(t, common.ErrBucketNameReserved, tx.MoveBucket([][]byte{[]byte("widgets")}, nil, name))
+
+		var names []string
+		require.NoError(t, tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, string(name))
+			return nil
+		}))
+		require.Equal(t, []string{"widgets"}, names)
+		return nil
+	}))
+	db.MustCheck()
+}
+
+// Ensure that Compact keeps the expiry times of the keys.
+func TestCompact_ExpiryTime(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.PutWithTTL([]byte("foo"), []byte("short"), 50*time.Millisecond))
+		require.NoError(t, b.PutWithTTL([]byte("bar"), []byte("long"), time.Hour))
+		return b.Put([]byte("baz"), []byte("forever"))
+	}))
+
+	dst, err := bolt.Open(filepath.Join(t.TempDir(), "compacted"), 0600, nil)
+	require.NoError(t, err)
+	defer dst.Close()
+	require.NoError(t, bolt.Compact(dst, db.DB, 0))
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Nil(t, b.Get([]byte("foo")))
+		require.Equal(t, []byte("long"), b.Get([]byte("bar")))
+		require.Equal(t, []byte("forever"), b.Get([]byte("baz")))
+		return nil
+	}))
+
+	n, err := dst.ExpireSweep(0)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+}
+
+// Ensure that the keys of a moved bucket, and of the buckets nested in it,
+// still expire, and that keys put at the old path do not.
+func TestTx_MoveBucket_ExpiryTime(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.PutWithTTL([]byte("foo"), []byte("bar"), 50*time.Millisecond))
+		child, err := b.CreateBucket([]byte("child"))
+		require.NoError(t, err)
+		return child.PutWithTTL([]byte("baz"), []byte("bat"), 50*time.Millisecond)
+	}))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("gadgets"))
+		require.NoError(t, err)
+		require.NoError(t, tx.MoveBucket([][]byte{[]byte("widgets")}, [][]byte{[]byte("gadgets")}, []byte("moved")))
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		return b.Put([]byte("foo"), []byte("permanent"))
+	}))
+
+	// A scoped transaction can not rewrite the expiry index.
+	require.Equal(t, common.ErrBucketNotDeclared, db.UpdateBuckets([][]byte{[]byte("gadgets")}, func(tx *bolt.Tx) error {
+		return tx.MoveBucket([][]byte{[]byte("gadgets"), []byte("moved")}, [][]byte{[]byte("gadgets")}, []byte("other"))
+	}))
+
+	time.Sleep(100 * time.Millisecond)
+	n, err := db.ExpireSweep(0)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		moved := tx.Bucket([]byte("gadgets")).Bucket([]byte("moved"))
+		require.Nil(t, moved.Get([]byte("foo")))
+		require.Equal(t, 0, moved.Bucket([]byte("child")).Stats().KeyN)
+		require.Equal(t, []byte("permanent"), tx.Bucket([]byte("widgets")).Get([]byte("foo")))
+		return nil
+	}))
+}
+
+// Ensure that putting a key with a TTL raises the format version, so that
+// older versions of bbolt do not read the expiry time as part of the value.
+func TestBucket_PutWithTTL_FormatVersion(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("a"), []byte("plain"))
+	}))
+	for _, m := range readMetas(t, db) {
+		require.Equal(t, uint32(common.Version), m.Version())
+	}
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).PutWithTTL([]byte("b"), []byte("short"), time.Hour)
+	}))
+	for _, m := range readMetas(t, db) {
+		require.Equal(t, uint32(common.VersionFeatures), m.Version())
+		require.NotZero(t, m.Flags()&common.MetaExpiryFlag)
+		require.Zero(t, m.Flags()&common.MetaBucketExtFlag)
+	}
+}
@@ -0,0 +1,511 @@
+package bbolt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// ExportFormat is the format of a logical dump written by Tx.Export.
+//
+// A dump is a stream of records: a header, one record per bucket with its
+// sequence, one record per key/value pair, and a trailer holding the number
+// of bucket and key/value records. Buckets and keys are listed in the order of
+// a depth first walk, every bucket before its contents. Records identify
+// their bucket by the full path of bucket names from the top level. The
+// entries of the indexes are listed as the buckets in the root holding them,
+// whose names start with "\x00bbolt.index.", so that the indexes do not need
+// to be rebuilt once the dump is imported.
+type ExportFormat int
+
+const (
+	// ExportJSON writes one JSON object per line. Names, keys and values are
+	// base64 encoded:
+	//
+	//	{"type":"header","version":2}
+	//	{"type":"bucket","path":["d2lkZ2V0cw=="],"sequence":3}
+	//	{"type":"kv","path":["d2lkZ2V0cw=="],"key":"Zm9v","value":"YmFy"}
+	//	{"type":"trailer","count":2}
+	//
+	// Bucket records have a "codec" field holding the codec ID of buckets
+	// with a codec, a "comparator" field holding the comparator name of
+	// buckets with a comparator, and a "counted" field set for counted
+	// buckets. Values are always written uncompressed.
+	// Key/value records of keys with a TTL have an "expires" field holding
+	// their expiry time, in nanoseconds since the epoch.
+	ExportJSON ExportFormat = iota
+
+	// ExportBinary writes the magic bytes "BBOLTEXP" and a version byte
+	// followed by records starting with a type byte: 'b' for buckets, 'k'
+	// for key/value pairs and 't' for the trailer. Integers are unsigned
+	// varints and byte strings are prefixed with their length. A path is its
+	// number of names followed by the names. A bucket record holds its path,
+	// sequence, codec ID byte, comparator name or an empty string, and a byte
+	// set to 1 for counted buckets, a key/value record its path, key, expiry time or 0 and value, and the
+	// trailer the number of records.
+	ExportBinary
+)
+
+// exportVersion is the version of the dump formats. Version 1 dumps, which do
+// not record the comparators of the buckets nor whether they are counted, are
+// still imported.
+const exportVersion = 2
+
+// exportMagic starts dumps in the binary format.
+const exportMagic = "BBOLTEXP"
+
+// importTxMaxSize is the size of the keys and values imported by each
+// transaction of DB.Import.
+const importTxMaxSize = 16 << 20
+
+// exportRecord is a record of a dump.
+type exportRecord struct {
+	Type       string   `json:"type"`
+	Version    int      `json:"version,omitempty"`
+	Path       [][]byte `json:"path,omitempty"`
+	Sequence   uint64   `json:"sequence,omitempty"`
+	Codec      uint8    `json:"codec,omitempty"`
+	Comparator string   `json:"comparator,omitempty"`
+	Counted    bool     `json:"counted,omitempty"`
+	Key        []byte   `json:"key,omitempty"`
+	Value      []byte   `json:"value"`
+	Expires    int64    `json:"expires,omitempty"`
+	Count      int      `json:"count,omitempty"`
+}
+
+// MarshalJSON writes the value of key/value records only, so that empty and
+// missing values can be told apart.
+func (r *exportRecord) MarshalJSON() ([]byte, error) {
+	type record exportRecord
+	if r.Type == "kv" {
+		return json.Marshal((*record)(r))
+	}
+	return json.Marshal(struct {
+		*record
+		Value []byte `json:"value,omitempty"`
+	}{record: (*record)(r)})
+}
+
+// Export writes a logical dump of all buckets, sequences and key/value pairs
+// of the transaction to w, in the given format. Unlike WriteTo, the dump does
+// not depend on the data file format, the page size or the byte order of the
+// machine, and can be read by other programs. DB.Import loads it back.
+//
+// Expired keys which are not swept yet are written with their expiry time,
+// since the indexes hold them too. They stay hidden once imported.
+func (tx *Tx) Export(w io.Writer, format ExportFormat) error {
+	if tx.db == nil {
+		return common.ErrTxClosed
+	}
+
+	bw := bufio.NewWriter(w)
+	var enc func(*exportRecord) error
+	switch format {
+	case ExportJSON:
+		je := json.NewEncoder(bw)
+		enc = func(r *exportRecord) error { return je.Encode(r) }
+	case ExportBinary:
+		if _, err := bw.WriteString(exportMagic); err != nil {
+			return err
+		}
+		enc = func(r *exportRecord) error { return writeBinaryRecord(bw, r) }
+	default:
+		return fmt.Errorf("unknown export format: %d", format)
+	}
+
+	if format == ExportJSON {
+		if err := enc(&exportRecord{Type: "header", Version: exportVersion}); err != nil {
+			return err
+		}
+	} else if err := bw.WriteByte(exportVersion); err != nil {
+		return err
+	}
+
+	var count int
+	if err := walkTx(tx, func(keys [][]byte, k, v []byte, expires int64, seq uint64, opts BucketOptions) error {
+		path := append(keys[:len(keys):len(keys)], k)
+		r := &exportRecord{Type: "bucket", Path: path, Sequence: seq}
+		if v != nil {
+			r = &exportRecord{Type: "kv", Path: keys, Key: k, Value: v, Expires: expires}
+		} else {
+			if opts.Codec != nil {
+				r.Codec = opts.Codec.ID()
+			}
+			if opts.Comparator != nil {
+				r.Comparator = opts.Comparator.Name()
+			}
+			r.Counted = opts.Counted
+		}
+		count++
+		return enc(r)
+	}); err != nil {
+		return err
+	}
+
+	if err := enc(&exportRecord{Type: "trailer", Count: count}); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Import loads a dump written by Tx.Export, in either format, into the
+// database. Buckets are created as needed and the key/value pairs of the dump
+// overwrite existing ones, keeping their expiry time.
+//
+// Large dumps are imported by several transactions. If an error occurs, the
+// buckets and keys imported by the transactions committed before it are kept.
+func (db *DB) Import(r io.Reader) error {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(exportMagic))
+	if err != nil && len(magic) == 0 {
+		return fmt.Errorf("%w: %v", common.ErrInvalidExport, err)
+	}
+
+	var dec func() (*exportRecord, error)
+	if bytes.Equal(magic, []byte(exportMagic)) {
+		_, _ = br.Discard(len(exportMagic))
+		version, err := br.ReadByte()
+		if err != nil || version < 1 || version > exportVersion {
+			return fmt.Errorf("%w: unsupported version", common.ErrInvalidExport)
+		}
+		dec = func() (*exportRecord, error) { return readBinaryRecord(br, int(version)) }
+	} else {
+		jd := json.NewDecoder(br)
+		dec = func() (*exportRecord, error) {
+			var r exportRecord
+			if err := jd.Decode(&r); err != nil {
+				return nil, err
+			}
+			return &r, nil
+		}
+		if r, err := dec(); err != nil || r.Type != "header" {
+			return fmt.Errorf("%w: missing header", common.ErrInvalidExport)
+		} else if r.Version < 1 || r.Version > exportVersion {
+			return fmt.Errorf("%w: unsupported version %d", common.ErrInvalidExport, r.Version)
+		}
+	}
+
+	im := &importer{db: db}
+	defer im.rollback()
+	for count := 0; ; count++ {
+		r, err := dec()
+		if err == io.EOF {
+			return fmt.Errorf("%w: missing trailer", common.ErrInvalidExport)
+		} else if err != nil {
+			return fmt.Errorf("%w: %v", common.ErrInvalidExport, err)
+		}
+
+		switch r.Type {
+		case "bucket":
+			err = im.createBucket(r)
+		case "kv":
+			err = im.put(r)
+		case "trailer":
+			if r.Count != count {
+				return fmt.Errorf("%w: %d records, trailer expects %d", common.ErrInvalidExport, count, r.Count)
+			}
+			return im.commit()
+		default:
+			err = fmt.Errorf("%w: unknown record type %q", common.ErrInvalidExport, r.Type)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// importer writes the records of a dump to a database, committing regularly.
+type importer struct {
+	db   *DB
+	tx   *Tx
+	size int
+
+	// The bucket of the previous record, which is usually the bucket of
+	// the next one.
+	path   [][]byte
+	bucket *Bucket
+}
+
+// begin makes sure a write transaction is open, committing the current one
+// if it has grown past importTxMaxSize.
+func (im *importer) begin(sz int) error {
+	if im.tx != nil && im.size+sz > importTxMaxSize {
+		if err := im.commit(); err != nil {
+			return err
+		}
+	}
+	if im.tx == nil {
+		tx, err := im.db.Begin(true)
+		if err != nil {
+			return err
+		}
+		im.tx, im.size, im.path, im.bucket = tx, 0, nil, nil
+	}
+	im.size += sz
+	return nil
+}
+
+func (im *importer) commit() error {
+	if im.tx == nil {
+		return nil
+	}
+	err := im.tx.Commit()
+	im.tx = nil
+	return err
+}
+
+func (im *importer) rollback() {
+	if im.tx != nil {
+		_ = im.tx.Rollback()
+		im.tx = nil
+	}
+}
+
+// lookup returns the bucket at the given path.
+func (im *importer) lookup(path [][]byte) (*Bucket, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%w: empty bucket path", common.ErrInvalidExport)
+	}
+	if im.bucket != nil && equalPaths(path, im.path) {
+		return im.bucket, nil
+	}
+	b := &im.tx.root
+	for _, name := range path {
+		child, err := b.bucket(name)
+		if err != nil {
+			return nil, err
+		} else if child == nil {
+			return nil, fmt.Errorf("bucket %x not found", name)
+		}
+		b = child
+	}
+	im.path, im.bucket = path, b
+	return b, nil
+}
+
+func (im *importer) createBucket(r *exportRecord) error {
+	if err := im.begin(0); err != nil {
+		return err
+	}
+	if len(r.Path) == 0 {
+		return fmt.Errorf("%w: empty bucket path", common.ErrInvalidExport)
+	}
+
+	// Only the buckets holding indexes can use the reserved names.
+	parent := &im.tx.root
+	if len(r.Path) == 1 && im.tx.isReservedName(r.Path[0]) && !isIndexBucket(r.Path[0]) {
+		return common.ErrBucketNameReserved
+	} else if len(r.Path) > 1 {
+		var err error
+		if parent, err = im.lookup(r.Path[:len(r.Path)-1]); err != nil {
+			return err
+		}
+	}
+
+	opts := BucketOptions{Counted: r.Counted}
+	if r.Codec != 0 {
+		if opts.Codec = lookupCodec(r.Codec); opts.Codec == nil {
+			return fmt.Errorf("%w: %d", common.ErrUnknownCodec, r.Codec)
+		}
+	}
+	if r.Comparator != "" {
+		if opts.Comparator = lookupComparator(r.Comparator); opts.Comparator == nil {
+			return fmt.Errorf("%w: %q", common.ErrUnknownComparator, r.Comparator)
+		}
+	}
+	name := r.Path[len(r.Path)-1]
+	b, err := parent.CreateBucketWithOptions(name, &opts)
+	if err == common.ErrBucketExists {
+		b, err = parent.bucket(name)
+	}
+	if err != nil {
+		return err
+	}
+	return b.SetSequence(r.Sequence)
+}
+
+func (im *importer) put(r *exportRecord) error {
+	if err := im.begin(len(r.Key) + len(r.Value)); err != nil {
+		return err
+	}
+	if r.Expires < 0 {
+		return fmt.Errorf("%w: negative expiry time", common.ErrInvalidExport)
+	}
+	b, err := im.lookup(r.Path)
+	if err != nil {
+		return err
+	}
+	b.FillPercent = 1.0
+	value := r.Value
+	if value == nil {
+		value = []byte{}
+	}
+	return b.put(r.Key, value, r.Expires)
+}
+
+// equalPaths returns true if two bucket paths are equal.
+func equalPaths(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeBinaryRecord writes a record in the binary format.
+func writeBinaryRecord(w *bufio.Writer, r *exportRecord) error {
+	buf := make([]byte, 0, 64)
+	switch r.Type {
+	case "bucket":
+		buf = append(buf, 'b')
+		buf = appendPath(buf, r.Path)
+		buf = binary.AppendUvarint(buf, r.Sequence)
+		buf = append(buf, r.Codec)
+		buf = appendBytes(buf, []byte(r.Comparator))
+		if r.Counted {
+			buf = append(buf, 1)
+		} else {
+			buf = append(buf, 0)
+		}
+	case "kv":
+		buf = append(buf, 'k')
+		buf = appendPath(buf, r.Path)
+		buf = appendBytes(buf, r.Key)
+		buf = binary.AppendUvarint(buf, uint64(r.Expires))
+		buf = binary.AppendUvarint(buf, uint64(len(r.Value)))
+	case "trailer":
+		buf = append(buf, 't')
+		buf = binary.AppendUvarint(buf, uint64(r.Count))
+	}
+	if _, err := w.Write(buf); err != nil {
+		return err
+	}
+	if r.Type == "kv" {
+		// Values can be large, so they are not copied into the buffer.
+		_, err := w.Write(r.Value)
+		return err
+	}
+	return nil
+}
+
+func appendPath(buf []byte, path [][]byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(path)))
+	for _, name := range path {
+		buf = appendBytes(buf, name)
+	}
+	return buf
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// readBinaryRecord reads a record in the binary format of the given version.
+func readBinaryRecord(r *bufio.Reader, version int) (*exportRecord, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	var rec exportRecord
+	switch typ {
+	case 'b':
+		rec.Type = "bucket"
+		if rec.Path, err = readPath(r); err != nil {
+			return nil, err
+		}
+		if rec.Sequence, err = binary.ReadUvarint(r); err != nil {
+			return nil, noEOF(err)
+		}
+		if rec.Codec, err = r.ReadByte(); err != nil {
+			return nil, noEOF(err)
+		}
+		if version >= 2 {
+			cmp, err := readBytes(r, 255)
+			if err != nil {
+				return nil, err
+			}
+			rec.Comparator = string(cmp)
+			counted, err := r.ReadByte()
+			if err != nil {
+				return nil, noEOF(err)
+			}
+			rec.Counted = counted != 0
+		}
+	case 'k':
+		rec.Type = "kv"
+		if rec.Path, err = readPath(r); err != nil {
+			return nil, err
+		}
+		if rec.Key, err = readBytes(r, MaxKeySize); err != nil {
+			return nil, err
+		}
+		expires, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		rec.Expires = int64(expires)
+		if rec.Value, err = readBytes(r, MaxValueSize); err != nil {
+			return nil, err
+		}
+	case 't':
+		rec.Type = "trailer"
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		rec.Count = int(n)
+	default:
+		return nil, fmt.Errorf("unknown record type %q", typ)
+	}
+	return &rec, nil
+}
+
+func readPath(r *bufio.Reader) ([][]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, noEOF(err)
+	} else if n == 0 || n > 1<<16 {
+		return nil, errors.New("invalid bucket path")
+	}
+	path := make([][]byte, n)
+	for i := range path {
+		if path[i], err = readBytes(r, MaxKeySize); err != nil {
+			return nil, err
+		}
+	}
+	return path, nil
+}
+
+func readBytes(r *bufio.Reader, max int) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, noEOF(err)
+	} else if n > uint64(max) {
+		return nil, errors.New("byte string too long")
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, noEOF(err)
+	}
+	return b, nil
+}
+
+// noEOF turns an end of file in the middle of a record into an error.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
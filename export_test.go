@@ -0,0 +1,207 @@
+package bbolt_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that a dump written by Export is loaded back by Import, in both
+// formats.
+func TestTx_Export(t *testing.T) {
+	for _, format := range []bolt.ExportFormat{bolt.ExportJSON, bolt.ExportBinary} {
+		t.Run(fmt.Sprintf("format-%d", format), func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucket([]byte("widgets"))
+				if err != nil {
+					return err
+				}
+				if err := b.SetSequence(42); err != nil {
+					return err
+				}
+				for i := 0; i < 1000; i++ {
+					if err := b.Put([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+						return err
+					}
+				}
+				if err := b.Put([]byte("empty"), []byte{}); err != nil {
+					return err
+				}
+				if err := b.PutWithTTL([]byte("ttl"), []byte("expiring"), time.Hour); err != nil {
+					return err
+				}
+				nested, err := b.CreateBucketWithOptions([]byte("nested"), &bolt.BucketOptions{Codec: bolt.FlateCodec})
+				if err != nil {
+					return err
+				}
+				if err := nested.Put([]byte("foo"), bytes.Repeat([]byte("bar"), 100)); err != nil {
+					return err
+				}
+				counted, err := tx.CreateBucketWithOptions([]byte("counted"), &bolt.BucketOptions{Counted: true})
+				if err != nil {
+					return err
+				}
+				if err := counted.Put([]byte("foo"), []byte("bar")); err != nil {
+					return err
+				}
+				_, err = tx.CreateBucket([]byte("empty"))
+				return err
+			}))
+
+			var buf bytes.Buffer
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				return tx.Export(&buf, format)
+			}))
+			dump := buf.Bytes()
+
+			imported := btesting.MustCreateDB(t)
+			require.NoError(t, imported.Import(bytes.NewReader(dump)))
+			imported.MustCheck()
+			require.NoError(t, imported.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("widgets"))
+				require.Equal(t, uint64(42), b.Sequence())
+				require.Equal(t, []byte("value-999"), b.Get([]byte("0999")))
+				require.Equal(t, []byte{}, b.Get([]byte("empty")))
+				require.Equal(t, []byte("expiring"), b.Get([]byte("ttl")))
+				nested := b.Bucket([]byte("nested"))
+				require.Equal(t, bolt.FlateCodec, nested.Options().Codec)
+				require.Equal(t, bytes.Repeat([]byte("bar"), 100), nested.Get([]byte("foo")))
+				counted := tx.Bucket([]byte("counted"))
+				require.True(t, counted.Options().Counted)
+				require.Equal(t, 1, counted.Len())
+				require.Equal(t, int64(3), counted.ValueSize())
+				require.False(t, tx.Bucket([]byte("empty")).Options().Counted)
+				return nil
+			}))
+
+			// Exporting the imported database gives the same dump, so keys
+			// keep their expiry time.
+			buf.Reset()
+			require.NoError(t, imported.View(func(tx *bolt.Tx) error {
+				return tx.Export(&buf, format)
+			}))
+			require.Equal(t, dump, buf.Bytes())
+
+			// Truncated dumps are rejected.
+			err := btesting.MustCreateDB(t).Import(bytes.NewReader(dump[:len(dump)-3]))
+			require.ErrorIs(t, err, common.ErrInvalidExport)
+		})
+	}
+}
+
+// Ensure that the comparators of the buckets are kept by an export and import
+// round trip, and that a dump using a comparator which is not registered can
+// not be imported.
+func TestTx_Export_Comparator(t *testing.T) {
+	key := func(v uint64) []byte {
+		return binary.LittleEndian.AppendUint64(nil, v)
+	}
+	for _, format := range []bolt.ExportFormat{bolt.ExportJSON, bolt.ExportBinary} {
+		t.Run(fmt.Sprintf("format-%d", format), func(t *testing.T) {
+			db := btesting.MustCreateDB(t)
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				b, err := tx.CreateBucketWithOptions([]byte("ids"), &bolt.BucketOptions{Comparator: uint64Comparator{}})
+				if err != nil {
+					return err
+				}
+				for _, v := range []uint64{1, 256, 2, 65536} {
+					if err := b.Put(key(v), []byte("value")); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+
+			var buf bytes.Buffer
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				return tx.Export(&buf, format)
+			}))
+
+			imported := btesting.MustCreateDB(t)
+			require.NoError(t, imported.Import(&buf))
+			imported.MustCheck()
+			require.NoError(t, imported.View(func(tx *bolt.Tx) error {
+				b := tx.Bucket([]byte("ids"))
+				require.Equal(t, uint64Comparator{}, b.Options().Comparator)
+				var keys [][]byte
+				c := b.Cursor()
+				for k, _ := c.First(); k != nil; k, _ = c.Next() {
+					keys = append(keys, k)
+				}
+				require.Equal(t, [][]byte{key(1), key(2), key(256), key(65536)}, keys)
+				return nil
+			}))
+		})
+	}
+
+	dump := `{"type":"header","version":2}
+{"type":"bucket","path":["aWRz"],"comparator":"unregistered"}
+{"type":"trailer","count":1}
+`
+	err := btesting.MustCreateDB(t).Import(strings.NewReader(dump))
+	require.ErrorIs(t, err, common.ErrUnknownComparator)
+
+	// Binary dumps of version 1 have no comparator names.
+	require.NoError(t, btesting.MustCreateDB(t).Import(strings.NewReader("BBOLTEXP\x01b\x01\x03ids\x00\x00t\x01")))
+}
+
+// Ensure that the JSON format writes one record per line.
+func TestTx_Export_JSON(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+
+	var buf bytes.Buffer
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		return tx.Export(&buf, bolt.ExportJSON)
+	}))
+
+	var lines []map[string]interface{}
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(s.Bytes(), &line))
+		lines = append(lines, line)
+	}
+	require.Equal(t, []map[string]interface{}{
+		{"type": "header", "version": float64(2)},
+		{"type": "bucket", "path": []interface{}{"d2lkZ2V0cw=="}},
+		{"type": "kv", "path": []interface{}{"d2lkZ2V0cw=="}, "key": "Zm9v", "value": "YmFy"},
+		{"type": "trailer", "count": float64(2)},
+	}, lines)
+}
+
+// Ensure that Import rejects streams which are not dumps.
+func TestDB_Import_Invalid(t *testing.T) {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "db"), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, dump := range []string{
+		"",
+		"not a dump",
+		`{"type":"bucket","path":["Zm9v"]}`,
+		`{"type":"header","version":3}`,
+		"BBOLTEXP\x01x",
+	} {
+		require.ErrorIs(t, db.Import(bytes.NewReader([]byte(dump))), common.ErrInvalidExport, dump)
+	}
+}
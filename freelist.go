@@ -27,6 +27,7 @@ type freelist struct {
 	allocs         map[common.Pgid]common.Txid               // mapping of Txid that allocated a pgid.
 	pending        map[common.Txid]*txPending                // mapping of soon-to-be free page ids by tx.
 	cache          map[common.Pgid]struct{}                  // fast lookup of all free and pending page ids.
+	claimed        map[common.Pgid]struct{}                  // pages allocated by scoped transactions which have not committed yet.
 	freemaps       map[uint64]pidSet                         // key is the size of continuous pages(span), value is a set which contains the starting pgids of same size
 	forwardMap     map[common.Pgid]uint64                    // key is start pgid, value is its span size
 	backwardMap    map[common.Pgid]uint64                    // key is end pgid, value is its span size
@@ -44,6 +45,7 @@ func newFreelist(freelistType common.FreelistType) *freelist {
 		allocs:       make(map[common.Pgid]common.Txid),
 		pending:      make(map[common.Txid]*txPending),
 		cache:        make(map[common.Pgid]struct{}),
+		claimed:      make(map[common.Pgid]struct{}),
 		freemaps:     make(map[uint64]pidSet),
 		forwardMap:   make(map[common.Pgid]uint64),
 		backwardMap:  make(map[common.Pgid]uint64),
@@ -78,7 +80,7 @@ func (f *freelist) size() int {
 
 // count returns count of pages on the freelist
 func (f *freelist) count() int {
-	return f.free_count() + f.pending_count()
+	return f.free_count() + f.pending_count() + len(f.claimed)
 }
 
 // arrayFreeCount returns count of free pages(array version)
@@ -95,13 +97,16 @@ func (f *freelist) pending_count() int {
 	return count
 }
 
-// copyall copies a list of all free ids and all pending ids in one sorted list.
-// f.count returns the minimum length required for dst.
+// copyall copies a list of all free ids, all pending ids and all claimed ids
+// in one sorted list. f.count returns the minimum length required for dst.
 func (f *freelist) copyall(dst []common.Pgid) {
-	m := make(common.Pgids, 0, f.pending_count())
+	m := make(common.Pgids, 0, f.pending_count()+len(f.claimed))
 	for _, txp := range f.pending {
 		m = append(m, txp.ids...)
 	}
+	for id := range f.claimed {
+		m = append(m, id)
+	}
 	sort.Sort(m)
 	common.Mergepgids(dst, f.getFreePageIDs(), m)
 }
@@ -230,6 +235,23 @@ func (f *freelist) releaseRange(begin, end common.Txid) {
 	f.mergeSpans(m)
 }
 
+// pinned returns the number of pending pages which a read transaction of the
+// given txid can still see, and which can not be reused until it closes.
+func (f *freelist) pinned(txid common.Txid) int {
+	var n int
+	for tid, txp := range f.pending {
+		if tid <= txid {
+			continue
+		}
+		for _, allocTxid := range txp.alloctx {
+			if allocTxid <= txid {
+				n++
+			}
+		}
+	}
+	return n
+}
+
 // rollback removes the pages from a given pending tx.
 func (f *freelist) rollback(txid common.Txid) {
 	// Remove page ids from cache.
@@ -257,6 +279,28 @@ func (f *freelist) rollback(txid common.Txid) {
 	f.mergeSpans(m)
 }
 
+// rollbackTo removes the pages freed by a given pending tx, except for the
+// first n of them.
+func (f *freelist) rollbackTo(txid common.Txid, n int) {
+	txp := f.pending[txid]
+	if txp == nil || len(txp.ids) <= n {
+		return
+	}
+	for i := n; i < len(txp.ids); i++ {
+		pgid := txp.ids[i]
+		delete(f.cache, pgid)
+		if tx := txp.alloctx[i]; tx != 0 {
+			// Pending free aborted; restore page back to alloc list.
+			f.allocs[pgid] = tx
+		}
+	}
+	txp.ids = txp.ids[:n]
+	txp.alloctx = txp.alloctx[:n]
+	if n == 0 {
+		delete(f.pending, txid)
+	}
+}
+
 // freed returns whether a given page is in the free list.
 func (f *freelist) freed(pgId common.Pgid) bool {
 	_, ok := f.cache[pgId]
@@ -331,16 +375,19 @@ func (f *freelist) write(p *common.Page) error {
 func (f *freelist) reload(p *common.Page) {
 	f.read(p)
 
-	// Build a cache of only pending pages.
+	// Build a cache of only pending and claimed pages.
 	pcache := make(map[common.Pgid]bool)
 	for _, txp := range f.pending {
 		for _, pendingID := range txp.ids {
 			pcache[pendingID] = true
 		}
 	}
+	for id := range f.claimed {
+		pcache[id] = true
+	}
 
 	// Check each page in the freelist and build a new available freelist
-	// with any pages not in the pending lists.
+	// with any pages not in the pending lists or claimed.
 	var a []common.Pgid
 	for _, id := range f.getFreePageIDs() {
 		if !pcache[id] {
@@ -353,16 +400,19 @@ func (f *freelist) reload(p *common.Page) {
 
 // noSyncReload reads the freelist from Pgids and filters out pending items.
 func (f *freelist) noSyncReload(Pgids []common.Pgid) {
-	// Build a cache of only pending pages.
+	// Build a cache of only pending and claimed pages.
 	pcache := make(map[common.Pgid]bool)
 	for _, txp := range f.pending {
 		for _, pendingID := range txp.ids {
 			pcache[pendingID] = true
 		}
 	}
+	for id := range f.claimed {
+		pcache[id] = true
+	}
 
 	// Check each page in the freelist and build a new available freelist
-	// with any pages not in the pending lists.
+	// with any pages not in the pending lists or claimed.
 	var a []common.Pgid
 	for _, id := range Pgids {
 		if !pcache[id] {
@@ -392,3 +442,119 @@ func (f *freelist) arrayMergeSpans(ids common.Pgids) {
 	sort.Sort(ids)
 	f.ids = common.Pgids(f.ids).Merge(ids)
 }
+
+// reserve moves the free pages at or above pgid to the pending list of txid so
+// that the transaction can not allocate them. They are released along with the
+// pages freed by txid, or returned to the free list if txid is rolled back.
+// Returns the number of reserved pages.
+func (f *freelist) reserve(txid common.Txid, pgid common.Pgid) int {
+	ids := f.getFreePageIDs()
+	i := sort.Search(len(ids), func(i int) bool { return ids[i] >= pgid })
+	if i == len(ids) {
+		return 0
+	}
+
+	txp := f.pending[txid]
+	if txp == nil {
+		txp = &txPending{}
+		f.pending[txid] = txp
+	}
+	for _, id := range ids[i:] {
+		txp.ids = append(txp.ids, id)
+		txp.alloctx = append(txp.alloctx, txid)
+	}
+	n := len(ids) - i
+
+	f.resetIDs(append([]common.Pgid(nil), ids[:i]...))
+	return n
+}
+
+// trimTail removes the free pages at the end of the file from the free list
+// and returns the new high water mark.
+func (f *freelist) trimTail(hwm common.Pgid) common.Pgid {
+	ids := f.getFreePageIDs()
+	i := len(ids)
+	for i > 0 && ids[i-1] == hwm-1 {
+		i--
+		hwm--
+	}
+	if i == len(ids) {
+		return hwm
+	}
+
+	f.resetIDs(append([]common.Pgid(nil), ids[:i]...))
+	return hwm
+}
+
+// untrimTail returns the pages removed by trimTail to the free list, when the
+// transaction which trimmed them is rolled back.
+func (f *freelist) untrimTail(ids common.Pgids) {
+	f.resetIDs(common.Pgids(f.getFreePageIDs()).Merge(ids))
+}
+
+// claim marks the n pages starting at id, which a scoped transaction allocated
+// before it commits, as claimed. Claimed pages are written to the freelist page
+// along with the free ones, since they are only in use once the transaction
+// commits, and are kept out of the free list when it is reloaded.
+func (f *freelist) claim(id common.Pgid, n int) {
+	for i := common.Pgid(0); i < common.Pgid(n); i++ {
+		f.claimed[id+i] = struct{}{}
+	}
+}
+
+// settle drops the claims on the given pages, which are in use once the
+// scoped transaction which claimed them commits with the given txid.
+func (f *freelist) settle(ids []common.Pgid, txid common.Txid) {
+	for _, id := range ids {
+		delete(f.claimed, id)
+		if _, ok := f.allocs[id]; ok {
+			f.allocs[id] = txid
+		}
+	}
+}
+
+// unclaim returns the given pages to the free list when the scoped transaction
+// which claimed them does not commit. Pages which are already free are left
+// as they are.
+func (f *freelist) unclaim(ids []common.Pgid) {
+	for _, id := range ids {
+		delete(f.claimed, id)
+	}
+	f.addFree(ids)
+}
+
+// addFree adds the given pages to the free list, except for those which are
+// already free or pending.
+func (f *freelist) addFree(ids []common.Pgid) {
+	var m common.Pgids
+	for _, id := range ids {
+		if _, ok := f.cache[id]; !ok {
+			m = append(m, id)
+		}
+	}
+	if len(m) == 0 {
+		return
+	}
+	sort.Sort(m)
+	f.resetIDs(common.Pgids(f.getFreePageIDs()).Merge(m))
+}
+
+// freeUnclaimed adds the pages from start up to end which are not claimed to
+// the free list.
+func (f *freelist) freeUnclaimed(start, end common.Pgid) {
+	var ids []common.Pgid
+	for id := start; id < end; id++ {
+		if _, ok := f.claimed[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	f.addFree(ids)
+}
+
+// resetIDs replaces the free page ids with a sorted list of ids.
+func (f *freelist) resetIDs(ids []common.Pgid) {
+	f.freemaps = make(map[uint64]pidSet)
+	f.forwardMap = make(map[common.Pgid]uint64)
+	f.backwardMap = make(map[common.Pgid]uint64)
+	f.readIDs(ids)
+}
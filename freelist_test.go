@@ -255,6 +255,84 @@ func TestFreelistArray_allocate(t *testing.T) {
 	}
 }
 
+// Ensure that reserved pages can not be allocated until they are released.
+func TestFreelist_reserve(t *testing.T) {
+	f := newTestFreelist()
+	f.readIDs([]common.Pgid{3, 4, 5, 8, 9})
+
+	if n := f.reserve(100, 5); n != 3 {
+		t.Fatalf("exp=3; got=%d", n)
+	}
+	if exp := []common.Pgid{3, 4}; !reflect.DeepEqual(exp, f.getFreePageIDs()) {
+		t.Fatalf("exp=%v; got=%v", exp, f.getFreePageIDs())
+	}
+	if !f.freed(8) {
+		t.Fatal("expected reserved page to be free")
+	}
+	if id := f.allocate(100, 3); id != 0 {
+		t.Fatalf("exp=0; got=%d", id)
+	}
+
+	f.rollback(100)
+	if exp := []common.Pgid{3, 4, 5, 8, 9}; !reflect.DeepEqual(exp, f.getFreePageIDs()) {
+		t.Fatalf("exp=%v; got=%v", exp, f.getFreePageIDs())
+	}
+}
+
+// Ensure that the free pages at the end of the file can be trimmed.
+func TestFreelist_trimTail(t *testing.T) {
+	f := newTestFreelist()
+	f.readIDs([]common.Pgid{3, 4, 8, 9})
+	f.free(100, common.NewPage(5, 0, 0, 0))
+
+	if hwm := f.trimTail(12); hwm != 12 {
+		t.Fatalf("exp=12; got=%d", hwm)
+	}
+	if hwm := f.trimTail(10); hwm != 8 {
+		t.Fatalf("exp=8; got=%d", hwm)
+	}
+	if exp := []common.Pgid{3, 4}; !reflect.DeepEqual(exp, f.getFreePageIDs()) {
+		t.Fatalf("exp=%v; got=%v", exp, f.getFreePageIDs())
+	}
+	if f.freed(9) || !f.freed(5) {
+		t.Fatal("unexpected free page cache")
+	}
+
+	// Pending pages are not trimmed.
+	if hwm := f.trimTail(6); hwm != 6 {
+		t.Fatalf("exp=6; got=%d", hwm)
+	}
+
+	// Trimmed pages are given back on rollback.
+	f.untrimTail(common.Pgids{8, 9})
+	if exp := []common.Pgid{3, 4, 8, 9}; !reflect.DeepEqual(exp, f.getFreePageIDs()) {
+		t.Fatalf("exp=%v; got=%v", exp, f.getFreePageIDs())
+	}
+	if !f.freed(9) {
+		t.Fatal("unexpected free page cache")
+	}
+}
+
+// Ensure that the pages freed after a point in a transaction can be restored.
+func TestFreelist_rollbackTo(t *testing.T) {
+	f := newTestFreelist()
+	f.free(100, common.NewPage(12, 0, 0, 1))
+	f.free(100, common.NewPage(9, 0, 0, 0))
+
+	f.rollbackTo(100, 2)
+	if exp := []common.Pgid{12, 13}; !reflect.DeepEqual(exp, f.pending[100].ids) {
+		t.Fatalf("exp=%v; got=%v", exp, f.pending[100].ids)
+	}
+	if f.freed(9) || !f.freed(13) {
+		t.Fatal("unexpected free page cache")
+	}
+
+	f.rollbackTo(100, 0)
+	if _, ok := f.pending[100]; ok {
+		t.Fatal("expected no pending pages")
+	}
+}
+
 // Ensure that a freelist can deserialize from a freelist page.
 func TestFreelist_read(t *testing.T) {
 	// Create a page.
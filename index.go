@@ -0,0 +1,339 @@
+package bbolt
+
+import (
+	"bytes"
+	"sort"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// IndexFunc returns the index keys of a key/value pair of an indexed bucket.
+// It must always return the same index keys for the same pair, and must not
+// modify k or v, nor retain them after returning. Empty index keys are
+// ignored, and returning none leaves the pair out of the index.
+type IndexFunc func(k, v []byte) [][]byte
+
+// indexDef is an index declared with DB.DeclareIndex.
+type indexDef struct {
+	name string
+	path [][]byte
+	fn   IndexFunc
+
+	// bucket is the name of the bucket in the root of the database which
+	// holds the index.
+	bucket []byte
+}
+
+// indexBucketPrefix starts the names of the buckets holding the indexes.
+var indexBucketPrefix = []byte("\x00bbolt.index.")
+
+// isIndexBucket returns whether name is the name of a bucket holding an index.
+func isIndexBucket(name []byte) bool {
+	return bytes.HasPrefix(name, indexBucketPrefix)
+}
+
+// DeclareIndex declares a secondary index of the bucket at path. While the
+// index is declared, every write transaction which puts or deletes keys of the
+// bucket updates the index along with them, so that Tx.Index can look up the
+// keys of the bucket by the index keys fn returns for their values.
+//
+// The entries of the index are stored in the database, but fn is not, so the
+// index must be declared every time the database is opened, before the
+// bucket is written. Keys written while the index is not declared are not
+// indexed; Index.Rebuild indexes the existing keys of a bucket.
+//
+// DeclareIndex waits for the open write transaction, if any, and must not be
+// called from a transaction.
+// Returns an error if the name or path is blank, if an index with the same
+// name is already declared, or if the database was created by an earlier
+// version and has buckets using the names of the internal buckets.
+func (db *DB) DeclareIndex(name string, path [][]byte, fn IndexFunc) error {
+	if name == "" {
+		return common.ErrIndexNameRequired
+	} else if len(path) == 0 {
+		return common.ErrBucketNameRequired
+	} else if db.isReservedName(path[0]) {
+		return common.ErrBucketNameReserved
+	} else if len(indexBucketPrefix)+len(name) > MaxKeySize {
+		return common.ErrKeyTooLarge
+	}
+
+	def := &indexDef{name: name, fn: fn, bucket: append(cloneBytes(indexBucketPrefix), name...)}
+	for _, name := range path {
+		def.path = append(def.path, cloneBytes(name))
+	}
+
+	// Transactions take the indexes when they begin, under the meta lock.
+	// Transactions begun with BeginBuckets also declare the buckets of the
+	// indexes, so they must not be open.
+	db.scopelock.Lock()
+	defer db.scopelock.Unlock()
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
+
+	if !db.opened {
+		return common.ErrDatabaseNotOpen
+	} else if !db.reservedNames {
+		return common.ErrReservedNamesInUse
+	}
+	i := sort.Search(len(db.indexes), func(i int) bool { return db.indexes[i].name >= name })
+	if i < len(db.indexes) && db.indexes[i].name == name {
+		return common.ErrIndexExists
+	}
+
+	indexes := make([]*indexDef, 0, len(db.indexes)+1)
+	indexes = append(indexes, db.indexes[:i]...)
+	indexes = append(indexes, def)
+	db.indexes = append(indexes, db.indexes[i:]...)
+	return nil
+}
+
+// Index is a secondary index of a bucket, declared with DB.DeclareIndex. It
+// maps index keys to the keys of the bucket whose values have them.
+// An Index is only valid for the lifetime of the transaction.
+type Index struct {
+	tx  *Tx
+	def *indexDef
+}
+
+// Index returns the index with the given name.
+// Returns nil if the index was not declared when the transaction began.
+func (tx *Tx) Index(name string) *Index {
+	for _, def := range tx.indexes {
+		if def.name == name {
+			return &Index{tx: tx, def: def}
+		}
+	}
+	return nil
+}
+
+// Lookup returns the keys of the indexed bucket whose values have the given
+// index key, in byte order, whatever the comparator of the bucket. Expired keys
+// which are not swept yet are returned.
+// The returned keys are only valid for the life of the transaction.
+func (idx *Index) Lookup(ikey []byte) [][]byte {
+	if idx.tx.db == nil {
+		return nil
+	}
+	keys := idx.entries(ikey)
+	if keys == nil {
+		return nil
+	}
+
+	var found [][]byte
+	c := keys.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		found = append(found, k)
+	}
+	c.panicOnCorruption()
+	return found
+}
+
+// Scan calls fn for each index key from start up to, but not including, end,
+// and each key of the indexed bucket with it. A nil start or end leaves that
+// end of the range open. Index keys, and the keys of each index key, are
+// visited in byte order, whatever the comparator of the bucket. Expired keys
+// which are not swept yet are visited. If fn returns an error, the scan stops
+// and the error is returned.
+// The keys passed to fn are only valid for the life of the transaction.
+func (idx *Index) Scan(start, end []byte, fn func(ikey, key []byte) error) error {
+	if idx.tx.db == nil {
+		return common.ErrTxClosed
+	}
+	index := idx.tx.root.Bucket(idx.def.bucket)
+	if index == nil {
+		return nil
+	}
+
+	c := index.Cursor()
+	ik, _ := c.First()
+	if start != nil {
+		ik, _ = c.Seek(start)
+	}
+	for ; ik != nil && (end == nil || index.compare(ik, end) < 0); ik, _ = c.Next() {
+		kc := index.Bucket(ik).Cursor()
+		for k, _ := kc.First(); k != nil; k, _ = kc.Next() {
+			if err := fn(ik, k); err != nil {
+				return err
+			}
+		}
+		if err := kc.Err(); err != nil {
+			return err
+		}
+	}
+	return c.Err()
+}
+
+// Rebuild replaces the entries of the index with the ones of the keys in the
+// indexed bucket, for example after declaring the index of a bucket which
+// already holds keys.
+// Returns an error if the transaction is read-only.
+func (idx *Index) Rebuild() error {
+	if idx.tx.db == nil {
+		return common.ErrTxClosed
+	} else if !idx.tx.writable {
+		return common.ErrTxNotWritable
+	} else if idx.tx.scope != nil && !idx.tx.scope.has(idx.def.bucket) {
+		return common.ErrBucketNotDeclared
+	}
+	return idx.tx.rebuildIndex(idx.def)
+}
+
+// entries returns the bucket holding the keys with the given index key, or
+// nil.
+func (idx *Index) entries(ikey []byte) *Bucket {
+	index := idx.tx.root.Bucket(idx.def.bucket)
+	if index == nil || len(ikey) == 0 {
+		return nil
+	}
+	return index.Bucket(ikey)
+}
+
+// indexes returns the indexes declared for the bucket.
+func (b *Bucket) indexes() []*indexDef {
+	if len(b.tx.indexes) == 0 || b == &b.tx.root {
+		return nil
+	}
+
+	var defs []*indexDef
+	path := b.path()
+	for _, def := range b.tx.indexes {
+		if len(def.path) == len(path) && pathHasPrefix(def.path, path) {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// reindex moves a key of the bucket in the given indexes from the index keys
+// of its old value, if the key exists, to those of its new value. The index
+// keys of the new value are checked before any index is changed.
+func (b *Bucket) reindex(defs []*indexDef, key, old []byte, exists bool, value []byte) error {
+	added := make([][][]byte, len(defs))
+	for i, def := range defs {
+		added[i] = def.fn(key, value)
+		for _, ikey := range added[i] {
+			if len(ikey) > MaxKeySize {
+				return common.ErrKeyTooLarge
+			}
+		}
+	}
+
+	return b.tx.untracked(func() error {
+		for i, def := range defs {
+			if exists {
+				if err := b.tx.removeIndexEntries(def, key, def.fn(key, old)); err != nil {
+					return err
+				}
+			}
+			if err := b.tx.addIndexEntries(def, key, added[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// unindex removes a key of the bucket, whose value is given, from the given
+// indexes.
+func (b *Bucket) unindex(defs []*indexDef, key, value []byte) error {
+	return b.tx.untracked(func() error {
+		for _, def := range defs {
+			if err := b.tx.removeIndexEntries(def, key, def.fn(key, value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// addIndexEntries adds a key of the indexed bucket to an index under the
+// given index keys.
+func (tx *Tx) addIndexEntries(def *indexDef, key []byte, ikeys [][]byte) error {
+	for _, ikey := range ikeys {
+		if len(ikey) == 0 {
+			continue
+		}
+		index, err := tx.root.CreateBucketIfNotExists(def.bucket)
+		if err != nil {
+			return err
+		}
+		keys, err := index.CreateBucketIfNotExists(ikey)
+		if err != nil {
+			return err
+		}
+		if err := keys.Put(key, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeIndexEntries removes a key of the indexed bucket from an index under
+// the given index keys. Index keys left without keys are removed.
+func (tx *Tx) removeIndexEntries(def *indexDef, key []byte, ikeys [][]byte) error {
+	for _, ikey := range ikeys {
+		keys := (&Index{tx: tx, def: def}).entries(ikey)
+		if keys == nil {
+			continue
+		}
+		if err := keys.Delete(key); err != nil {
+			return err
+		}
+		kc := keys.Cursor()
+		if k, _ := kc.First(); k == nil {
+			if err := kc.Err(); err != nil {
+				return err
+			}
+			if err := tx.root.Bucket(def.bucket).DeleteBucket(ikey); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rebuildIndexes rebuilds the indexes of the bucket at path and of the buckets
+// nested in it, after the bucket was replaced as a whole.
+func (tx *Tx) rebuildIndexes(path [][]byte) error {
+	for _, def := range tx.indexes {
+		if len(def.path) >= len(path) && pathHasPrefix(def.path, path) {
+			if err := tx.rebuildIndex(def); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rebuildIndex replaces the entries of an index with the ones of the keys in
+// the indexed bucket. The index is left empty if the bucket does not exist.
+func (tx *Tx) rebuildIndex(def *indexDef) error {
+	return tx.untracked(func() error {
+		if err := tx.root.DeleteBucket(def.bucket); err != nil && err != common.ErrBucketNotFound {
+			return err
+		}
+
+		b, err := tx.bucketAt(def.path)
+		if err == common.ErrBucketNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// Expired keys which are not swept yet are indexed, as they are
+		// when they are put.
+		c := b.Cursor()
+		for k, v, flags := c.first(); k != nil; k, v, flags = c.next() {
+			if flags&common.BucketLeafFlag != 0 {
+				continue
+			}
+			if err := tx.addIndexEntries(def, k, def.fn(k, c.value(v, flags))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,190 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// tags indexes values holding comma separated tags by each of their tags.
+func tags(k, v []byte) [][]byte {
+	return bytes.Split(v, []byte(","))
+}
+
+// scan returns the entries of an index as "ikey=key" strings.
+func scan(t *testing.T, idx *bolt.Index, start, end []byte) []string {
+	var entries []string
+	require.NoError(t, idx.Scan(start, end, func(ikey, key []byte) error {
+		entries = append(entries, string(ikey)+"="+string(key))
+		return nil
+	}))
+	return entries
+}
+
+// Ensure that an index follows puts and deletes of the keys of its bucket.
+func TestDB_DeclareIndex(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.DeclareIndex("tags", [][]byte{[]byte("widgets")}, tags))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("red,round")))
+		require.NoError(t, b.Put([]byte("b"), []byte("blue,round")))
+		require.NoError(t, b.Put([]byte("c"), []byte("red")))
+		require.NoError(t, b.Put([]byte("d"), []byte("green")))
+		require.NoError(t, b.Put([]byte("e"), []byte("blue")))
+
+		// Overwriting a key moves it to its new index keys.
+		require.NoError(t, b.Put([]byte("b"), []byte("blue,square")))
+		require.NoError(t, b.Delete([]byte("c")))
+		_, _, err = b.DeleteRange([]byte("d"), []byte("e"))
+		return err
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		idx := tx.Index("tags")
+		require.Nil(t, tx.Index("missing"))
+		require.Equal(t, [][]byte{[]byte("a")}, idx.Lookup([]byte("red")))
+		require.Equal(t, [][]byte{[]byte("b"), []byte("e")}, idx.Lookup([]byte("blue")))
+		require.Nil(t, idx.Lookup([]byte("green")))
+		require.Equal(t, []string{"blue=b", "blue=e", "red=a", "round=a", "square=b"}, scan(t, idx, nil, nil))
+		require.Equal(t, []string{"red=a", "round=a"}, scan(t, idx, []byte("r"), []byte("s")))
+		return nil
+	}))
+
+	// Deleting the bucket empties its index.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.DeleteBucket([]byte("widgets")))
+		require.Nil(t, scan(t, tx.Index("tags"), nil, nil))
+		return nil
+	}))
+	db.MustCheck()
+}
+
+// Ensure that rebuilding an index indexes the keys written before it was
+// declared, and that indexes follow buckets which are moved or bulk loaded.
+func TestIndex_Rebuild(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("red")))
+		require.NoError(t, b.Put([]byte("b"), []byte("blue")))
+		return nil
+	}))
+
+	require.NoError(t, db.DeclareIndex("tags", [][]byte{[]byte("widgets")}, tags))
+	require.Equal(t, common.ErrIndexExists, db.DeclareIndex("tags", [][]byte{[]byte("gadgets")}, tags))
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		require.Len(t, errs, 2)
+		require.ErrorContains(t, errs[0], "index tags: missing key")
+		return nil
+	}))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		idx := tx.Index("tags")
+		require.NoError(t, idx.Rebuild())
+		require.Equal(t, []string{"blue=b", "red=a"}, scan(t, idx, nil, nil))
+
+		// Moving the bucket away empties the index, and moving a bucket
+		// in its place indexes its keys.
+		require.NoError(t, tx.MoveBucket([][]byte{[]byte("widgets")}, nil, []byte("old")))
+		require.Nil(t, scan(t, idx, nil, nil))
+		require.NoError(t, tx.MoveBucket([][]byte{[]byte("old")}, nil, []byte("widgets")))
+		require.Equal(t, []string{"blue=b", "red=a"}, scan(t, idx, nil, nil))
+		require.NoError(t, tx.DeleteBucket([]byte("widgets")))
+
+		l, err := tx.BulkLoad([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, l.Put([]byte("x"), []byte("green")))
+		require.NoError(t, l.Close())
+		require.Equal(t, []string{"green=x"}, scan(t, idx, nil, nil))
+		return nil
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, common.ErrTxNotWritable, tx.Index("tags").Rebuild())
+		return nil
+	}))
+}
+
+// Ensure that indexes can not be declared with invalid names or paths.
+func TestDB_DeclareIndex_Invalid(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.Equal(t, common.ErrIndexNameRequired, db.DeclareIndex("", [][]byte{[]byte("widgets")}, tags))
+	require.Equal(t, common.ErrBucketNameRequired, db.DeclareIndex("tags", nil, tags))
+	require.Equal(t, common.ErrBucketNameReserved, db.DeclareIndex("tags", [][]byte{[]byte("\x00bbolt.expiry")}, tags))
+
+	// Index keys which are too large leave the bucket unchanged.
+	require.NoError(t, db.DeclareIndex("tags", [][]byte{[]byte("widgets")}, tags))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.Equal(t, common.ErrKeyTooLarge, b.Put([]byte("a"), make([]byte, bolt.MaxKeySize+1)))
+		require.Nil(t, b.Get([]byte("a")))
+		return nil
+	}))
+}
+
+// Ensure that Compact and logical exports copy the entries of the indexes,
+// including the ones of expired keys which are not swept yet.
+func TestIndex_Copy(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.DeclareIndex("tags", [][]byte{[]byte("widgets")}, tags))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("red,round")))
+		require.NoError(t, b.Put([]byte("b"), []byte("blue")))
+		return b.PutWithTTL([]byte("c"), []byte("red"), time.Millisecond)
+	}))
+	time.Sleep(10 * time.Millisecond)
+
+	copies := map[string]func(dst *bolt.DB) error{
+		"compact": func(dst *bolt.DB) error {
+			return bolt.Compact(dst, db.DB, 0)
+		},
+		"export": func(dst *bolt.DB) error {
+			var buf bytes.Buffer
+			if err := db.View(func(tx *bolt.Tx) error { return tx.Export(&buf, bolt.ExportBinary) }); err != nil {
+				return err
+			}
+			return dst.Import(&buf)
+		},
+	}
+	for name, copyTo := range copies {
+		t.Run(name, func(t *testing.T) {
+			// The index is declared on the copy only once it's copied.
+			dst := btesting.MustCreateDB(t)
+			require.NoError(t, copyTo(dst.DB))
+			require.NoError(t, dst.DeclareIndex("tags", [][]byte{[]byte("widgets")}, tags))
+			dst.MustCheck()
+			require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+				require.Equal(t, []string{"blue=b", "red=a", "red=c", "round=a"}, scan(t, tx.Index("tags"), nil, nil))
+				return nil
+			}))
+
+			// Sweeping the expired key removes it from the index.
+			n, err := dst.ExpireSweep(0)
+			require.NoError(t, err)
+			require.Equal(t, 1, n)
+			require.NoError(t, dst.View(func(tx *bolt.Tx) error {
+				require.Equal(t, [][]byte{[]byte("a")}, tx.Index("tags").Lookup([]byte("red")))
+				return nil
+			}))
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"encoding/binary"
 	"fmt"
 	"unsafe"
 )
@@ -52,3 +53,126 @@ func (b *InBucket) InlinePage(v []byte) *Page {
 func (b *InBucket) String() string {
 	return fmt.Sprintf("<pgid=%d,seq=%d>", b.root, b.sequence)
 }
+
+// BucketExt holds the options of a bucket. It is stored after the header of
+// buckets flagged with BucketExtLeafFlag, as a uint32 size followed by
+// type-length-value records. The size is a multiple of 8 so that an inline
+// page following the extension stays aligned.
+type BucketExt struct {
+	Codec uint8 // id of the codec compressing the values, 0 if none
+
+	// Comparator is the name of the comparator ordering the keys, or empty
+	// if they are ordered by bytes.Compare.
+	Comparator string
+
+	// Counted is set if the bucket keeps the number of its keys and the total
+	// size of its values in KeyN and ValueBytes.
+	Counted    bool
+	KeyN       uint64
+	ValueBytes uint64
+}
+
+// Types of the records of a BucketExt.
+const (
+	bucketExtCodec    = 1
+	bucketExtCounters = 2
+	bucketExtCompare  = 3
+)
+
+// IsZero returns true if the bucket has no options, in which case no
+// extension is stored.
+func (e *BucketExt) IsZero() bool {
+	return *e == BucketExt{}
+}
+
+// Size returns the size of the encoded extension.
+func (e *BucketExt) Size() int {
+	if e.IsZero() {
+		return 0
+	}
+	sz := 4
+	if e.Codec != 0 {
+		sz += 3
+	}
+	if e.Counted {
+		sz += 18
+	}
+	if e.Comparator != "" {
+		sz += 2 + len(e.Comparator)
+	}
+	return (sz + 7) &^ 7
+}
+
+// Write encodes the extension into b, which must be at least Size bytes long.
+func (e *BucketExt) Write(b []byte) {
+	sz := e.Size()
+	if sz == 0 {
+		return
+	}
+	binary.LittleEndian.PutUint32(b, uint32(sz))
+	i := 4
+	if e.Codec != 0 {
+		b[i], b[i+1], b[i+2] = bucketExtCodec, 1, e.Codec
+		i += 3
+	}
+	if e.Counted {
+		b[i], b[i+1] = bucketExtCounters, 16
+		binary.LittleEndian.PutUint64(b[i+2:], e.KeyN)
+		binary.LittleEndian.PutUint64(b[i+10:], e.ValueBytes)
+		i += 18
+	}
+	if e.Comparator != "" {
+		b[i], b[i+1] = bucketExtCompare, uint8(len(e.Comparator))
+		copy(b[i+2:], e.Comparator)
+		i += 2 + len(e.Comparator)
+	}
+	for ; i < sz; i++ {
+		b[i] = 0
+	}
+}
+
+// BucketExtSize returns the size of the extension encoded at the start of b.
+func BucketExtSize(b []byte) int {
+	return int(binary.LittleEndian.Uint32(b))
+}
+
+// ReadBucketExt decodes the extension encoded at the start of b.
+func ReadBucketExt(b []byte) (BucketExt, error) {
+	var e BucketExt
+	if len(b) < 4 {
+		return e, ErrInvalidBucketOptions
+	}
+	sz := BucketExtSize(b)
+	if sz < 4 || sz > len(b) {
+		return e, ErrInvalidBucketOptions
+	}
+	for i := 4; i < sz && b[i] != 0; {
+		if i+2 > sz || i+2+int(b[i+1]) > sz {
+			return e, ErrInvalidBucketOptions
+		}
+		typ, val := b[i], b[i+2:i+2+int(b[i+1])]
+		switch typ {
+		case bucketExtCodec:
+			if len(val) != 1 {
+				return e, ErrInvalidBucketOptions
+			}
+			e.Codec = val[0]
+		case bucketExtCounters:
+			if len(val) != 16 {
+				return e, ErrInvalidBucketOptions
+			}
+			e.Counted = true
+			e.KeyN = binary.LittleEndian.Uint64(val)
+			e.ValueBytes = binary.LittleEndian.Uint64(val[8:])
+		case bucketExtCompare:
+			if len(val) == 0 {
+				return e, ErrInvalidBucketOptions
+			}
+			e.Comparator = string(val)
+		default:
+			return e, fmt.Errorf("%w: unknown option %d", ErrInvalidBucketOptions, typ)
+		}
+		i += 2 + len(val)
+	}
+	return e, nil
+}
@@ -0,0 +1,58 @@
+package common
+
+import (
+	"errors"
+	"testing"
+)
+
+// Ensure that a bucket header extension can be written and read back.
+func TestBucketExt(t *testing.T) {
+	var e BucketExt
+	if !e.IsZero() || e.Size() != 0 {
+		t.Fatalf("unexpected size of empty extension: %d", e.Size())
+	}
+
+	e.Codec = 2
+	buf := make([]byte, e.Size()+4)
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	e.Write(buf)
+	if e.Size() != 8 || BucketExtSize(buf) != 8 {
+		t.Fatalf("unexpected size: %d", BucketExtSize(buf))
+	}
+	if got, err := ReadBucketExt(buf); err != nil || got != e {
+		t.Fatalf("unexpected extension: %+v, %v", got, err)
+	}
+
+	// Counters are stored along with the codec.
+	e.Counted, e.KeyN, e.ValueBytes = true, 1<<40, 12345
+	counted := make([]byte, e.Size())
+	e.Write(counted)
+	if e.Size() != 32 || BucketExtSize(counted) != 32 {
+		t.Fatalf("unexpected size: %d", BucketExtSize(counted))
+	}
+	if got, err := ReadBucketExt(counted); err != nil || got != e {
+		t.Fatalf("unexpected extension: %+v, %v", got, err)
+	}
+
+	// Comparator names have a variable length.
+	e.Comparator = "uint64"
+	named := make([]byte, e.Size())
+	e.Write(named)
+	if e.Size() != 40 {
+		t.Fatalf("unexpected size: %d", e.Size())
+	}
+	if got, err := ReadBucketExt(named); err != nil || got != e {
+		t.Fatalf("unexpected extension: %+v, %v", got, err)
+	}
+
+	// Unknown options are rejected.
+	buf[4] = 0x7f
+	if _, err := ReadBucketExt(buf); !errors.Is(err, ErrInvalidBucketOptions) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := ReadBucketExt(buf[:3]); !errors.Is(err, ErrInvalidBucketOptions) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -1,6 +1,9 @@
 package common
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // These errors can be returned when opening or calling methods on a DB.
 var (
@@ -26,6 +29,26 @@ var (
 	// ErrChecksum is returned when either meta page checksum does not match.
 	ErrChecksum = errors.New("checksum error")
 
+	// ErrPageChecksum is returned when the checksum stored in a page does not
+	// match its contents. It is wrapped in a CorruptionError.
+	ErrPageChecksum = errors.New("page checksum mismatch")
+
+	// ErrDecryption is returned when a page of an encrypted database fails to
+	// decrypt, because it was modified. It is wrapped in a CorruptionError.
+	ErrDecryption = errors.New("page decryption failed")
+
+	// ErrEncrypted is returned when opening an encrypted database without an
+	// encryption key.
+	ErrEncrypted = errors.New("database is encrypted")
+
+	// ErrNotEncrypted is returned when opening a database which is not
+	// encrypted with an encryption key.
+	ErrNotEncrypted = errors.New("database is not encrypted")
+
+	// ErrEncryptionKey is returned when opening an encrypted database with a
+	// key which does not decrypt it.
+	ErrEncryptionKey = errors.New("wrong encryption key")
+
 	// ErrTimeout is returned when a database cannot obtain an exclusive lock
 	// on the data file after the timeout passed to Open().
 	ErrTimeout = errors.New("timeout")
@@ -48,6 +71,16 @@ var (
 	// ErrFreePagesNotLoaded is returned when a readonly transaction without
 	// preloading the free pages is trying to access the free pages.
 	ErrFreePagesNotLoaded = errors.New("free pages are not pre-loaded")
+
+	// ErrInvalidSavepoint is returned when rolling back to a savepoint that
+	// belongs to another transaction or that was discarded by rolling back to
+	// an earlier savepoint.
+	ErrInvalidSavepoint = errors.New("invalid savepoint")
+
+	// ErrSavepointBulkLoad is returned when creating a savepoint in a
+	// transaction which bulk loads a bucket, or bulk loading a bucket in a
+	// transaction which has savepoints.
+	ErrSavepointBulkLoad = errors.New("savepoints can not be combined with bulk loads")
 )
 
 // These errors can occur when putting or deleting a value or a bucket.
@@ -75,4 +108,99 @@ var (
 	// on an existing non-bucket key or when trying to create or delete a
 	// non-bucket key on an existing bucket key.
 	ErrIncompatibleValue = errors.New("incompatible value")
+
+	// ErrUnknownCodec is returned when opening a bucket whose values are
+	// compressed by a codec which is not registered.
+	ErrUnknownCodec = errors.New("unknown codec")
+
+	// ErrUnknownComparator is returned when opening a bucket whose keys are
+	// ordered by a comparator which is not registered.
+	ErrUnknownComparator = errors.New("unknown comparator")
+
+	// ErrInvalidComparator is returned when creating a bucket with a
+	// comparator whose name is empty or too long.
+	ErrInvalidComparator = errors.New("invalid comparator name")
+
+	// ErrInvalidBucketOptions is returned when opening a bucket whose header
+	// holds options which are invalid or not supported.
+	ErrInvalidBucketOptions = errors.New("invalid bucket options")
+
+	// ErrInvalidMove is returned when moving a bucket into itself or into
+	// one of its nested buckets.
+	ErrInvalidMove = errors.New("can not move a bucket into itself")
+
+	// ErrInvalidTTL is returned when putting a key with a TTL which is not
+	// positive.
+	ErrInvalidTTL = errors.New("ttl must be positive")
+
+	// ErrInvalidExpiryIndex is returned when an entry of the index of the
+	// keys with an expiry time can not be decoded.
+	ErrInvalidExpiryIndex = errors.New("invalid expiry index entry")
+
+	// ErrBucketNameReserved is returned when creating a bucket in the root
+	// of the database with a name reserved for internal buckets, such as the
+	// expiry index and secondary indexes.
+	ErrBucketNameReserved = errors.New("bucket name is reserved")
+
+	// ErrReservedNamesInUse is returned when putting a key with a TTL or
+	// declaring an index in a database created by an earlier version, which
+	// has buckets in the root using the names reserved for internal buckets.
+	ErrReservedNamesInUse = errors.New("bucket names reserved for internal buckets are in use")
+
+	// ErrIndexNameRequired is returned when declaring an index with a blank
+	// name.
+	ErrIndexNameRequired = errors.New("index name required")
+
+	// ErrIndexExists is returned when declaring an index with the name of an
+	// index which is already declared.
+	ErrIndexExists = errors.New("index already exists")
+
+	// ErrKeyOutOfOrder is returned when a key added to a bulk loader is not
+	// greater than the previous one.
+	ErrKeyOutOfOrder = errors.New("key out of order")
+
+	// ErrLoaderClosed is returned when adding a key to a bulk loader which
+	// has been closed.
+	ErrLoaderClosed = errors.New("bulk loader closed")
+
+	// ErrBucketNotDeclared is returned when a transaction begun with
+	// DB.BeginBuckets writes to a top-level bucket which it did not declare.
+	ErrBucketNotDeclared = errors.New("bucket not declared by transaction")
+
+	// ErrScopedBulkLoad is returned when bulk loading a bucket in a
+	// transaction begun with DB.BeginBuckets.
+	ErrScopedBulkLoad = errors.New("bulk loads can not be used in bucket-scoped transactions")
+)
+
+// These errors can occur when applying an incremental backup.
+var (
+	// ErrInvalidIncrement is returned when a stream is not a valid
+	// incremental backup.
+	ErrInvalidIncrement = errors.New("invalid incremental backup")
+
+	// ErrIncrementMismatch is returned when an incremental backup does not
+	// start at the transaction the base database is at.
+	ErrIncrementMismatch = errors.New("incremental backup does not match base database")
+)
+
+// These errors can occur when importing a logical dump.
+var (
+	// ErrInvalidExport is returned when a stream is not a valid dump written
+	// by Tx.Export.
+	ErrInvalidExport = errors.New("invalid export")
 )
+
+// CorruptionError is returned when a corrupted page is read from the database.
+// Err describes the corruption, such as ErrPageChecksum or ErrDecryption.
+type CorruptionError struct {
+	Pgid Pgid
+	Err  error
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("page %d: %s", e.Pgid, e.Err)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return e.Err
+}
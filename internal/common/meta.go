@@ -23,7 +23,9 @@ type Meta struct {
 func (m *Meta) Validate() error {
 	if m.magic != Magic {
 		return ErrInvalid
-	} else if m.version != Version {
+	} else if m.version != Version && m.version != VersionChecksums && m.version != VersionFeatures {
+		return ErrVersionMismatch
+	} else if m.version == VersionFeatures && m.flags&^MetaKnownFlags != 0 {
 		return ErrVersionMismatch
 	} else if m.checksum != m.Sum64() {
 		return ErrChecksum
@@ -70,10 +72,40 @@ func (m *Meta) SetMagic(v uint32) {
 	m.magic = v
 }
 
+func (m *Meta) Version() uint32 {
+	return m.version
+}
+
 func (m *Meta) SetVersion(v uint32) {
 	m.version = v
 }
 
+// PageChecksums returns whether every page but the meta pages stores a checksum.
+func (m *Meta) PageChecksums() bool {
+	return m.version == VersionChecksums || (m.version == VersionFeatures && m.flags&MetaChecksumsFlag != 0)
+}
+
+// PageTxids returns whether every page but the meta pages stores the id of the
+// transaction which wrote it.
+func (m *Meta) PageTxids() bool {
+	return m.version == VersionFeatures && m.flags&MetaPageTxidsFlag != 0
+}
+
+// AddFeatures sets the given feature flags, and raises the format version to
+// VersionFeatures so that older versions of bbolt do not open the database.
+// It returns whether any of the flags was not set before.
+func (m *Meta) AddFeatures(flags uint32) bool {
+	if m.flags&flags == flags {
+		return false
+	}
+	if m.version == VersionChecksums {
+		flags |= MetaChecksumsFlag
+	}
+	m.version = VersionFeatures
+	m.flags |= flags
+	return true
+}
+
 func (m *Meta) PageSize() uint32 {
 	return m.pageSize
 }
@@ -2,6 +2,7 @@ package common
 
 import (
 	"fmt"
+	"hash/crc32"
 	"os"
 	"sort"
 	"unsafe"
@@ -23,8 +24,30 @@ const (
 
 const (
 	BucketLeafFlag = 0x01
+
+	// BucketExtLeafFlag marks a bucket entry whose header is followed by a
+	// BucketExt, before the inline page of the bucket.
+	BucketExtLeafFlag = 0x02
+
+	// CompressedLeafFlag marks a value compressed by the codec of its bucket.
+	CompressedLeafFlag = 0x04
+
+	// ExpiringLeafFlag marks a value prefixed with the time it expires at, in
+	// big endian nanoseconds since the epoch.
+	ExpiringLeafFlag = 0x08
 )
 
+// PageChecksumSize is the size of the checksum stored at the end of every page
+// but the meta pages, in data files of format version VersionChecksums.
+const PageChecksumSize = 4
+
+// PageTxidSize is the size of the id of the transaction which wrote a page,
+// stored before the checksum of every page but the meta pages in data files
+// with MetaPageTxidsFlag.
+const PageTxidSize = 8
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
 type Pgid uint64
 
 type Page struct {
@@ -205,6 +228,48 @@ func (p *Page) SetOverflow(target uint32) {
 	p.overflow = target
 }
 
+// checksumOffset returns the offset of the checksum from the start of the page.
+func (p *Page) checksumOffset(pageSize int) int {
+	return (int(p.overflow)+1)*pageSize - PageChecksumSize
+}
+
+// Txid returns the id of the transaction which wrote the page, stored before
+// its checksum.
+func (p *Page) Txid(pageSize int) Txid {
+	return *(*Txid)(UnsafeAdd(unsafe.Pointer(p), uintptr(p.checksumOffset(pageSize)-PageTxidSize)))
+}
+
+// SetTxid stores the id of the transaction which writes the page before its
+// checksum, which must be set afterwards.
+func (p *Page) SetTxid(pageSize int, txid Txid) {
+	*(*Txid)(UnsafeAdd(unsafe.Pointer(p), uintptr(p.checksumOffset(pageSize)-PageTxidSize))) = txid
+}
+
+// Sum32 computes the checksum of the page, including its overflow pages. It
+// covers every byte but the checksum itself.
+func (p *Page) Sum32(pageSize int) uint32 {
+	return crc32.Checksum(UnsafeByteSlice(unsafe.Pointer(p), 0, 0, p.checksumOffset(pageSize)), castagnoli)
+}
+
+// Checksum returns the checksum stored at the end of the page.
+func (p *Page) Checksum(pageSize int) uint32 {
+	return *(*uint32)(UnsafeAdd(unsafe.Pointer(p), uintptr(p.checksumOffset(pageSize))))
+}
+
+// SetChecksum computes the checksum of the page and stores it at its end.
+func (p *Page) SetChecksum(pageSize int) {
+	*(*uint32)(UnsafeAdd(unsafe.Pointer(p), uintptr(p.checksumOffset(pageSize)))) = p.Sum32(pageSize)
+}
+
+// VerifyChecksum returns ErrPageChecksum if the checksum stored at the end of
+// the page does not match its contents.
+func (p *Page) VerifyChecksum(pageSize int) error {
+	if p.Checksum(pageSize) != p.Sum32(pageSize) {
+		return ErrPageChecksum
+	}
+	return nil
+}
+
 func (p *Page) String() string {
 	return fmt.Sprintf("ID: %d, Type: %s, count: %d, overflow: %d", p.id, p.Typ(), p.count, p.overflow)
 }
@@ -326,6 +391,17 @@ func (n *leafPageElement) Bucket() *InBucket {
 	}
 }
 
+// InlinePage returns the inline page of a bucket entry, which follows the
+// bucket header and its extension, if any.
+func (n *leafPageElement) InlinePage() *Page {
+	v := n.Value()
+	off := BucketHeaderSize
+	if n.flags&uint32(BucketExtLeafFlag) != 0 {
+		off += BucketExtSize(v[off:])
+	}
+	return (*Page)(unsafe.Pointer(&v[off]))
+}
+
 // PageInfo represents human readable information about a page.
 type PageInfo struct {
 	ID            int
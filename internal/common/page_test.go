@@ -31,6 +31,24 @@ func TestPage_dump(t *testing.T) {
 	(&Page{id: 256}).hexdump(16)
 }
 
+// Ensure that the checksum of a page covers its overflow pages and detects
+// changes to them.
+func TestPage_checksum(t *testing.T) {
+	buf := make([]byte, 2*1024)
+	p := LoadPage(buf)
+	p.SetId(3)
+	p.SetOverflow(1)
+	p.SetChecksum(1024)
+	if err := p.VerifyChecksum(1024); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	buf[1500] = 0xff
+	if err := p.VerifyChecksum(1024); err != ErrPageChecksum {
+		t.Fatalf("exp=%v; got=%v", ErrPageChecksum, err)
+	}
+}
+
 func TestPgids_merge(t *testing.T) {
 	a := Pgids{4, 5, 6, 10, 11, 12, 13, 27}
 	b := Pgids{1, 3, 8, 9, 25, 30}
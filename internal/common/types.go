@@ -12,6 +12,47 @@ const MaxMmapStep = 1 << 30 // 1GB
 // Version represents the data file format version.
 const Version = 2
 
+// VersionChecksums is the data file format version which stores a checksum
+// at the end of every page but the meta pages.
+const VersionChecksums = 3
+
+// VersionFeatures is the data file format version of databases which use
+// features that older versions of bbolt can not read. The features in use are
+// recorded in the meta flags, and the pages carry a checksum like in version
+// VersionChecksums if MetaChecksumsFlag is set.
+const VersionFeatures = 4
+
+// MetaEncryptedFlag is set in the meta flags of encrypted databases.
+const MetaEncryptedFlag = 0x01
+
+// MetaReservedNamesFlag is set in the meta flags of databases which reserve the
+// names starting with "\x00bbolt." in the root bucket for internal buckets.
+const MetaReservedNamesFlag = 0x02
+
+// MetaChecksumsFlag is set in the meta flags of databases of format version
+// VersionFeatures which store a checksum at the end of every page but the meta
+// pages.
+const MetaChecksumsFlag = 0x04
+
+// MetaBucketExtFlag is set in the meta flags of databases of format version
+// VersionFeatures which may hold buckets with options, or values compressed by
+// a codec.
+const MetaBucketExtFlag = 0x08
+
+// MetaExpiryFlag is set in the meta flags of databases of format version
+// VersionFeatures which may hold values with an expiry time.
+const MetaExpiryFlag = 0x10
+
+// MetaPageTxidsFlag is set in the meta flags of databases of format version
+// VersionFeatures created with that version, which store the id of the
+// transaction which wrote every page but the meta pages before its checksum.
+// It's only set along with MetaChecksumsFlag.
+const MetaPageTxidsFlag = 0x20
+
+// MetaKnownFlags are the meta flags understood by this version of bbolt.
+// Databases of format version VersionFeatures with other flags are rejected.
+const MetaKnownFlags = MetaEncryptedFlag | MetaReservedNamesFlag | MetaChecksumsFlag | MetaBucketExtFlag | MetaExpiryFlag | MetaPageTxidsFlag
+
 // Magic represents a marker value to indicate that a file is a Bolt DB.
 const Magic uint32 = 0xED0CDAED
 
@@ -65,16 +65,23 @@ func ReadPage(path string, pageID uint64) (*common.Page, []byte, error) {
 	return p, buf, nil
 }
 
+// WritePage writes a Page (with overflow) to a path. The checksum of the Page
+// is updated if the file stores Page checksums.
+// This is not transactionally safe.
 func WritePage(path string, pageBuf []byte) error {
 	page := common.LoadPage(pageBuf)
-	pageSize, _, err := ReadPageAndHWMSize(path)
+	m, err := ReadMeta(path)
 	if err != nil {
 		return err
 	}
+	pageSize := uint64(m.PageSize())
 	expectedLen := pageSize * (uint64(page.Overflow()) + 1)
 	if expectedLen != uint64(len(pageBuf)) {
 		return fmt.Errorf("WritePage: len(buf):%d != pageSize*(overflow+1):%d", len(pageBuf), expectedLen)
 	}
+	if m.PageChecksums() && !page.IsMetaPage() {
+		page.SetChecksum(int(pageSize))
+	}
 	f, err := os.OpenFile(path, os.O_WRONLY, 0)
 	if err != nil {
 		return err
@@ -87,25 +94,35 @@ func WritePage(path string, pageBuf []byte) error {
 // ReadPageAndHWMSize reads Page size and HWM (id of the last+1 Page).
 // This is not transactionally safe.
 func ReadPageAndHWMSize(path string) (uint64, common.Pgid, error) {
+	m, err := ReadMeta(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	return uint64(m.PageSize()), common.Pgid(m.Pgid()), nil
+}
+
+// ReadMeta reads the first Meta page.
+// This is not transactionally safe.
+func ReadMeta(path string) (*common.Meta, error) {
 	// Open database file.
 	f, err := os.Open(path)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 	defer f.Close()
 
 	// Read 4KB chunk.
 	buf := make([]byte, 4096)
 	if _, err := io.ReadFull(f, buf); err != nil {
-		return 0, 0, err
+		return nil, err
 	}
 
 	// Read Page size from metadata.
 	m := common.LoadPageMeta(buf)
 	if m.Magic() != common.Magic {
-		return 0, 0, fmt.Errorf("the Meta Page has wrong (unexpected) magic")
+		return nil, fmt.Errorf("the Meta Page has wrong (unexpected) magic")
 	}
-	return uint64(m.PageSize()), common.Pgid(m.Pgid()), nil
+	return m, nil
 }
 
 // GetRootPage returns the root-page (according to the most recent transaction).
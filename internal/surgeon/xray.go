@@ -56,7 +56,7 @@ func (n XRay) traverse(stack []common.Pgid, callback func(page *common.Page, sta
 						return err
 					}
 				} else {
-					inlinePage := lpe.Bucket().InlinePage(lpe.Value())
+					inlinePage := lpe.InlinePage()
 					if err := callback(inlinePage, stack); err != nil {
 						return fmt.Errorf("failed callback for inline page  (stack %v): %w", stack, err)
 					}
@@ -0,0 +1,165 @@
+//go:build go1.23
+
+package bbolt
+
+import (
+	"bytes"
+	"iter"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// The iterators of a cursor move the cursor, starting from the position the
+// scan requires each time they are used. Like the other methods of a cursor,
+// they yield nested buckets with a nil value. The iterators of a bucket use a
+// cursor of their own, and skip nested buckets, which can be listed with
+// ForEachBucket instead.
+//
+// Keys and values are only valid for the life of the transaction. If the
+// context of the transaction is done, an iteration stops early and the Err
+// method of the cursor returns the context's error. Likewise, an iteration
+// which reads a corrupted page stops, and the iterators of a cursor leave the
+// CorruptionError to its Err method, while the iterators of a bucket panic
+// with it like Get.
+
+// All returns an iterator over all keys of the bucket of the cursor, in
+// ascending order, including nested buckets.
+func (c *Cursor) All() iter.Seq2[[]byte, []byte] {
+	return c.all(false)
+}
+
+// Backward returns an iterator over all keys of the bucket of the cursor, in
+// descending order, including nested buckets.
+func (c *Cursor) Backward() iter.Seq2[[]byte, []byte] {
+	return c.rangeBackward(nil, nil, false)
+}
+
+// Prefix returns an iterator over the keys of the bucket of the cursor which
+// start with prefix, in ascending order, including nested buckets.
+func (c *Cursor) Prefix(prefix []byte) iter.Seq2[[]byte, []byte] {
+	return c.prefix(prefix, false)
+}
+
+// Range returns an iterator over the keys of the bucket of the cursor from
+// start up to, but not including, end, in ascending order, including nested
+// buckets. A nil start or end leaves that end of the range open.
+func (c *Cursor) Range(start, end []byte) iter.Seq2[[]byte, []byte] {
+	return c.rangeForward(start, end, false)
+}
+
+// RangeBackward is like Range, but iterates over the keys in descending order.
+func (c *Cursor) RangeBackward(start, end []byte) iter.Seq2[[]byte, []byte] {
+	return c.rangeBackward(start, end, false)
+}
+
+// All returns an iterator over all key/value pairs of the bucket, in ascending
+// order of their keys. Nested buckets are skipped.
+func (b *Bucket) All() iter.Seq2[[]byte, []byte] {
+	c := b.Cursor()
+	return c.checked(c.all(true))
+}
+
+// Backward returns an iterator over all key/value pairs of the bucket, in
+// descending order of their keys. Nested buckets are skipped.
+func (b *Bucket) Backward() iter.Seq2[[]byte, []byte] {
+	c := b.Cursor()
+	return c.checked(c.rangeBackward(nil, nil, true))
+}
+
+// Prefix returns an iterator over the key/value pairs of the bucket whose keys
+// start with prefix, in ascending order. Nested buckets are skipped.
+func (b *Bucket) Prefix(prefix []byte) iter.Seq2[[]byte, []byte] {
+	c := b.Cursor()
+	return c.checked(c.prefix(prefix, true))
+}
+
+// Range returns an iterator over the key/value pairs of the bucket with keys
+// from start up to, but not including, end, in ascending order. A nil start
+// or end leaves that end of the range open. Nested buckets are skipped.
+func (b *Bucket) Range(start, end []byte) iter.Seq2[[]byte, []byte] {
+	c := b.Cursor()
+	return c.checked(c.rangeForward(start, end, true))
+}
+
+// RangeBackward is like Range, but iterates over the key/value pairs in
+// descending order of their keys.
+func (b *Bucket) RangeBackward(start, end []byte) iter.Seq2[[]byte, []byte] {
+	c := b.Cursor()
+	return c.checked(c.rangeBackward(start, end, true))
+}
+
+// checked wraps an iterator of a bucket, which panics with the
+// CorruptionError which stopped its cursor, as the caller can't reach it.
+func (c *Cursor) checked(seq iter.Seq2[[]byte, []byte]) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		seq(yield)
+		c.panicOnCorruption()
+	}
+}
+
+func (c *Cursor) all(skipBuckets bool) iter.Seq2[[]byte, []byte] {
+	return c.rangeForward(nil, nil, skipBuckets)
+}
+
+func (c *Cursor) prefix(prefix []byte, skipBuckets bool) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if skipBuckets && c.onBucket() {
+				continue
+			}
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+func (c *Cursor) rangeForward(start, end []byte, skipBuckets bool) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		var k, v []byte
+		if start == nil {
+			k, v = c.First()
+		} else {
+			k, v = c.Seek(start)
+		}
+		for ; k != nil && (end == nil || c.bucket.compare(k, end) < 0); k, v = c.Next() {
+			if skipBuckets && c.onBucket() {
+				continue
+			}
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+func (c *Cursor) rangeBackward(start, end []byte, skipBuckets bool) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		// Move to the last key before end.
+		var k, v []byte
+		if end == nil {
+			k, v = c.Last()
+		} else if k, _ = c.Seek(end); k == nil {
+			if c.err != nil {
+				return
+			}
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		for ; k != nil && (start == nil || c.bucket.compare(k, start) >= 0); k, v = c.Prev() {
+			if skipBuckets && c.onBucket() {
+				continue
+			}
+			if !yield(k, v) {
+				return
+			}
+		}
+	}
+}
+
+// onBucket reports whether the cursor is on a nested bucket.
+func (c *Cursor) onBucket() bool {
+	_, _, flags := c.keyValue()
+	return flags&common.BucketLeafFlag != 0
+}
@@ -0,0 +1,105 @@
+//go:build go1.23
+
+package bbolt_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// collect returns the keys yielded by an iterator, with the values of nested
+// buckets marked by a "/" suffix.
+func collect(seq func(func([]byte, []byte) bool)) []string {
+	var keys []string
+	for k, v := range seq {
+		if v == nil {
+			keys = append(keys, string(k)+"/")
+		} else {
+			keys = append(keys, string(k))
+		}
+	}
+	return keys
+}
+
+// Ensure that the iterators of cursors and buckets yield the keys of full,
+// prefix and range scans in both directions.
+func TestBucket_Iterators(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for _, k := range []string{"a", "ba", "bb", "bc", "c", "d"} {
+			require.NoError(t, b.Put([]byte(k), []byte("v")))
+		}
+		_, err = b.CreateBucket([]byte("bd"))
+		return err
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		c := b.Cursor()
+
+		// Cursors include nested buckets.
+		require.Equal(t, []string{"a", "ba", "bb", "bc", "bd/", "c", "d"}, collect(c.All()))
+		require.Equal(t, []string{"d", "c", "bd/", "bc", "bb", "ba", "a"}, collect(c.Backward()))
+		require.Equal(t, []string{"ba", "bb", "bc", "bd/"}, collect(c.Prefix([]byte("b"))))
+		require.Equal(t, []string{"bb", "bc", "bd/"}, collect(c.Range([]byte("bb"), []byte("c"))))
+		require.Equal(t, []string{"bd/", "bc", "bb"}, collect(c.RangeBackward([]byte("bb"), []byte("c"))))
+
+		// Buckets skip them.
+		require.Equal(t, []string{"a", "ba", "bb", "bc", "c", "d"}, collect(b.All()))
+		require.Equal(t, []string{"d", "c", "bc", "bb", "ba", "a"}, collect(b.Backward()))
+		require.Equal(t, []string{"ba", "bb", "bc"}, collect(b.Prefix([]byte("b"))))
+		require.Nil(t, collect(b.Prefix([]byte("x"))))
+
+		// Open and missing range bounds.
+		require.Equal(t, []string{"a", "ba"}, collect(b.Range(nil, []byte("bb"))))
+		require.Equal(t, []string{"c", "d"}, collect(b.Range([]byte("bz"), nil)))
+		require.Nil(t, collect(b.Range([]byte("c"), []byte("c"))))
+		require.Equal(t, []string{"ba", "a"}, collect(b.RangeBackward(nil, []byte("bb"))))
+		require.Equal(t, []string{"d", "c"}, collect(b.RangeBackward([]byte("bz"), []byte("z"))))
+		require.Equal(t, []string{"bc", "bb"}, collect(b.RangeBackward([]byte("bb"), []byte("bcc"))))
+
+		// Iteration can be stopped early, and iterators can be reused.
+		seq := b.All()
+		for k := range seq {
+			require.Equal(t, []byte("a"), k)
+			break
+		}
+		require.Len(t, collect(seq), 6)
+		return nil
+	}))
+}
+
+// Ensure that iterators stop when the context of the transaction is done.
+func TestBucket_Iterators_Context(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, b.Put([]byte{byte(i >> 8), byte(i)}, make([]byte, 100)))
+		}
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, db.ViewContext(ctx, func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		var n int
+		for range c.All() {
+			if n++; n == 10 {
+				cancel()
+			}
+		}
+		require.Less(t, n, 1000)
+		require.ErrorIs(t, c.Err(), context.Canceled)
+		return nil
+	}))
+}
@@ -0,0 +1,145 @@
+package bbolt
+
+import (
+	"time"
+)
+
+// Logger receives the log messages of a database. Each message comes with
+// alternating keys and values describing it, such as "size", 65536. The
+// methods of a *slog.Logger satisfy the interface.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// discardLogger is the default logger, which discards every message.
+type discardLogger struct{}
+
+func (discardLogger) Debug(msg string, keyvals ...interface{}) {}
+func (discardLogger) Info(msg string, keyvals ...interface{})  {}
+func (discardLogger) Warn(msg string, keyvals ...interface{})  {}
+func (discardLogger) Error(msg string, keyvals ...interface{}) {}
+
+// EventType is the type of a lifecycle event of a database.
+type EventType int
+
+const (
+	// EventMmap is sent after the data file is memory mapped. Size is the
+	// size of the new memory map in bytes.
+	EventMmap EventType = iota + 1
+
+	// EventMunmap is sent after the memory map is released.
+	EventMunmap
+
+	// EventGrow is sent after the data file is grown. Size is the new size
+	// of the file in bytes.
+	EventGrow
+
+	// EventFreelistLoad is sent after the freelist is read from its page or
+	// rebuilt by scanning the database. Size is the number of free pages.
+	EventFreelistLoad
+
+	// EventCommitRebalance, EventCommitSpill, EventCommitFreelist,
+	// EventCommitWrite and EventCommitMeta are sent after each phase of a
+	// commit: merging nodes which had deletions, writing nodes into dirty
+	// pages, writing the freelist, writing the dirty pages to disk, and
+	// writing the meta page. Size is the number of bytes written for
+	// EventCommitWrite.
+	EventCommitRebalance
+	EventCommitSpill
+	EventCommitFreelist
+	EventCommitWrite
+	EventCommitMeta
+
+	// EventCommit is sent once a commit succeeds or fails. Duration covers
+	// all of its phases.
+	EventCommit
+
+	// EventClose is sent after the database is closed.
+	EventClose
+
+	// EventLongReadTx is sent when a read transaction has been open for
+	// longer than Options.MaxReadTxAge. Txid is the id of the transaction,
+	// Duration its age and Size the number of freed pages it keeps from
+	// being reused.
+	EventLongReadTx
+)
+
+// String returns the name of the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventMmap:
+		return "mmap"
+	case EventMunmap:
+		return "munmap"
+	case EventGrow:
+		return "grow"
+	case EventFreelistLoad:
+		return "freelist-load"
+	case EventCommitRebalance:
+		return "commit-rebalance"
+	case EventCommitSpill:
+		return "commit-spill"
+	case EventCommitFreelist:
+		return "commit-freelist"
+	case EventCommitWrite:
+		return "commit-write"
+	case EventCommitMeta:
+		return "commit-meta"
+	case EventCommit:
+		return "commit"
+	case EventClose:
+		return "close"
+	case EventLongReadTx:
+		return "long-read-tx"
+	}
+	return "unknown"
+}
+
+// Event is a lifecycle event of a database.
+type Event struct {
+	Type EventType
+
+	// Txid is the id of the transaction of commit events.
+	Txid int
+
+	// Duration is the time the operation took.
+	Duration time.Duration
+
+	// Size is the size reported by the event type, or 0.
+	Size int
+
+	// Err is the error the operation failed with, if any.
+	Err error
+}
+
+// EventHook receives the lifecycle events of a database. It is called
+// synchronously, while the database holds its locks, so it must return
+// quickly and must not use the database.
+type EventHook interface {
+	OnEvent(e Event)
+}
+
+// EventHookFunc is a function which is used as an EventHook.
+type EventHookFunc func(e Event)
+
+// OnEvent calls f(e).
+func (f EventHookFunc) OnEvent(e Event) { f(e) }
+
+// nopHook is the default event hook, which ignores every event.
+type nopHook struct{}
+
+func (nopHook) OnEvent(e Event) {}
+
+// event sends an event to the event hook, and logs it with the given message
+// and key/value pairs: at the debug level, or at the error level if it failed.
+func (db *DB) event(e Event, msg string, keyvals ...interface{}) {
+	db.hook.OnEvent(e)
+	if e.Err != nil {
+		db.logger.Error(msg+" failed", append(keyvals, "error", e.Err)...)
+		return
+	}
+	db.logger.Debug(msg, append(keyvals, "duration", e.Duration)...)
+}
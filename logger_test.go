@@ -0,0 +1,81 @@
+package bbolt_test
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// recordingLogger records the messages logged at each level.
+type recordingLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recordingLogger) log(level, msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, level+": "+msg)
+}
+
+func (l *recordingLogger) Debug(msg string, keyvals ...interface{}) { l.log("debug", msg) }
+func (l *recordingLogger) Info(msg string, keyvals ...interface{})  { l.log("info", msg) }
+func (l *recordingLogger) Warn(msg string, keyvals ...interface{})  { l.log("warn", msg) }
+func (l *recordingLogger) Error(msg string, keyvals ...interface{}) { l.log("error", msg) }
+
+// Ensure that the lifecycle events of a database are sent to its event hook
+// and logger.
+func TestOptions_EventHook(t *testing.T) {
+	var events []bolt.Event
+	logger := &recordingLogger{}
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "db"), 0600, &bolt.Options{
+		Logger:    logger,
+		EventHook: bolt.EventHookFunc(func(e bolt.Event) { events = append(events, e) }),
+	})
+	require.NoError(t, err)
+
+	var txid int
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		txid = tx.ID()
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, b.Put([]byte{byte(i >> 8), byte(i)}, make([]byte, 500)))
+		}
+		return nil
+	}))
+	require.NoError(t, db.Close())
+
+	byType := make(map[bolt.EventType][]bolt.Event)
+	var types []bolt.EventType
+	for _, e := range events {
+		byType[e.Type] = append(byType[e.Type], e)
+		if e.Txid == txid {
+			types = append(types, e.Type)
+		}
+	}
+
+	require.Equal(t, []bolt.EventType{
+		bolt.EventCommitRebalance,
+		bolt.EventCommitSpill,
+		bolt.EventCommitFreelist,
+		bolt.EventCommitWrite,
+		bolt.EventCommitMeta,
+		bolt.EventCommit,
+	}, types)
+	require.Greater(t, byType[bolt.EventCommitWrite][0].Size, 500*1000)
+	require.Len(t, byType[bolt.EventFreelistLoad], 1)
+	require.NotEmpty(t, byType[bolt.EventGrow])
+	require.Greater(t, len(byType[bolt.EventMmap]), 1)
+	require.NotEmpty(t, byType[bolt.EventMunmap])
+	require.Len(t, byType[bolt.EventClose], 1)
+	require.Equal(t, "commit-write", bolt.EventCommitWrite.String())
+
+	require.Contains(t, logger.msgs, "info: database opened")
+	require.Contains(t, logger.msgs, "debug: commit")
+	require.Contains(t, logger.msgs, "info: database closed")
+}
@@ -0,0 +1,61 @@
+package bbolt
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// mapping is a memory map of the data file, returned by Storage.Mmap.
+//
+// The file is mapped again when it grows, without waiting for the read
+// transactions which are using the current mapping: each read transaction
+// holds a reference to the mapping it began with, and a mapping is unmapped
+// once it has been replaced and its last read transaction closed.
+type mapping struct {
+	ref  []byte // mmap'ed readonly, write throws SEGV
+	data *[maxMapSize]byte
+	size int
+
+	// refs counts the read transactions using the mapping, plus one while
+	// it's the current mapping of the database. It's updated atomically,
+	// since transactions release their mapping without the meta lock.
+	refs int32
+}
+
+// newMapping returns a mapping of the view b, referenced as the current
+// mapping of the database.
+func newMapping(b []byte) *mapping {
+	return &mapping{
+		ref:  b,
+		data: (*[maxMapSize]byte)(unsafe.Pointer(&b[0])),
+		size: len(b),
+		refs: 1,
+	}
+}
+
+// page retrieves a page reference from the mapping.
+func (m *mapping) page(id common.Pgid, pageSize int) *common.Page {
+	pos := id * common.Pgid(pageSize)
+	return (*common.Page)(unsafe.Pointer(&m.data[pos]))
+}
+
+// acquire adds a reference to the mapping. It must be called with the meta
+// lock held, so that the mapping can not be replaced and unmapped meanwhile.
+func (m *mapping) acquire() {
+	atomic.AddInt32(&m.refs, 1)
+}
+
+// release drops a reference to a mapping, and unmaps it if it was the last
+// one.
+func (db *DB) release(m *mapping) error {
+	if atomic.AddInt32(&m.refs, -1) > 0 {
+		return nil
+	}
+	start := time.Now()
+	err := db.storage.Munmap(m.ref)
+	db.event(Event{Type: EventMunmap, Duration: time.Since(start), Size: m.size, Err: err}, "munmap", "size", m.size)
+	return err
+}
@@ -0,0 +1,199 @@
+// Package metrics exports the statistics of a bbolt database as metrics.
+//
+// A Collector reads DB.Stats and reports one value per metric, either in the
+// Prometheus text exposition format with WriteTo, or to any other metrics
+// system through a Sink. The package has no dependencies outside of bbolt and
+// the standard library.
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Kind is the kind of a metric.
+type Kind int
+
+const (
+	// Gauge is a metric whose value can go up and down.
+	Gauge Kind = iota
+
+	// Counter is a metric whose value only goes up, until the database is
+	// reopened.
+	Counter
+)
+
+// String returns the name of the kind in the Prometheus text format.
+func (k Kind) String() string {
+	if k == Counter {
+		return "counter"
+	}
+	return "gauge"
+}
+
+// Metric is the value of a metric at the time it was collected.
+type Metric struct {
+	Name   string
+	Help   string
+	Kind   Kind
+	Labels map[string]string
+	Value  float64
+}
+
+// Sink receives the metrics reported by a Collector. It adapts the collector
+// to a metrics system.
+type Sink interface {
+	Report(m Metric)
+}
+
+// SinkFunc is a function which is used as a Sink.
+type SinkFunc func(m Metric)
+
+// Report calls f(m).
+func (f SinkFunc) Report(m Metric) { f(m) }
+
+// Collector collects the metrics of a database.
+type Collector struct {
+	db *bolt.DB
+
+	// Labels are added to every metric, for example to tell apart the
+	// databases of a process.
+	Labels map[string]string
+}
+
+// NewCollector returns a collector for the metrics of db.
+func NewCollector(db *bolt.DB) *Collector {
+	return &Collector{db: db}
+}
+
+// metric describes a metric and reads its value from the stats.
+type metric struct {
+	name  string
+	help  string
+	kind  Kind
+	value func(s *bolt.Stats) float64
+}
+
+// metrics are the metrics reported by collectors, in order.
+var metrics = []metric{
+	{"bbolt_free_pages", "Number of free pages on the freelist.", Gauge,
+		func(s *bolt.Stats) float64 { return float64(s.FreePageN) }},
+	{"bbolt_pending_pages", "Number of pages freed by transactions which may still be read.", Gauge,
+		func(s *bolt.Stats) float64 { return float64(s.PendingPageN) }},
+	{"bbolt_free_alloc_bytes", "Bytes allocated in free pages.", Gauge,
+		func(s *bolt.Stats) float64 { return float64(s.FreeAlloc) }},
+	{"bbolt_freelist_inuse_bytes", "Bytes used by the freelist.", Gauge,
+		func(s *bolt.Stats) float64 { return float64(s.FreelistInuse) }},
+	{"bbolt_open_read_txs", "Number of open read transactions.", Gauge,
+		func(s *bolt.Stats) float64 { return float64(s.OpenTxN) }},
+	{"bbolt_mmap_size_bytes", "Size of the memory map.", Gauge,
+		func(s *bolt.Stats) float64 { return float64(s.MmapSize) }},
+	{"bbolt_file_size_bytes", "Size of the data file.", Gauge,
+		func(s *bolt.Stats) float64 { return float64(s.FileSize) }},
+	{"bbolt_read_txs_total", "Number of read transactions started.", Counter,
+		func(s *bolt.Stats) float64 { return float64(s.TxN) }},
+	{"bbolt_page_allocations_total", "Number of page allocations.", Counter,
+		func(s *bolt.Stats) float64 { return float64(s.TxStats.GetPageCount()) }},
+	{"bbolt_page_allocated_bytes_total", "Bytes allocated in pages.", Counter,
+		func(s *bolt.Stats) float64 { return float64(s.TxStats.GetPageAlloc()) }},
+	{"bbolt_cursors_total", "Number of cursors created.", Counter,
+		func(s *bolt.Stats) float64 { return float64(s.TxStats.GetCursorCount()) }},
+	{"bbolt_nodes_total", "Number of node allocations.", Counter,
+		func(s *bolt.Stats) float64 { return float64(s.TxStats.GetNodeCount()) }},
+	{"bbolt_node_derefs_total", "Number of node dereferences.", Counter,
+		func(s *bolt.Stats) float64 { return float64(s.TxStats.GetNodeDeref()) }},
+	{"bbolt_rebalances_total", "Number of node rebalances.", Counter,
+		func(s *bolt.Stats) float64 { return float64(s.TxStats.GetRebalance()) }},
+	{"bbolt_rebalance_seconds_total", "Time spent rebalancing nodes.", Counter,
+		func(s *bolt.Stats) float64 { return s.TxStats.GetRebalanceTime().Seconds() }},
+	{"bbolt_splits_total", "Number of nodes split.", Counter,
+		func(s *bolt.Stats) float64 { return float64(s.TxStats.GetSplit()) }},
+	{"bbolt_spills_total", "Number of nodes spilled.", Counter,
+		func(s *bolt.Stats) float64 { return float64(s.TxStats.GetSpill()) }},
+	{"bbolt_spill_seconds_total", "Time spent spilling nodes.", Counter,
+		func(s *bolt.Stats) float64 { return s.TxStats.GetSpillTime().Seconds() }},
+	{"bbolt_writes_total", "Number of writes to disk.", Counter,
+		func(s *bolt.Stats) float64 { return float64(s.TxStats.GetWrite()) }},
+	{"bbolt_write_seconds_total", "Time spent writing to disk.", Counter,
+		func(s *bolt.Stats) float64 { return s.TxStats.GetWriteTime().Seconds() }},
+	{"bbolt_write_calls_total", "Number of write system calls.", Counter,
+		func(s *bolt.Stats) float64 { return float64(s.TxStats.GetWriteCalls()) }},
+}
+
+// Collect reports the current value of every metric to sink. The metrics of
+// transactions are updated when the transactions close.
+func (c *Collector) Collect(sink Sink) {
+	stats := c.db.Stats()
+	for _, m := range metrics {
+		sink.Report(Metric{
+			Name:   m.name,
+			Help:   m.help,
+			Kind:   m.kind,
+			Labels: c.Labels,
+			Value:  m.value(&stats),
+		})
+	}
+}
+
+// WriteTo writes the current value of every metric to w in the Prometheus
+// text exposition format, and returns the number of bytes written.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	labels := formatLabels(c.Labels)
+	c.Collect(SinkFunc(func(m Metric) {
+		bw.WriteString("# HELP " + m.Name + " " + helpEscaper.Replace(m.Help) + "\n")
+		bw.WriteString("# TYPE " + m.Name + " " + m.Kind.String() + "\n")
+		bw.WriteString(m.Name + labels + " " + strconv.FormatFloat(m.Value, 'f', -1, 64) + "\n")
+	}))
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+// formatLabels returns labels in the Prometheus text format, sorted by name,
+// or an empty string if there are none.
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name + `="` + labelEscaper.Replace(labels[name]) + `"`)
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
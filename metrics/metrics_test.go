@@ -0,0 +1,58 @@
+package metrics_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/metrics"
+)
+
+// Ensure that the collector reports the stats of the database to sinks.
+func TestCollector_Collect(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}))
+
+	got := make(map[string]metrics.Metric)
+	c := metrics.NewCollector(db.DB)
+	c.Labels = map[string]string{"db": "test"}
+	c.Collect(metrics.SinkFunc(func(m metrics.Metric) {
+		got[m.Name] = m
+	}))
+
+	stats := db.Stats()
+	require.Equal(t, metrics.Gauge, got["bbolt_mmap_size_bytes"].Kind)
+	require.Equal(t, float64(stats.MmapSize), got["bbolt_mmap_size_bytes"].Value)
+	require.Positive(t, got["bbolt_file_size_bytes"].Value)
+	require.Equal(t, metrics.Counter, got["bbolt_writes_total"].Kind)
+	require.Equal(t, float64(stats.TxStats.GetWrite()), got["bbolt_writes_total"].Value)
+	require.Equal(t, float64(stats.TxStats.GetWriteCalls()), got["bbolt_write_calls_total"].Value)
+	require.Equal(t, map[string]string{"db": "test"}, got["bbolt_free_pages"].Labels)
+}
+
+// Ensure that the collector writes the Prometheus text format.
+func TestCollector_WriteTo(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	c := metrics.NewCollector(db.DB)
+	c.Labels = map[string]string{"path": `C:\db "1"`, "app": "test"}
+
+	var buf bytes.Buffer
+	n, err := c.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), n)
+
+	out := buf.String()
+	require.True(t, strings.HasPrefix(out, "# HELP bbolt_free_pages Number of free pages on the freelist.\n# TYPE bbolt_free_pages gauge\n"))
+	require.Contains(t, out, "# TYPE bbolt_splits_total counter\n")
+	require.Contains(t, out, `bbolt_open_read_txs{app="test",path="C:\\db \"1\""} 0`+"\n")
+}
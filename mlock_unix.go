@@ -8,11 +8,11 @@ import "golang.org/x/sys/unix"
 // mlock locks memory of db file
 func mlock(db *DB, fileSize int) error {
 	sizeToLock := fileSize
-	if sizeToLock > db.datasz {
+	if sizeToLock > db.mapping.size {
 		// Can't lock more than mmaped slice
-		sizeToLock = db.datasz
+		sizeToLock = db.mapping.size
 	}
-	if err := unix.Mlock(db.dataref[:sizeToLock]); err != nil {
+	if err := unix.Mlock(db.mapping.ref[:sizeToLock]); err != nil {
 		return err
 	}
 	return nil
@@ -20,17 +20,17 @@ func mlock(db *DB, fileSize int) error {
 
 // munlock unlocks memory of db file
 func munlock(db *DB, fileSize int) error {
-	if db.dataref == nil {
+	if db.mapping == nil {
 		return nil
 	}
 
 	sizeToUnlock := fileSize
-	if sizeToUnlock > db.datasz {
+	if sizeToUnlock > db.mapping.size {
 		// Can't unlock more than mmaped slice
-		sizeToUnlock = db.datasz
+		sizeToUnlock = db.mapping.size
 	}
 
-	if err := unix.Munlock(db.dataref[:sizeToUnlock]); err != nil {
+	if err := unix.Munlock(db.mapping.ref[:sizeToUnlock]); err != nil {
 		return err
 	}
 	return nil
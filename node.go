@@ -80,7 +80,7 @@ func (n *node) childAt(index int) *node {
 
 // childIndex returns the index of a given child node.
 func (n *node) childIndex(child *node) int {
-	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].Key(), child.key) != -1 })
+	index := sort.Search(len(n.inodes), func(i int) bool { return n.bucket.compare(n.inodes[i].Key(), child.key) >= 0 })
 	return index
 }
 
@@ -124,10 +124,17 @@ func (n *node) put(oldKey, newKey, value []byte, pgId common.Pgid, flags uint32)
 	}
 
 	// Find insertion index.
-	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].Key(), oldKey) != -1 })
+	index := sort.Search(len(n.inodes), func(i int) bool { return n.bucket.compare(n.inodes[i].Key(), oldKey) >= 0 })
 
 	// Add capacity and shift nodes if we don't have an exact match and need to insert.
 	exact := len(n.inodes) > 0 && index < len(n.inodes) && bytes.Equal(n.inodes[index].Key(), oldKey)
+	if n.isLeaf {
+		if exact {
+			n.bucket.count(n.inodes[index].Flags(), n.inodes[index].Value(), -1)
+		}
+		n.bucket.count(flags, value, 1)
+		n.bucket.tx.useLeafFeatures(flags)
+	}
 	if !exact {
 		n.inodes = append(n.inodes, common.Inode{})
 		copy(n.inodes[index+1:], n.inodes[index:])
@@ -144,13 +151,17 @@ func (n *node) put(oldKey, newKey, value []byte, pgId common.Pgid, flags uint32)
 // del removes a key from the node.
 func (n *node) del(key []byte) {
 	// Find index of key.
-	index := sort.Search(len(n.inodes), func(i int) bool { return bytes.Compare(n.inodes[i].Key(), key) != -1 })
+	index := sort.Search(len(n.inodes), func(i int) bool { return n.bucket.compare(n.inodes[i].Key(), key) >= 0 })
 
 	// Exit if the key isn't found.
 	if index >= len(n.inodes) || !bytes.Equal(n.inodes[index].Key(), key) {
 		return
 	}
 
+	if n.isLeaf {
+		n.bucket.count(n.inodes[index].Flags(), n.inodes[index].Value(), -1)
+	}
+
 	// Delete inode from the node.
 	n.inodes = append(n.inodes[:index], n.inodes[index+1:]...)
 
@@ -312,16 +323,17 @@ func (n *node) spill() error {
 	n.children = nil
 
 	// Split nodes into appropriate sizes. The first node will always be n.
-	var nodes = n.split(uintptr(tx.db.pageSize))
+	// Leave room for the page trailer.
+	var nodes = n.split(uintptr(tx.db.pageSize - tx.db.trailerSize()))
 	for _, node := range nodes {
 		// Add node's page to the freelist if it's not new.
 		if node.pgid > 0 {
-			tx.db.freelist.free(tx.meta.Txid(), tx.page(node.pgid))
+			tx.free(tx.page(node.pgid))
 			node.pgid = 0
 		}
 
 		// Allocate contiguous space for the node.
-		p, err := tx.allocate((node.size() + tx.db.pageSize - 1) / tx.db.pageSize)
+		p, err := tx.allocate((node.size() + tx.db.trailerSize() + tx.db.pageSize - 1) / tx.db.pageSize)
 		if err != nil {
 			return err
 		}
@@ -493,7 +505,7 @@ func (n *node) dereference() {
 // free adds the node's underlying page to the freelist.
 func (n *node) free() {
 	if n.pgid != 0 {
-		n.bucket.tx.db.freelist.free(n.bucket.tx.meta.Txid(), n.bucket.tx.page(n.pgid))
+		n.bucket.tx.free(n.bucket.tx.page(n.pgid))
 		n.pgid = 0
 	}
 }
@@ -525,14 +537,10 @@ func (n *node) dump() {
 }
 */
 
-func compareKeys(left, right []byte) int {
-	return bytes.Compare(left, right)
-}
-
 type nodes []*node
 
 func (s nodes) Len() int      { return len(s) }
 func (s nodes) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
 func (s nodes) Less(i, j int) bool {
-	return bytes.Compare(s[i].inodes[0].Key(), s[j].inodes[0].Key()) == -1
+	return s[i].bucket.compare(s[i].inodes[0].Key(), s[j].inodes[0].Key()) < 0
 }
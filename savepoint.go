@@ -0,0 +1,185 @@
+package bbolt
+
+import (
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Savepoint marks a point within a read/write transaction that the
+// transaction can be rolled back to without discarding the changes made
+// before it. Savepoints are created with Tx.Savepoint.
+type Savepoint struct {
+	tx             *Tx
+	buckets        []bucketState
+	freed          int
+	handlers       int
+	changes        int
+	changeHandlers int
+	watchLog       int
+	features       uint32
+}
+
+// bucketState holds the state of a cached bucket at a savepoint.
+type bucketState struct {
+	bucket   *Bucket
+	inBucket common.InBucket
+	ext      common.BucketExt
+	page     *common.Page
+	rootNode *node
+	nodes    map[common.Pgid]*node
+	buckets  map[string]*Bucket
+
+	// parent and name record where the bucket was, since MoveBucket can
+	// move it to another parent.
+	parent *Bucket
+	name   []byte
+}
+
+// Savepoint returns a savepoint that marks the current state of the
+// transaction. Changes made after it, including changes to bucket sequences
+// and handlers registered with OnCommit or OnCommitChanges, can be undone with
+// RollbackTo. Savepoints can not be created in a transaction which bulk loads
+// a bucket.
+func (tx *Tx) Savepoint() (*Savepoint, error) {
+	if tx.db == nil {
+		return nil, common.ErrTxClosed
+	} else if !tx.writable {
+		return nil, common.ErrTxNotWritable
+	} else if tx.bulkLoaded {
+		return nil, common.ErrSavepointBulkLoad
+	}
+
+	sp := &Savepoint{
+		tx:             tx,
+		handlers:       len(tx.commitHandlers),
+		changeHandlers: len(tx.changeHandlers),
+		watchLog:       len(tx.watchLog),
+		features:       tx.features,
+	}
+	if tx.changes != nil {
+		sp.changes = len(tx.changes.Changes)
+	}
+	sp.freed = tx.freedCount()
+	sp.save(&tx.root)
+
+	tx.savepoints = append(tx.savepoints, sp)
+	return sp, nil
+}
+
+// RollbackTo undoes all changes made to the transaction after the savepoint
+// was created. The transaction stays open, and the savepoint can be rolled
+// back to again. Savepoints created after sp are discarded.
+//
+// Buckets created or first opened after the savepoint, as well as cursors
+// created before RollbackTo is called, must not be used afterwards.
+func (tx *Tx) RollbackTo(sp *Savepoint) error {
+	if tx.db == nil {
+		return common.ErrTxClosed
+	} else if !tx.writable {
+		return common.ErrTxNotWritable
+	}
+
+	// Find the savepoint and discard the ones created after it.
+	i := len(tx.savepoints) - 1
+	for i >= 0 && tx.savepoints[i] != sp {
+		i--
+	}
+	if sp == nil || sp.tx != tx || i < 0 {
+		return common.ErrInvalidSavepoint
+	}
+	for _, later := range tx.savepoints[i+1:] {
+		later.tx = nil
+	}
+	tx.savepoints = tx.savepoints[:i+1]
+
+	// Restore the freelist, the buckets, the recorded changes, the commit
+	// handlers, the changes recorded for the watchers and the features used
+	// by the transaction.
+	tx.unfree(sp.freed)
+	for _, s := range sp.buckets {
+		s.restore()
+	}
+	if tx.changes != nil {
+		tx.changes.Changes = tx.changes.Changes[:sp.changes]
+	}
+	tx.commitHandlers = tx.commitHandlers[:sp.handlers]
+	tx.changeHandlers = tx.changeHandlers[:sp.changeHandlers]
+	tx.watchLog = tx.watchLog[:sp.watchLog]
+	tx.features = sp.features
+
+	return nil
+}
+
+// save records the state of a bucket and its cached child buckets.
+func (sp *Savepoint) save(b *Bucket) {
+	s := bucketState{
+		bucket:   b,
+		inBucket: *b.InBucket,
+		ext:      b.ext,
+		page:     b.page,
+		buckets:  make(map[string]*Bucket, len(b.buckets)),
+		parent:   b.parent,
+		name:     b.name,
+	}
+	s.rootNode, s.nodes = cloneNodes(b.rootNode, b.nodes)
+	for name, child := range b.buckets {
+		s.buckets[name] = child
+	}
+	sp.buckets = append(sp.buckets, s)
+
+	for _, child := range b.buckets {
+		sp.save(child)
+	}
+}
+
+// restore resets a bucket to its recorded state. The bucket receives a copy
+// of the recorded nodes so that the state can be restored again.
+func (s *bucketState) restore() {
+	b := s.bucket
+	*b.InBucket = s.inBucket
+	b.ext = s.ext
+	b.page = s.page
+	b.parent, b.name = s.parent, s.name
+	b.rootNode, b.nodes = cloneNodes(s.rootNode, s.nodes)
+	b.buckets = make(map[string]*Bucket, len(s.buckets))
+	for name, child := range s.buckets {
+		b.buckets[name] = child
+	}
+}
+
+// cloneNodes returns a deep copy of a tree of nodes and of the node cache
+// that refers to them.
+func cloneNodes(root *node, cache map[common.Pgid]*node) (*node, map[common.Pgid]*node) {
+	clones := make(map[*node]*node)
+	var clone func(n, parent *node) *node
+	clone = func(n, parent *node) *node {
+		c := &node{
+			bucket:     n.bucket,
+			isLeaf:     n.isLeaf,
+			unbalanced: n.unbalanced,
+			spilled:    n.spilled,
+			key:        n.key,
+			pgid:       n.pgid,
+			parent:     parent,
+			inodes:     append(common.Inodes(nil), n.inodes...),
+		}
+		clones[n] = c
+		for _, child := range n.children {
+			c.children = append(c.children, clone(child, c))
+		}
+		return c
+	}
+
+	var r *node
+	if root != nil {
+		r = clone(root, nil)
+	}
+	nodes := make(map[common.Pgid]*node, len(cache))
+	for id, n := range cache {
+		c, ok := clones[n]
+		if !ok {
+			c = clone(n, nil)
+		}
+		nodes[id] = c
+	}
+	return r, nodes
+}
@@ -0,0 +1,231 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that rolling back to a savepoint undoes only the later changes.
+func TestTx_RollbackTo(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	var committed bool
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("before")))
+		}
+		_, err = b.NextSequence()
+		require.NoError(t, err)
+		tx.OnCommit(func() { committed = true })
+
+		sp, err := tx.Savepoint()
+		require.NoError(t, err)
+
+		for i := 0; i < 1000; i += 2 {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("after")))
+			require.NoError(t, b.Delete([]byte(fmt.Sprintf("%04d", i+1))))
+		}
+		require.NoError(t, b.SetSequence(100))
+		_, err = tx.CreateBucket([]byte("gadgets"))
+		require.NoError(t, err)
+		tx.OnCommit(func() { t.Error("unexpected commit handler") })
+
+		require.NoError(t, tx.RollbackTo(sp))
+
+		require.Nil(t, tx.Bucket([]byte("gadgets")))
+		require.Equal(t, uint64(1), b.Sequence())
+		require.Equal(t, []byte("before"), b.Get([]byte("0000")))
+		require.Equal(t, []byte("before"), b.Get([]byte("0001")))
+
+		// The transaction keeps working after the rollback.
+		return b.Put([]byte("0000"), []byte("final"))
+	}))
+	require.True(t, committed)
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 1000, b.Stats().KeyN)
+		require.Equal(t, uint64(1), b.Sequence())
+		require.Equal(t, []byte("final"), b.Get([]byte("0000")))
+		require.Equal(t, []byte("before"), b.Get([]byte("0999")))
+		require.Nil(t, tx.Bucket([]byte("gadgets")))
+		return nil
+	}))
+}
+
+// Ensure that deleting a bucket can be rolled back without leaking its pages.
+func TestTx_RollbackTo_DeleteBucket(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 5000,
+		func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d", key)) },
+		func(tx int, key int) []byte { return make([]byte, 100) },
+	))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.Bucket([]byte("widgets")).CreateBucket([]byte("nested"))
+		return err
+	}))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.Bucket([]byte("widgets")).Put([]byte("0000"), []byte("changed")))
+
+		sp, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, tx.DeleteBucket([]byte("widgets")))
+		require.NoError(t, tx.RollbackTo(sp))
+
+		// A savepoint can be rolled back to more than once.
+		require.NoError(t, tx.DeleteBucket([]byte("widgets")))
+		require.NoError(t, tx.RollbackTo(sp))
+
+		b := tx.Bucket([]byte("widgets"))
+		require.NotNil(t, b)
+		require.NotNil(t, b.Bucket([]byte("nested")))
+		require.Equal(t, []byte("changed"), b.Get([]byte("0000")))
+		return nil
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 5001, b.Stats().KeyN)
+		require.Equal(t, []byte("changed"), b.Get([]byte("0000")))
+		return nil
+	}))
+}
+
+// Ensure that a range delete can be rolled back without leaking its pages.
+func TestTx_RollbackTo_DeleteRange(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 5000,
+		func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d", key)) },
+		func(tx int, key int) []byte { return make([]byte, 100) },
+	))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.NoError(t, b.Put([]byte("2500"), []byte("changed")))
+
+		sp, err := tx.Savepoint()
+		require.NoError(t, err)
+		keys, _, err := b.DeleteRange([]byte("1000"), []byte("4000"))
+		require.NoError(t, err)
+		require.Equal(t, 3000, keys)
+		require.NoError(t, tx.RollbackTo(sp))
+
+		require.Equal(t, []byte("changed"), b.Get([]byte("2500")))
+		keys, _, err = b.DeleteRange([]byte("0000"), []byte("1000"))
+		require.NoError(t, err)
+		require.Equal(t, 1000, keys)
+		return nil
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 4000, b.Stats().KeyN)
+		require.Equal(t, []byte("changed"), b.Get([]byte("2500")))
+		return nil
+	}))
+}
+
+// Ensure that moving a bucket can be rolled back.
+func TestTx_RollbackTo_MoveBucket(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Fill([]byte("widgets"), 1, 1000,
+		func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d", key)) },
+		func(tx int, key int) []byte { return make([]byte, 100) },
+	))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("gadgets"))
+		require.NoError(t, err)
+
+		sp, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, tx.MoveBucket([][]byte{[]byte("widgets")}, [][]byte{[]byte("gadgets")}, []byte("moved")))
+		require.Nil(t, tx.Bucket([]byte("widgets")))
+		require.NoError(t, tx.RollbackTo(sp))
+
+		require.Nil(t, tx.Bucket([]byte("gadgets")).Bucket([]byte("moved")))
+		return tx.Bucket([]byte("widgets")).Put([]byte("0000"), []byte("changed"))
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 1000, b.Stats().KeyN)
+		require.Equal(t, []byte("changed"), b.Get([]byte("0000")))
+		return nil
+	}))
+}
+
+// Ensure that rolling back the writes which use features of format version 4
+// leaves the format version of the database unraised.
+func TestTx_RollbackTo_FormatVersion(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+
+		sp, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, b.PutWithTTL([]byte("a"), []byte("short"), time.Hour))
+		_, err = tx.CreateBucketWithOptions([]byte("counted"), &bolt.BucketOptions{Counted: true})
+		require.NoError(t, err)
+		require.NoError(t, tx.RollbackTo(sp))
+
+		return b.Put([]byte("b"), []byte("plain"))
+	}))
+	for _, m := range readMetas(t, db) {
+		require.Equal(t, uint32(common.Version), m.Version())
+		require.Zero(t, m.Flags()&(common.MetaExpiryFlag|common.MetaBucketExtFlag))
+	}
+	db.MustCheck()
+}
+
+// Ensure that only valid savepoints can be rolled back to.
+func TestTx_RollbackTo_Invalid(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		_, err := tx.Savepoint()
+		require.ErrorIs(t, err, common.ErrTxNotWritable)
+		return nil
+	}))
+
+	var other *bolt.Savepoint
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		var err error
+		other, err = tx.Savepoint()
+		return err
+	}))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		require.ErrorIs(t, tx.RollbackTo(other), common.ErrInvalidSavepoint)
+		require.ErrorIs(t, tx.RollbackTo(nil), common.ErrInvalidSavepoint)
+
+		sp1, err := tx.Savepoint()
+		require.NoError(t, err)
+		_, err = tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		sp2, err := tx.Savepoint()
+		require.NoError(t, err)
+
+		// Rolling back to a savepoint discards the savepoints after it.
+		require.NoError(t, tx.RollbackTo(sp1))
+		require.ErrorIs(t, tx.RollbackTo(sp2), common.ErrInvalidSavepoint)
+		require.Nil(t, tx.Bucket([]byte("widgets")))
+		return nil
+	}))
+}
@@ -0,0 +1,521 @@
+package bbolt
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// txScope is the state of a write transaction begun with DB.BeginBuckets.
+//
+// Until it commits, a scoped transaction works on a snapshot of the database
+// like a read transaction, without the writer lock: the pages it frees are
+// kept aside until it commits. To commit, it rebalances and spills its
+// buckets onto pages it claims from the freelist while briefly holding the
+// writer lock, and writes them to disk. It then takes the writer lock, moves
+// onto the latest meta page and root bucket, and writes the headers of its
+// buckets into the root bucket, which is the only page it shares with the
+// other transactions, along with the freelist and the meta page.
+type txScope struct {
+	// names are the declared buckets, and the buckets holding the indexes of
+	// the declared buckets.
+	names map[string]struct{}
+
+	// freed are the headers of the pages freed before the commit, which are
+	// released to the freelist once the transaction holds the writer lock.
+	freed []common.Page
+
+	// snapshot is the mapping the transaction began with. The nodes read
+	// before the commit point into it, so it's held until the transaction
+	// closes.
+	snapshot *mapping
+
+	// txid is the id given to the transaction when its commit began, which
+	// the pages written before it holds the writer lock store. The commits
+	// are applied in the order of these ids, so the id the transaction
+	// commits with is at most txid.
+	txid common.Txid
+
+	// claimed are the pages allocated before the commit, which are returned
+	// to the freelist unless the transaction commits.
+	claimed []common.Pgid
+
+	// committing is set once the transaction holds the writer lock.
+	committing bool
+}
+
+// has returns whether the top-level bucket with the given name is declared.
+func (s *txScope) has(name []byte) bool {
+	_, ok := s.names[string(name)]
+	return ok
+}
+
+// BeginBuckets starts a write transaction which only writes to the top-level
+// buckets with the given names, and to the buckets nested in them.
+//
+// Transactions begun with BeginBuckets whose names are disjoint run at the
+// same time: each one waits only for the transactions which declared one of
+// its names, and makes its changes without holding the writer lock. Its
+// commit holds the writer lock only to allocate pages, and to write the root
+// bucket, the freelist and the meta page: its buckets are rebalanced, spilled,
+// written and synced to disk without it, so that the commits of transactions
+// with disjoint names overlap. They are applied in the order they began. Other
+// write transactions wait for all of them, and they wait for other write
+// transactions.
+//
+// The transaction sees the database as it was when the transaction began, and
+// can read every bucket. Until it commits, it keeps the pages of that snapshot
+// from being reused and is listed by OpenTxs, like a read transaction. Writing
+// to a top-level bucket which is not declared, or creating, deleting or moving
+// one, returns ErrBucketNotDeclared. Keys can not be put with a TTL, nor
+// buckets holding keys with a TTL moved, and buckets can not be bulk loaded.
+// The indexes of the declared buckets are updated as usual. ID returns the id
+// the transaction will commit with only once it commits.
+func (db *DB) BeginBuckets(names [][]byte) (*Tx, error) {
+	return db.BeginBucketsContext(context.Background(), names)
+}
+
+// BeginBucketsContext is like BeginBuckets, but it stops waiting for the
+// buckets and locks needed to start the transaction and returns the context's
+// error when ctx is done.
+func (db *DB) BeginBucketsContext(ctx context.Context, names [][]byte) (*Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if db.readOnly {
+		return nil, common.ErrDatabaseReadOnly
+	}
+
+	scope := &txScope{names: make(map[string]struct{}, len(names))}
+	for _, name := range names {
+		if len(name) == 0 {
+			return nil, common.ErrBucketNameRequired
+		} else if db.isReservedName(name) {
+			return nil, common.ErrBucketNameReserved
+		}
+		scope.names[string(name)] = struct{}{}
+	}
+
+	// Wait for the other write transactions, which take the scope lock for
+	// writing, and then for the scoped transactions sharing a name.
+	if err := lockContext(ctx, db.scopelock.TryRLock, db.scopelock.RLock); err != nil {
+		return nil, err
+	}
+	for _, def := range db.indexes {
+		if scope.has(def.path[0]) {
+			scope.names[string(def.bucket)] = struct{}{}
+		}
+	}
+	if err := db.lockBuckets(ctx, scope.names); err != nil {
+		db.scopelock.RUnlock()
+		return nil, err
+	}
+
+	// Begin on a snapshot, like a read transaction.
+	t, err := db.beginTx(ctx)
+	if err != nil {
+		db.unlockBuckets(scope.names)
+		db.scopelock.RUnlock()
+		return nil, err
+	}
+	t.writable = true
+	t.scope = scope
+	t.pages = make(map[common.Pgid]*common.Page)
+	t.root = newBucket(t)
+	t.root.InBucket = &common.InBucket{}
+	*t.root.InBucket = *(t.meta.RootBucket())
+	if db.TrackChanges {
+		t.changes = &ChangeSet{}
+	}
+	t.watching = db.watched()
+	return t, nil
+}
+
+// UpdateBuckets executes a function within the context of a write transaction
+// begun with BeginBuckets, which is managed like the transactions of Update.
+func (db *DB) UpdateBuckets(names [][]byte, fn func(*Tx) error) error {
+	return db.UpdateBucketsContext(context.Background(), names, fn)
+}
+
+// UpdateBucketsContext is like UpdateBuckets, but the transaction is started
+// with BeginBucketsContext. The transaction is rolled back and the context's
+// error is returned if ctx is done before it is committed.
+func (db *DB) UpdateBucketsContext(ctx context.Context, names [][]byte, fn func(*Tx) error) (err error) {
+	t, err := db.BeginBucketsContext(ctx, names)
+	if err != nil {
+		return err
+	}
+	return t.update(ctx, fn)
+}
+
+// lockBuckets waits until none of the names is declared by an open scoped
+// transaction, and declares them.
+func (db *DB) lockBuckets(ctx context.Context, names map[string]struct{}) error {
+	db.scopeMu.Lock()
+	defer db.scopeMu.Unlock()
+	for {
+		free := true
+		for name := range names {
+			if _, ok := db.scoped[name]; ok {
+				free = false
+				break
+			}
+		}
+		if free {
+			break
+		}
+
+		// Wait for a scoped transaction to close.
+		released := db.scopeFreed
+		db.scopeMu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			db.scopeMu.Lock()
+			return ctx.Err()
+		}
+		db.scopeMu.Lock()
+	}
+
+	if db.scoped == nil {
+		db.scoped = make(map[string]struct{})
+		db.scopeFreed = make(chan struct{})
+	}
+	for name := range names {
+		db.scoped[name] = struct{}{}
+	}
+	return nil
+}
+
+// unlockBuckets releases the names declared by a scoped transaction, and wakes
+// up the transactions waiting for them.
+func (db *DB) unlockBuckets(names map[string]struct{}) {
+	db.scopeMu.Lock()
+	defer db.scopeMu.Unlock()
+	for name := range names {
+		delete(db.scoped, name)
+	}
+	close(db.scopeFreed)
+	db.scopeFreed = make(chan struct{})
+}
+
+// declared returns whether the transaction can write to the bucket, which is
+// nested in a declared top-level bucket if the transaction is scoped. The root
+// bucket can not be written by a scoped transaction.
+func (b *Bucket) declared() bool {
+	scope := b.tx.scope
+	if scope == nil {
+		return true
+	}
+	for b.parent != nil && b.parent != &b.tx.root {
+		b = b.parent
+	}
+	return b.parent != nil && scope.has(b.name)
+}
+
+// childWritable returns whether the bucket with the given name can be created
+// in the bucket, or deleted or moved from it.
+func (b *Bucket) childWritable(name []byte) bool {
+	if b == &b.tx.root && b.tx.scope != nil {
+		return b.tx.scope.has(name)
+	}
+	return b.Writable()
+}
+
+// notWritable returns the error of writing to a bucket which is not writable.
+func (b *Bucket) notWritable() error {
+	if b.tx.writable {
+		return common.ErrBucketNotDeclared
+	}
+	return common.ErrTxNotWritable
+}
+
+// free releases the page p, which the transaction no longer uses, to the
+// freelist. A scoped transaction keeps it aside until it commits.
+func (tx *Tx) free(p *common.Page) {
+	if tx.scope != nil && !tx.scope.committing {
+		tx.scope.freed = append(tx.scope.freed, *p)
+		return
+	}
+	tx.db.freelist.free(tx.meta.Txid(), p)
+}
+
+// freedCount returns the number of pages freed by the transaction, for
+// savepoints.
+func (tx *Tx) freedCount() int {
+	if tx.scope != nil {
+		return len(tx.scope.freed)
+	}
+	if txp := tx.db.freelist.pending[tx.meta.Txid()]; txp != nil {
+		return len(txp.ids)
+	}
+	return 0
+}
+
+// unfree undoes the frees of the transaction after the first n, for
+// savepoints.
+func (tx *Tx) unfree(n int) {
+	if tx.scope != nil {
+		tx.scope.freed = tx.scope.freed[:n]
+		return
+	}
+	tx.db.freelist.rollbackTo(tx.meta.Txid(), n)
+}
+
+// rebase moves a scoped transaction onto the latest committed state of the
+// database once it holds the writer lock, so that it commits like any other
+// write transaction. The headers of the top-level buckets it declared are
+// written into the latest root bucket, which the other transactions did not
+// change them in.
+func (tx *Tx) rebase() {
+	db, scope := tx.db, tx.scope
+
+	// Wait for the scoped transactions which began to commit before.
+	for db.scopeTxids[0] != scope.txid {
+		db.scopeTurn.Wait()
+	}
+
+	// Take the entries of the declared buckets from the root bucket of the
+	// snapshot, which writeScope updated, before it's replaced.
+	type entry struct {
+		name   []byte
+		value  []byte
+		flags  uint32
+		exists bool
+	}
+	entries := make([]entry, 0, len(scope.names))
+	for name := range scope.names {
+		e := entry{name: []byte(name)}
+		k, v, flags := tx.root.Cursor().seek(e.name)
+		if string(k) == name && flags&common.BucketLeafFlag != 0 {
+			e.value, e.flags, e.exists = cloneBytes(v), flags, true
+		}
+		entries = append(entries, e)
+	}
+
+	// Leave the open transactions, keeping the mapping of the snapshot, and
+	// take over the latest meta page like a write transaction.
+	db.metalock.Lock()
+	for i, t := range db.txs {
+		if t == tx {
+			last := len(db.txs) - 1
+			db.txs[i] = db.txs[last]
+			db.txs[last] = nil
+			db.txs = db.txs[:last]
+			break
+		}
+	}
+	scope.snapshot, tx.mapping = tx.mapping, nil
+	scope.committing = true
+	tx.decrypted = nil
+	tx.meta = &common.Meta{}
+	db.meta().Copy(tx.meta)
+	tx.meta.IncTxid()
+	db.raiseHighWaterMark(tx.meta)
+	db.rwtx = tx
+	db.freePages()
+	db.reportLongReadTxs()
+	n := len(db.txs)
+	db.metalock.Unlock()
+
+	db.statlock.Lock()
+	db.stats.OpenTxN = n
+	db.statlock.Unlock()
+
+	if tx.changes != nil {
+		tx.changes.Txid = int(tx.meta.Txid())
+	}
+	for i := range scope.freed {
+		db.freelist.free(tx.meta.Txid(), &scope.freed[i])
+	}
+	scope.freed = nil
+	db.freelist.settle(scope.claimed, tx.meta.Txid())
+
+	// Write the headers of the declared buckets into the latest root bucket.
+	tx.root = newBucket(tx)
+	tx.root.InBucket = &common.InBucket{}
+	*tx.root.InBucket = *(tx.meta.RootBucket())
+	for _, e := range entries {
+		c := tx.root.Cursor()
+		k, _, _ := c.seek(e.name)
+		if e.exists {
+			c.node().put(e.name, e.name, e.value, 0, e.flags)
+		} else if string(k) == string(e.name) {
+			c.node().del(e.name)
+		}
+	}
+}
+
+// rebalanceScope rebalances the declared buckets of a scoped transaction
+// before it takes the writer lock to commit. The root bucket of the snapshot
+// is replaced by rebase, and rebalanced by the commit.
+func (tx *Tx) rebalanceScope() {
+	startTime := time.Now()
+	for name, child := range tx.root.buckets {
+		if tx.scope.has([]byte(name)) {
+			child.rebalance()
+		}
+	}
+	if tx.stats.GetRebalance() > 0 {
+		tx.stats.IncRebalanceTime(time.Since(startTime))
+	}
+}
+
+// writeScope spills the declared buckets of a scoped transaction onto pages
+// claimed from the freelist, and writes them to disk, before the transaction
+// takes the writer lock to commit. The headers of the buckets are written into
+// the root bucket of the snapshot, which rebase takes them from. The pages
+// are returned to the freelist if it fails.
+func (tx *Tx) writeScope() (err error) {
+	db, scope := tx.db, tx.scope
+
+	db.rwlock.Lock()
+	if txid := db.meta().Txid(); txid > db.scopeTxid {
+		db.scopeTxid = txid
+	}
+	db.scopeTxid++
+	scope.txid = db.scopeTxid
+	db.scopeTxids = append(db.scopeTxids, scope.txid)
+	if db.scopeTurn == nil {
+		db.scopeTurn = sync.NewCond(&db.rwlock)
+	}
+	db.rwlock.Unlock()
+	defer func() {
+		if err != nil {
+			db.rwlock.Lock()
+			db.freelist.unclaim(scope.claimed)
+			db.endScopeTurn(scope.txid)
+			db.rwlock.Unlock()
+		}
+	}()
+
+	startTime := time.Now()
+	for name, child := range tx.root.buckets {
+		if scope.has([]byte(name)) {
+			if err := tx.root.spillChild(name, child); err != nil {
+				return err
+			}
+		}
+	}
+	tx.stats.IncSpillTime(time.Since(startTime))
+
+	// Write the pages and sync the file, like the commit does with the rest.
+	writeStart := time.Now()
+	pages := make(common.Pages, 0, len(tx.pages))
+	for _, p := range tx.pages {
+		pages = append(pages, p)
+	}
+	tx.pages = make(map[common.Pgid]*common.Page)
+	sort.Sort(pages)
+	if err := tx.writePages(pages); err != nil {
+		return err
+	}
+	db.writes.record(scope.txid, pages)
+	if !db.NoSync || common.IgnoreNoSync {
+		if err := db.storage.Sync(); err != nil {
+			return err
+		}
+	}
+	for _, p := range pages {
+		db.releasePageBuffer(p)
+	}
+	tx.stats.IncWriteTime(time.Since(writeStart))
+	return nil
+}
+
+// claim allocates count pages for a scoped transaction before it commits. The
+// writer lock is held only while the pages are taken from the freelist, or
+// from the end of the file.
+func (tx *Tx) claim(count int) (*common.Page, error) {
+	db, scope := tx.db, tx.scope
+	p := db.pageBuffer(count)
+
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+	p.SetId(db.freelist.allocate(scope.txid, count))
+	if p.Id() == 0 {
+		// Take the pages at the end of the file, above those claimed by the
+		// other scoped transactions.
+		m := &common.Meta{}
+		db.meta().Copy(m)
+		db.raiseHighWaterMark(m)
+		p.SetId(m.Pgid())
+		hwm := p.Id() + common.Pgid(count)
+		if minsz := int(hwm+1) * db.pageSize; minsz >= db.mapping.size {
+			if err := db.mmap(minsz); err != nil {
+				return nil, fmt.Errorf("mmap allocate error: %s", err)
+			}
+		}
+		if err := db.grow(int(hwm+1) * db.pageSize); err != nil {
+			return nil, err
+		}
+		db.scopePgid = hwm
+	}
+	db.freelist.claim(p.Id(), count)
+	for i := 0; i < count; i++ {
+		scope.claimed = append(scope.claimed, p.Id()+common.Pgid(i))
+	}
+
+	// The pages can be above the high water mark of the snapshot.
+	if hwm := p.Id() + common.Pgid(count); hwm > tx.meta.Pgid() {
+		tx.meta.SetPgid(hwm)
+	}
+	return p, nil
+}
+
+// raiseHighWaterMark raises the high water mark of the meta of a write
+// transaction above the pages claimed by scoped transactions, which can be
+// above the high water mark of the latest meta page until the next commit. It
+// must be called while holding the writer lock.
+func (db *DB) raiseHighWaterMark(m *common.Meta) {
+	if db.scopePgid > m.Pgid() {
+		m.SetPgid(db.scopePgid)
+	}
+}
+
+// endScopeTurn removes the id of a scoped transaction which committed or
+// failed to from the commit order, and wakes up the transactions waiting for
+// their turn. It must be called while holding the writer lock.
+func (db *DB) endScopeTurn(txid common.Txid) {
+	for i, id := range db.scopeTxids {
+		if id == txid {
+			db.scopeTxids = append(db.scopeTxids[:i], db.scopeTxids[i+1:]...)
+			break
+		}
+	}
+	db.scopeTurn.Broadcast()
+}
+
+// pageTxid returns the transaction id stored in the pages the transaction
+// writes, which is the one a scoped transaction was given when its commit
+// began until it holds the writer lock.
+func (tx *Tx) pageTxid() common.Txid {
+	if tx.scope != nil && !tx.scope.committing {
+		return tx.scope.txid
+	}
+	return tx.meta.Txid()
+}
+
+// ownsFreelist returns whether the transaction holds the writer lock, which
+// scoped transactions only take to commit.
+func (tx *Tx) ownsFreelist() bool {
+	return tx.writable && (tx.scope == nil || tx.scope.committing)
+}
+
+// closeScope releases the buckets, locks and mapping held by a scoped
+// transaction when it closes.
+func (tx *Tx) closeScope() {
+	db, scope := tx.db, tx.scope
+	if scope.committing {
+		_ = db.release(scope.snapshot)
+		db.mmaplock.RUnlock()
+	} else {
+		db.removeTx(tx)
+	}
+	db.unlockBuckets(scope.names)
+	db.scopelock.RUnlock()
+}
@@ -0,0 +1,280 @@
+package bbolt_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that transactions declaring disjoint buckets are open at the same
+// time, and that each one sees the changes committed by the other.
+func TestDB_UpdateBuckets(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"a", "b", "c"} {
+			b, err := tx.CreateBucket([]byte(name))
+			require.NoError(t, err)
+			for i := 0; i < 1000; i++ {
+				require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)))
+			}
+		}
+		return nil
+	}))
+
+	// Each transaction waits until the other one is open before it commits.
+	var wg sync.WaitGroup
+	open := make(chan struct{}, 2)
+	for _, name := range []string{"a", "b"} {
+		name := []byte(name)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, db.UpdateBuckets([][]byte{name}, func(tx *bolt.Tx) error {
+				open <- struct{}{}
+				for len(open) < 2 {
+					time.Sleep(time.Millisecond)
+				}
+
+				b := tx.Bucket(name)
+				for i := 0; i < 1000; i += 2 {
+					require.NoError(t, b.Delete([]byte(fmt.Sprintf("%04d", i))))
+				}
+				child, err := b.CreateBucket([]byte("child"))
+				require.NoError(t, err)
+				for i := 0; i < 500; i++ {
+					require.NoError(t, child.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)))
+				}
+				return nil
+			}))
+		}()
+	}
+	wg.Wait()
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		for _, name := range []string{"a", "b"} {
+			b := tx.Bucket([]byte(name))
+			require.Equal(t, 1001, b.Stats().KeyN)
+			require.Nil(t, b.Get([]byte("0000")))
+			require.NotNil(t, b.Get([]byte("0001")))
+			require.Equal(t, 500, b.Bucket([]byte("child")).Stats().KeyN)
+		}
+		require.Equal(t, 1000, tx.Bucket([]byte("c")).Stats().KeyN)
+		return nil
+	}))
+}
+
+// Ensure that a scoped transaction waits for the transactions sharing one of
+// its buckets, and that other write transactions wait for it.
+func TestDB_BeginBuckets_Wait(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("a"))
+		return err
+	}))
+
+	tx, err := db.BeginBuckets([][]byte{[]byte("a"), []byte("b")})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = db.BeginBucketsContext(ctx, [][]byte{[]byte("b")})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = db.BeginContext(ctx, true)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The database stays readable, and other buckets writable.
+	require.NoError(t, db.View(func(tx *bolt.Tx) error { return nil }))
+	require.NoError(t, db.UpdateBuckets([][]byte{[]byte("c")}, func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("c"))
+		return err
+	}))
+
+	_, err = tx.CreateBucket([]byte("b"))
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit())
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		require.NotNil(t, tx.Bucket([]byte("a")))
+		require.NotNil(t, tx.Bucket([]byte("b")))
+		require.NotNil(t, tx.Bucket([]byte("c")))
+		return nil
+	}))
+	db.MustCheck()
+}
+
+// Ensure that a scoped transaction can read every bucket, but only write to
+// the buckets it declared.
+func TestDB_UpdateBuckets_NotDeclared(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"a", "b"} {
+			b, err := tx.CreateBucket([]byte(name))
+			require.NoError(t, err)
+			require.NoError(t, b.Put([]byte("key"), []byte("value")))
+			_, err = b.CreateBucket([]byte("child"))
+			require.NoError(t, err)
+		}
+		return nil
+	}))
+
+	require.NoError(t, db.UpdateBuckets([][]byte{[]byte("a")}, func(tx *bolt.Tx) error {
+		a, b := tx.Bucket([]byte("a")), tx.Bucket([]byte("b"))
+		require.True(t, a.Writable())
+		require.True(t, a.Bucket([]byte("child")).Writable())
+		require.False(t, b.Writable())
+		require.False(t, b.Bucket([]byte("child")).Writable())
+		require.Equal(t, []byte("value"), b.Get([]byte("key")))
+
+		require.Equal(t, common.ErrBucketNotDeclared, b.Put([]byte("key"), []byte("other")))
+		require.Equal(t, common.ErrBucketNotDeclared, b.Delete([]byte("key")))
+		require.Equal(t, common.ErrBucketNotDeclared, b.Bucket([]byte("child")).Put([]byte("key"), nil))
+		c := b.Cursor()
+		c.First()
+		require.Equal(t, common.ErrBucketNotDeclared, c.Delete())
+		_, err := tx.CreateBucket([]byte("c"))
+		require.Equal(t, common.ErrBucketNotDeclared, err)
+		require.Equal(t, common.ErrBucketNotDeclared, tx.DeleteBucket([]byte("b")))
+		require.Equal(t, common.ErrBucketNotDeclared, tx.MoveBucket([][]byte{[]byte("a"), []byte("child")}, [][]byte{[]byte("b")}, []byte("moved")))
+
+		require.Equal(t, common.ErrBucketNotDeclared, a.PutWithTTL([]byte("key"), nil, time.Hour))
+		_, err = a.BulkLoad([]byte("loaded"))
+		require.Equal(t, common.ErrScopedBulkLoad, err)
+
+		return a.Put([]byte("key"), []byte("other"))
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("other"), tx.Bucket([]byte("a")).Get([]byte("key")))
+		require.Equal(t, []byte("value"), tx.Bucket([]byte("b")).Get([]byte("key")))
+		return nil
+	}))
+}
+
+// Ensure that a scoped transaction can delete, recreate and move its buckets
+// after other transactions committed, and roll back to savepoints.
+func TestDB_BeginBuckets_Rebase(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"a", "b"} {
+			b, err := tx.CreateBucket([]byte(name))
+			require.NoError(t, err)
+			for i := 0; i < 1000; i++ {
+				require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)))
+			}
+		}
+		return nil
+	}))
+
+	tx, err := db.BeginBuckets([][]byte{[]byte("a"), []byte("moved")})
+	require.NoError(t, err)
+	sp, err := tx.Savepoint()
+	require.NoError(t, err)
+	require.NoError(t, tx.DeleteBucket([]byte("a")))
+	require.NoError(t, tx.RollbackTo(sp))
+	require.NoError(t, tx.MoveBucket([][]byte{[]byte("a")}, nil, []byte("moved")))
+	a, err := tx.CreateBucket([]byte("a"))
+	require.NoError(t, err)
+	require.NoError(t, a.Put([]byte("new"), []byte("value")))
+
+	// Other transactions grow the database and free pages meanwhile.
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.UpdateBuckets([][]byte{[]byte("b"), []byte("c")}, func(tx *bolt.Tx) error {
+			require.NoError(t, tx.DeleteBucket([]byte("b")))
+			b, err := tx.CreateBucket([]byte("b"))
+			require.NoError(t, err)
+			for i := 0; i < 1000; i++ {
+				require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 200)))
+			}
+			_, err = tx.CreateBucketIfNotExists([]byte("c"))
+			return err
+		}))
+	}
+	require.NoError(t, tx.Commit())
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 1, tx.Bucket([]byte("a")).Stats().KeyN)
+		require.Equal(t, 1000, tx.Bucket([]byte("moved")).Stats().KeyN)
+		require.Equal(t, 1000, tx.Bucket([]byte("b")).Stats().KeyN)
+		require.NotNil(t, tx.Bucket([]byte("c")))
+		return nil
+	}))
+
+	// A rolled back transaction leaves no trace.
+	tx, err = db.BeginBuckets([][]byte{[]byte("a")})
+	require.NoError(t, err)
+	require.NoError(t, tx.DeleteBucket([]byte("a")))
+	require.NoError(t, tx.Rollback())
+	db.MustCheck()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.NotNil(t, tx.Bucket([]byte("a")))
+		return nil
+	}))
+}
+
+// Ensure that a scoped transaction rebalances its buckets before it takes the
+// writer lock, while other transactions commit.
+func TestDB_BeginBuckets_Rebalance(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("a"))
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)))
+		}
+		return nil
+	}))
+
+	tx, err := db.BeginBuckets([][]byte{[]byte("a")})
+	require.NoError(t, err)
+	b := tx.Bucket([]byte("a"))
+	for i := 0; i < 1000; i++ {
+		if i%100 != 0 {
+			require.NoError(t, b.Delete([]byte(fmt.Sprintf("%04d", i))))
+		}
+	}
+	require.NoError(t, db.UpdateBuckets([][]byte{[]byte("b")}, func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("b"))
+		return err
+	}))
+	require.NoError(t, tx.Commit())
+	stats := tx.Stats()
+	require.Positive(t, stats.GetRebalance())
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		stats := tx.Bucket([]byte("a")).Stats()
+		require.Equal(t, 10, stats.KeyN)
+		require.LessOrEqual(t, stats.LeafPageN, 2)
+		require.NotNil(t, tx.Bucket([]byte("b")))
+		return nil
+	}))
+}
+
+// Ensure that a scoped transaction updates the indexes of its buckets.
+func TestDB_UpdateBuckets_Index(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	require.NoError(t, db.DeclareIndex("tags", [][]byte{[]byte("widgets")}, tags))
+
+	require.NoError(t, db.UpdateBuckets([][]byte{[]byte("widgets")}, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("a"), []byte("red,round")))
+		return b.Put([]byte("b"), []byte("blue"))
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []string{"blue=b", "red=a", "round=a"}, scan(t, tx.Index("tags"), nil, nil))
+		return nil
+	}))
+}
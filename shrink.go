@@ -0,0 +1,155 @@
+package bbolt
+
+import (
+	"fmt"
+	"runtime"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Shrink gives the free space at the end of the database file back to the
+// file system without copying the database to a second file.
+//
+// Live pages near the end of the file are moved into free pages closer to
+// the start using ordinary write transactions, and the file is truncated once
+// its tail is entirely free. Shrink blocks other writers only for the duration
+// of each of those transactions, so it can run while the database is in use.
+//
+// Pages that are still in use by open read transactions can not be moved or
+// reclaimed. Shrink stops once it makes no more progress, so long running read
+// transactions may leave the file larger than the data it holds.
+func (db *DB) Shrink() error {
+	var stalled int
+	for stalled < 2 {
+		var trimmed, moved int
+		if err := db.Update(func(tx *Tx) (err error) {
+			trimmed, moved, err = tx.shrink()
+			return err
+		}); err != nil {
+			return err
+		}
+
+		// Pages moved by a round are reclaimed by the next one, unless a
+		// read transaction still holds them.
+		if trimmed > 0 {
+			stalled = 0
+		} else if moved == 0 {
+			break
+		} else {
+			stalled++
+		}
+	}
+
+	// Truncate the file while holding the writer lock so it can not grow.
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	return db.truncate(int(tx.meta.Pgid()) * db.pageSize)
+}
+
+// shrink trims the free pages at the end of the file and moves live pages out
+// of the free space that remains at the end of the file. It returns the number
+// of pages trimmed and moved, or an error if a bucket can not be opened.
+func (tx *Tx) shrink() (trimmed, moved int, err error) {
+	// Drop the free tail of the file, remembering the pages to give them back
+	// to the freelist if the transaction is rolled back.
+	hwm := tx.meta.Pgid()
+	tx.meta.SetPgid(tx.db.freelist.trimTail(hwm))
+	for id := tx.meta.Pgid(); id < hwm; id++ {
+		tx.trimmed = append(tx.trimmed, id)
+	}
+	trimmed = len(tx.trimmed)
+
+	// Everything at or above the target fits into the free pages below it.
+	// Keep the free pages above the target out of reach of this transaction
+	// so the moved pages are allocated below it. The freelist is rewritten by
+	// every commit, so only the pages of the buckets need to be moved.
+	target := tx.meta.Pgid() - common.Pgid(tx.db.freelist.free_count())
+	tx.db.freelist.reserve(tx.meta.Txid(), target)
+	moved, err = tx.root.relocate(target)
+	return trimmed, moved, err
+}
+
+// relocate materializes the nodes of every page at or above target in the
+// bucket and its nested buckets so they are written to new pages on commit.
+// It returns the number of relocated pages, or an error if a nested bucket can
+// not be opened, such as one whose codec or comparator is not registered.
+func (b *Bucket) relocate(target common.Pgid) (int, error) {
+	// Inline buckets live in the page of their parent.
+	if b.page != nil {
+		return 0, nil
+	}
+
+	// Keep the relocated pages as full as they are now.
+	b.FillPercent = maxFillPercent
+
+	var moved int
+	var children [][]byte
+	b.tx.forEachPage(b.RootPage(), func(p *common.Page, _ int, pgids []common.Pgid) {
+		if p.Id()+common.Pgid(p.Overflow()) >= target {
+			var n *node
+			for _, id := range pgids {
+				n = b.node(id, n)
+			}
+			moved += int(p.Overflow()) + 1
+		}
+
+		if p.IsLeafPage() {
+			for i := uint16(0); i < p.Count(); i++ {
+				elem := p.LeafPageElement(i)
+				if elem.IsBucketEntry() {
+					children = append(children, cloneBytes(elem.Key()))
+				}
+			}
+		}
+	})
+
+	for _, name := range children {
+		child, err := b.bucket(name)
+		if err != nil {
+			return moved, fmt.Errorf("bucket %x: %w", name, err)
+		} else if child == nil {
+			panic(fmt.Sprintf("missing nested bucket: %x", name))
+		}
+		n, err := child.relocate(target)
+		moved += n
+		if err != nil {
+			return moved, err
+		}
+	}
+	return moved, nil
+}
+
+// truncate shrinks the database file to sz bytes. It must be called while
+// holding the writer lock, and no page at or beyond sz can be in use.
+func (db *DB) truncate(sz int) error {
+	// A memory mapped file can not be truncated on Windows.
+	if db.readOnly || runtime.GOOS == "windows" {
+		return nil
+	}
+
+	size, err := db.storage.Size()
+	if err != nil {
+		return fmt.Errorf("file stat error: %s", err)
+	} else if int(size) <= sz {
+		return nil
+	}
+
+	if err := db.storage.Truncate(int64(sz)); err != nil {
+		return fmt.Errorf("file resize error: %s", err)
+	}
+	if err := db.storage.Sync(); err != nil {
+		return fmt.Errorf("file sync error: %s", err)
+	}
+	if db.Mlock {
+		if err := db.mrelock(int(size), sz); err != nil {
+			return fmt.Errorf("mlock/munlock error: %s", err)
+		}
+	}
+
+	db.filesz = sz
+	return nil
+}
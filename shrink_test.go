@@ -0,0 +1,113 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that Shrink moves live pages out of the tail and truncates the file.
+func TestDB_Shrink(t *testing.T) {
+	for _, freelistType := range []common.FreelistType{common.FreelistArrayType, common.FreelistMapType} {
+		t.Run(string(freelistType), func(t *testing.T) {
+			db := btesting.MustCreateDBWithOption(t, &bolt.Options{FreelistType: freelistType})
+
+			// Write a bucket that is deleted later, followed by buckets
+			// whose pages end up at the end of the file.
+			fill := func(name []byte, n int, nested bool) {
+				require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+					b, err := tx.CreateBucket(name)
+					if err != nil {
+						return err
+					}
+					if nested {
+						if b, err = b.CreateBucket([]byte("nested")); err != nil {
+							return err
+						}
+					}
+					for i := 0; i < n; i++ {
+						if err := b.Put([]byte(fmt.Sprintf("%08d", i)), make([]byte, 100)); err != nil {
+							return err
+						}
+					}
+					// Add a value spanning several pages.
+					return b.Put([]byte("large"), make([]byte, 5*os.Getpagesize()))
+				}))
+			}
+			fill([]byte("deleted"), 20000, false)
+			fill([]byte("widgets"), 2000, false)
+			fill([]byte("nested"), 2000, true)
+			require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+				return tx.DeleteBucket([]byte("deleted"))
+			}))
+			widgets := dumpBucket(t, db.DB, []byte("widgets"))
+
+			before, err := os.Stat(db.Path())
+			require.NoError(t, err)
+
+			require.NoError(t, db.Shrink())
+			db.MustCheck()
+
+			after, err := os.Stat(db.Path())
+			require.NoError(t, err)
+			require.Less(t, after.Size(), before.Size()/2)
+
+			// The file only holds the pages below the high water mark.
+			require.NoError(t, db.View(func(tx *bolt.Tx) error {
+				require.Equal(t, tx.Size(), after.Size())
+				require.Equal(t, 2001, tx.Bucket([]byte("nested")).Bucket([]byte("nested")).Stats().KeyN)
+				return nil
+			}))
+			require.Equal(t, widgets, dumpBucket(t, db.DB, []byte("widgets")))
+
+			// The database keeps working after it has been shrunk.
+			fill([]byte("more"), 5000, false)
+			db.MustCheck()
+			db.MustClose()
+			db.MustReopen()
+			db.MustCheck()
+			require.Equal(t, widgets, dumpBucket(t, db.DB, []byte("widgets")))
+		})
+	}
+}
+
+// Ensure that Shrink does not reclaim pages used by an open read transaction.
+func TestDB_Shrink_OpenReadTx(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	for _, name := range []string{"deleted", "widgets"} {
+		require.NoError(t, db.Fill([]byte(name), 1, 10000,
+			func(tx int, key int) []byte { return []byte(fmt.Sprintf("%08d", key)) },
+			func(tx int, key int) []byte { return make([]byte, 100) },
+		))
+	}
+	widgets := dumpBucket(t, db.DB, []byte("widgets"))
+
+	tx, err := db.Begin(false)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("deleted"))
+	}))
+	require.NoError(t, db.Shrink())
+	db.MustCheck()
+
+	// The read transaction still sees its own snapshot.
+	require.NotNil(t, tx.Bucket([]byte("deleted")))
+	require.Equal(t, 10000, tx.Bucket([]byte("widgets")).Stats().KeyN)
+	require.NoError(t, tx.Rollback())
+
+	before, err := os.Stat(db.Path())
+	require.NoError(t, err)
+	require.NoError(t, db.Shrink())
+	db.MustCheck()
+	after, err := os.Stat(db.Path())
+	require.NoError(t, err)
+	require.Less(t, after.Size(), before.Size())
+	require.Equal(t, widgets, dumpBucket(t, db.DB, []byte("widgets")))
+}
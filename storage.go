@@ -0,0 +1,154 @@
+package bbolt
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Storage holds the pages of a database. By default a database is stored in
+// the file at the path given to Open, which is memory mapped for reads. A
+// different storage can be set with Options.Storage.
+//
+// A storage is used by a single DB at a time. The DB does not call Mmap,
+// Truncate and Close concurrently with each other, but ReadAt, WriteAt, Sync
+// and Munmap can be called while the views returned by Mmap are being read.
+type Storage interface {
+	// ReadAt reads from the storage the way io.ReaderAt does. It is used
+	// before the storage is mapped and to copy the database.
+	io.ReaderAt
+
+	// WriteAt writes to the storage the way io.WriterAt does, growing it if
+	// needed. Written data must be visible through the data returned by Mmap.
+	io.WriterAt
+
+	// Size returns the size of the storage in bytes.
+	Size() (int64, error)
+
+	// Truncate grows or shrinks the storage to the given size in bytes.
+	Truncate(size int64) error
+
+	// Sync flushes the data written to the storage to durable media.
+	Sync() error
+
+	// Mmap returns a read-only view of the first size bytes of the storage.
+	// The size can be larger than the storage, in which case the view
+	// includes the data written beyond its current end later on. The flags
+	// are DB.MmapFlags, storages which are not backed by a file can ignore
+	// them.
+	//
+	// Mmap is called again with a larger size when the database grows,
+	// while read transactions may still use the earlier views. A view only
+	// has to include the data written while it is the latest one.
+	Mmap(size int, flags int) ([]byte, error)
+
+	// Munmap releases a view returned by Mmap.
+	Munmap(b []byte) error
+
+	// Lock acquires an exclusive or a shared lock on the storage, so that a
+	// database opened for writing can not be used by another DB at the same
+	// time. It returns ErrTimeout when timeout is non-zero and expires, and
+	// the context's error when ctx is done.
+	Lock(ctx context.Context, exclusive bool, timeout time.Duration) error
+
+	// Unlock releases the lock acquired with Lock.
+	Unlock() error
+
+	// Close releases the resources held by the storage.
+	Close() error
+}
+
+// vectorWriter is implemented by the storages which can write several buffers
+// at consecutive offsets with a single system call, such as pwritev.
+type vectorWriter interface {
+	// writevAt writes the buffers one after the other starting at offset
+	// off, and returns the number of system calls it made.
+	writevAt(bufs [][]byte, off int64) (calls int, err error)
+}
+
+// fileStorage is the default storage, which keeps the database in a file.
+type fileStorage struct {
+	file     *os.File
+	readOnly bool
+
+	// data is the latest view returned by Mmap, which is synced on
+	// platforms without a unified buffer cache.
+	mu   sync.Mutex
+	data []byte // mmap'ed readonly, write throws SEGV
+}
+
+func (s *fileStorage) ReadAt(b []byte, off int64) (int, error) {
+	return s.file.ReadAt(b, off)
+}
+
+func (s *fileStorage) WriteAt(b []byte, off int64) (int, error) {
+	return s.file.WriteAt(b, off)
+}
+
+func (s *fileStorage) Size() (int64, error) {
+	info, err := s.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *fileStorage) Truncate(size int64) error {
+	return s.file.Truncate(size)
+}
+
+func (s *fileStorage) Sync() error {
+	return fdatasync(s)
+}
+
+func (s *fileStorage) Mmap(size int, flags int) ([]byte, error) {
+	b, err := mmap(s, size, flags)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.data = b
+	s.mu.Unlock()
+	return b, nil
+}
+
+func (s *fileStorage) Munmap(b []byte) error {
+	s.mu.Lock()
+	if len(s.data) > 0 && &b[0] == &s.data[0] {
+		s.data = nil
+	}
+	s.mu.Unlock()
+	return munmap(b)
+}
+
+func (s *fileStorage) Lock(ctx context.Context, exclusive bool, timeout time.Duration) error {
+	return flock(ctx, s, exclusive, timeout)
+}
+
+func (s *fileStorage) Unlock() error {
+	// No need to unlock read-only file, closing it releases the shared lock.
+	if s.readOnly {
+		return nil
+	}
+	return funlock(s)
+}
+
+func (s *fileStorage) Close() error {
+	return s.file.Close()
+}
+
+// openReader returns a reader for the pages of the database, and a function
+// which releases it. The database file is opened again for reading so that
+// flag, such as Tx.WriteFlag, applies to the reads.
+func (db *DB) openReader(flag int) (io.ReaderAt, func() error, error) {
+	if _, ok := db.storage.(*fileStorage); !ok {
+		return db.storage, func() error { return nil }, nil
+	}
+	f, err := db.openFile(db.path, os.O_RDONLY|flag, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f.Close, nil
+}
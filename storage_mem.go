@@ -0,0 +1,223 @@
+package bbolt
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// MemStorage is a Storage that keeps the database in memory. It can be used
+// to run a database entirely in RAM, for example in tests or for caches.
+// Nothing is persisted, but the contents outlive the DB so that the storage
+// can be opened again after the DB is closed.
+//
+// The zero value is an empty storage ready to use.
+type MemStorage struct {
+	mu sync.Mutex
+
+	// buf holds the contents. Bytes between its length and capacity are
+	// always zero, so that the storage can grow in place.
+	buf []byte
+
+	// mapped is the latest view returned by Mmap. It shares its memory with
+	// buf until buf has to be reallocated, after which writes are copied to
+	// both. Earlier views are left as they are.
+	mapped []byte
+
+	readers int  // number of shared locks held
+	writer  bool // whether the exclusive lock is held
+}
+
+// ReadAt reads len(b) bytes from the storage starting at offset off.
+func (s *MemStorage) ReadAt(b []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if off >= int64(len(s.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(b, s.buf[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// WriteAt writes b to the storage at offset off, growing it if needed.
+func (s *MemStorage) WriteAt(b []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	end := int(off) + len(b)
+	if end > len(s.buf) {
+		s.resize(end)
+	}
+	copy(s.buf[off:], b)
+	s.syncMapped(int(off), end)
+	return len(b), nil
+}
+
+// Size returns the size of the storage in bytes.
+func (s *MemStorage) Size() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.buf)), nil
+}
+
+// Truncate grows or shrinks the storage to the given size in bytes.
+func (s *MemStorage) Truncate(size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := len(s.buf)
+	s.resize(int(size))
+	if int(size) < old {
+		s.syncMapped(int(size), old)
+	}
+	return nil
+}
+
+// Sync does nothing, since the storage is not persisted.
+func (s *MemStorage) Sync() error {
+	return nil
+}
+
+// Mmap returns a view of the first size bytes of the storage.
+func (s *MemStorage) Mmap(size int, _ int) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Reserve enough memory for the view, so that writes within it are
+	// made in place.
+	if size > cap(s.buf) {
+		buf := make([]byte, len(s.buf), size)
+		copy(buf, s.buf)
+		s.buf = buf
+	}
+	s.mapped = s.buf[:size]
+	return s.mapped, nil
+}
+
+// Munmap releases a view returned by Mmap.
+func (s *MemStorage) Munmap(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Earlier views may share their memory with the latest one, so the
+	// latest view is only released if it's the same slice.
+	if len(b) > 0 && len(b) == len(s.mapped) && &b[0] == &s.mapped[0] {
+		s.mapped = nil
+	}
+	return nil
+}
+
+// Lock acquires an exclusive or a shared lock on the storage.
+func (s *MemStorage) Lock(ctx context.Context, exclusive bool, timeout time.Duration) error {
+	var t time.Time
+	if timeout != 0 {
+		t = time.Now()
+	}
+	for {
+		if s.tryLock(exclusive) {
+			return nil
+		}
+
+		// If we timed out then return an error.
+		if timeout != 0 && time.Since(t) > timeout-flockRetryTimeout {
+			return common.ErrTimeout
+		}
+
+		// Wait for a bit and try again.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(flockRetryTimeout):
+		}
+	}
+}
+
+// Unlock releases the lock acquired with Lock.
+func (s *MemStorage) Unlock() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writer {
+		s.writer = false
+	} else if s.readers > 0 {
+		s.readers--
+	}
+	return nil
+}
+
+// Close releases the views of the storage. The contents are kept.
+func (s *MemStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mapped = nil
+	return nil
+}
+
+// tryLock acquires an exclusive or a shared lock if it is available.
+func (s *MemStorage) tryLock(exclusive bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.writer || (exclusive && s.readers > 0) {
+		return false
+	}
+	if exclusive {
+		s.writer = true
+	} else {
+		s.readers++
+	}
+	return true
+}
+
+// resize changes the length of buf, zeroing the bytes it drops. The memory is
+// reallocated when the capacity is too small.
+func (s *MemStorage) resize(size int) {
+	if size < len(s.buf) {
+		zero(s.buf[size:])
+		s.buf = s.buf[:size]
+		return
+	}
+	if size > cap(s.buf) {
+		c := 2 * cap(s.buf)
+		if c < size {
+			c = size
+		}
+		buf := make([]byte, len(s.buf), c)
+		copy(buf, s.buf)
+		s.buf = buf
+	}
+	s.buf = s.buf[:size]
+}
+
+// syncMapped copies the bytes in [from, to) of buf to the mapped view, when
+// buf has been reallocated since the view was returned.
+func (s *MemStorage) syncMapped(from, to int) {
+	if len(s.mapped) == 0 || &s.buf[:1][0] == &s.mapped[0] {
+		return
+	}
+	if to > len(s.mapped) {
+		to = len(s.mapped)
+	}
+	if from >= to {
+		return
+	}
+	if to > len(s.buf) {
+		zero(s.mapped[from:to])
+		to = len(s.buf)
+	}
+	copy(s.mapped[from:to], s.buf[from:to])
+}
+
+// zero sets all bytes of b to zero.
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
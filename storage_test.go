@@ -0,0 +1,114 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// Ensure that a database can be kept in memory, and opened again after it is
+// closed.
+func TestMemStorage(t *testing.T) {
+	s := &bolt.MemStorage{}
+	db := btesting.MustCreateDBWithOption(t, &bolt.Options{Storage: s})
+
+	// Grow the database past the initial mapping a few times.
+	require.NoError(t, db.Fill([]byte("widgets"), 10, 1000,
+		func(tx int, key int) []byte { return []byte(fmt.Sprintf("%04d%04d", tx, key)) },
+		func(tx int, key int) []byte { return make([]byte, 100) },
+	))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	}))
+	db.MustCheck()
+	db.MustClose()
+
+	size, err := s.Size()
+	require.NoError(t, err)
+	require.Greater(t, size, int64(1000*100))
+
+	db.MustReopen()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		require.Equal(t, 10001, b.Stats().KeyN)
+		require.Equal(t, []byte("bar"), b.Get([]byte("foo")))
+		return nil
+	}))
+
+	// A copy of the database can be opened from a file.
+	path := filepath.Join(t.TempDir(), "db.copy")
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	}))
+	fdb, err := bolt.Open(path, 0600, nil)
+	require.NoError(t, err)
+	require.NoError(t, fdb.View(func(tx *bolt.Tx) error {
+		require.Equal(t, []byte("bar"), tx.Bucket([]byte("widgets")).Get([]byte("foo")))
+		return nil
+	}))
+	require.NoError(t, fdb.Close())
+}
+
+// Ensure that a memory storage is locked the same way as a file.
+func TestMemStorage_Lock(t *testing.T) {
+	s := &bolt.MemStorage{}
+	db, err := bolt.Open("mem", 0, &bolt.Options{Storage: s})
+	require.NoError(t, err)
+
+	_, err = bolt.Open("mem", 0, &bolt.Options{Storage: s, Timeout: 100 * time.Millisecond})
+	require.ErrorIs(t, err, common.ErrTimeout)
+	_, err = bolt.Open("mem", 0, &bolt.Options{Storage: s, Timeout: 100 * time.Millisecond, ReadOnly: true})
+	require.ErrorIs(t, err, common.ErrTimeout)
+	require.NoError(t, db.Close())
+
+	// Read-only databases can share the storage.
+	db0, err := bolt.Open("mem", 0, &bolt.Options{Storage: s, ReadOnly: true})
+	require.NoError(t, err)
+	db1, err := bolt.Open("mem", 0, &bolt.Options{Storage: s, ReadOnly: true})
+	require.NoError(t, err)
+	_, err = bolt.Open("mem", 0, &bolt.Options{Storage: s, Timeout: 100 * time.Millisecond})
+	require.ErrorIs(t, err, common.ErrTimeout)
+	require.NoError(t, db0.Close())
+	require.NoError(t, db1.Close())
+
+	db, err = bolt.Open("mem", 0, &bolt.Options{Storage: s, Timeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+}
+
+// Ensure that writes and truncation are visible through a mapping of the
+// storage, even when the storage has to grow past it.
+func TestMemStorage_Mmap(t *testing.T) {
+	s := &bolt.MemStorage{}
+	_, err := s.WriteAt([]byte("foo"), 0)
+	require.NoError(t, err)
+
+	b, err := s.Mmap(8, 0)
+	require.NoError(t, err)
+	require.Equal(t, []byte("foo\x00\x00\x00\x00\x00"), b)
+
+	_, err = s.WriteAt([]byte("bar"), 4)
+	require.NoError(t, err)
+	require.NoError(t, s.Truncate(1024))
+	_, err = s.WriteAt([]byte("baz"), 2)
+	require.NoError(t, err)
+	require.Equal(t, []byte("fobazar\x00"), b)
+
+	require.NoError(t, s.Truncate(3))
+	require.Equal(t, []byte("fob\x00\x00\x00\x00\x00"), b)
+	require.NoError(t, s.Munmap(b))
+
+	buf := make([]byte, 8)
+	n, err := s.ReadAt(buf, 0)
+	require.Equal(t, 3, n)
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, []byte("fob"), buf[:n])
+}
@@ -1,11 +1,14 @@
 package bbolt
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
@@ -25,11 +28,61 @@ type Tx struct {
 	writable       bool
 	managed        bool
 	db             *DB
+	ctx            context.Context
 	meta           *common.Meta
 	root           Bucket
 	pages          map[common.Pgid]*common.Page
 	stats          TxStats
 	commitHandlers []func()
+	changes        *ChangeSet
+	changeHandlers []func(*ChangeSet)
+	savepoints     []*Savepoint
+
+	// mapping is the mapping a read transaction reads pages from, which it
+	// holds a reference to until it closes. It's nil for write transactions,
+	// which read from the current mapping of the database.
+	mapping *mapping
+
+	// decrypted caches the pages of an encrypted database read by the
+	// transaction, which can not be served from the mmap.
+	decrypted   map[common.Pgid]*common.Page
+	decryptedMu sync.Mutex
+
+	// loaders are the bulk loaders of the transaction which are still open.
+	// bulkLoaded is set once the transaction started a bulk load.
+	loaders    []*BulkLoader
+	bulkLoaded bool
+
+	// expiryNow is the time against which the transaction checks if keys
+	// have expired, in nanoseconds since the epoch, or 0 until it is needed.
+	expiryNow int64
+
+	// indexes are the indexes declared when the transaction began.
+	indexes []*indexDef
+
+	// watching is set if the database had watchers when the transaction
+	// began, which receive the changes in watchLog once it commits.
+	watching bool
+	watchLog []watchRecord
+
+	// start, label and stack describe a read transaction for DB.OpenTxs.
+	// reported is set once it was reported for exceeding MaxReadTxAge.
+	// label and reported are protected by the meta lock.
+	start    time.Time
+	label    string
+	stack    []byte
+	reported bool
+
+	// scope is the state of a transaction begun with DB.BeginBuckets, or nil.
+	scope *txScope
+
+	// trimmed are the free pages at the end of the file which Shrink removed
+	// from the freelist, and which are returned to it on rollback.
+	trimmed common.Pgids
+
+	// features are the meta flags of the features the transaction wrote,
+	// which raise the format version of the database when it commits.
+	features uint32
 
 	// WriteFlag specifies the flag for write-related methods like WriteTo().
 	// Tx opens the database file with the specified flag to copy the data.
@@ -44,6 +97,7 @@ type Tx struct {
 func (tx *Tx) init(db *DB) {
 	tx.db = db
 	tx.pages = nil
+	tx.indexes = db.indexes
 
 	// Copy the meta page since it can be changed by the writer.
 	tx.meta = &common.Meta{}
@@ -58,6 +112,10 @@ func (tx *Tx) init(db *DB) {
 	if tx.writable {
 		tx.pages = make(map[common.Pgid]*common.Page)
 		tx.meta.IncTxid()
+		if db.TrackChanges {
+			tx.changes = &ChangeSet{Txid: int(tx.meta.Txid())}
+		}
+		tx.watching = db.watched()
 	}
 }
 
@@ -71,6 +129,14 @@ func (tx *Tx) DB() *DB {
 	return tx.db
 }
 
+// Context returns the context the transaction was started with.
+func (tx *Tx) Context() context.Context {
+	if tx.ctx == nil {
+		return context.Background()
+	}
+	return tx.ctx
+}
+
 // Size returns current database size in bytes as seen by this transaction.
 func (tx *Tx) Size() int64 {
 	return int64(tx.meta.Pgid()) * int64(tx.db.pageSize)
@@ -98,35 +164,141 @@ func (tx *Tx) Stats() TxStats {
 // Returns nil if the bucket does not exist.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (tx *Tx) Bucket(name []byte) *Bucket {
+	if tx.isReservedName(name) {
+		return nil
+	}
 	return tx.root.Bucket(name)
 }
 
+// LookupBucket is like Bucket, but returns an error wrapping ErrUnknownCodec
+// or ErrUnknownComparator if the bucket exists but its codec or comparator is
+// not registered.
+func (tx *Tx) LookupBucket(name []byte) (*Bucket, error) {
+	if tx.isReservedName(name) {
+		return nil, nil
+	}
+	return tx.root.LookupBucket(name)
+}
+
 // CreateBucket creates a new bucket.
 // Returns an error if the bucket already exists, if the bucket name is blank, or if the bucket name is too long.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (tx *Tx) CreateBucket(name []byte) (*Bucket, error) {
+	if tx.isReservedName(name) {
+		return nil, common.ErrBucketNameReserved
+	}
 	return tx.root.CreateBucket(name)
 }
 
+// CreateBucketWithOptions creates a new bucket with the given options.
+// Default options are used if opts is nil.
+// Returns an error if the bucket already exists, if the bucket name is blank, or if the bucket name is too long.
+// The bucket instance is only valid for the lifetime of the transaction.
+func (tx *Tx) CreateBucketWithOptions(name []byte, opts *BucketOptions) (*Bucket, error) {
+	if tx.isReservedName(name) {
+		return nil, common.ErrBucketNameReserved
+	}
+	return tx.root.CreateBucketWithOptions(name, opts)
+}
+
 // CreateBucketIfNotExists creates a new bucket if it doesn't already exist.
-// Returns an error if the bucket name is blank, or if the bucket name is too long.
+// Returns an error if the bucket name is blank, if the bucket name is too long, or if the existing bucket can not be opened.
 // The bucket instance is only valid for the lifetime of the transaction.
 func (tx *Tx) CreateBucketIfNotExists(name []byte) (*Bucket, error) {
+	if tx.isReservedName(name) {
+		return nil, common.ErrBucketNameReserved
+	}
 	return tx.root.CreateBucketIfNotExists(name)
 }
 
 // DeleteBucket deletes a bucket.
 // Returns an error if the bucket cannot be found or if the key represents a non-bucket value.
 func (tx *Tx) DeleteBucket(name []byte) error {
+	if tx.isReservedName(name) {
+		return common.ErrBucketNotFound
+	}
 	return tx.root.DeleteBucket(name)
 }
 
+// MoveBucket moves the bucket at srcPath, along with everything in it, to the
+// bucket at dstParentPath under the name newName. An empty dstParentPath moves
+// the bucket to the root of the database. Only the bucket header is moved, so
+// the contents of the bucket are not copied.
+// Returns an error if either bucket can not be found, if newName is blank or
+// already exists in the destination, or if the destination is inside of the
+// bucket being moved.
+func (tx *Tx) MoveBucket(srcPath [][]byte, dstParentPath [][]byte, newName []byte) error {
+	if tx.db == nil {
+		return common.ErrTxClosed
+	} else if !tx.writable {
+		return common.ErrTxNotWritable
+	} else if len(srcPath) == 0 || len(newName) == 0 {
+		return common.ErrBucketNameRequired
+	} else if len(newName) > MaxKeySize {
+		return common.ErrKeyTooLarge
+	}
+
+	// A bucket can not be moved into itself.
+	if len(dstParentPath) >= len(srcPath) && pathHasPrefix(dstParentPath, srcPath) {
+		return common.ErrInvalidMove
+	}
+
+	// Internal buckets can not be moved, nor replaced.
+	if tx.isReservedName(srcPath[0]) || (len(dstParentPath) > 0 && tx.isReservedName(dstParentPath[0])) {
+		return common.ErrBucketNotFound
+	} else if len(dstParentPath) == 0 && tx.isReservedName(newName) {
+		return common.ErrBucketNameReserved
+	}
+
+	src, err := tx.bucketAt(srcPath[:len(srcPath)-1])
+	if err != nil {
+		return err
+	}
+	dst, err := tx.bucketAt(dstParentPath)
+	if err != nil {
+		return err
+	}
+	return src.moveBucket(srcPath[len(srcPath)-1], dst, newName)
+}
+
+// bucketAt returns the bucket at the given path of names, starting from the
+// root bucket.
+func (tx *Tx) bucketAt(path [][]byte) (*Bucket, error) {
+	b := &tx.root
+	for _, name := range path {
+		child, err := b.bucket(name)
+		if err != nil {
+			return nil, err
+		} else if child == nil {
+			return nil, common.ErrBucketNotFound
+		}
+		b = child
+	}
+	return b, nil
+}
+
+// pathHasPrefix returns whether the path of names starts with prefix.
+func pathHasPrefix(path, prefix [][]byte) bool {
+	for i := range prefix {
+		if !bytes.Equal(path[i], prefix[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // ForEach executes a function for each bucket in the root.
 // If the provided function returns an error then the iteration is stopped and
-// the error is returned to the caller.
+// the error is returned to the caller. The iteration also stops with an error
+// wrapping ErrUnknownCodec or ErrUnknownComparator at a bucket whose codec or
+// comparator is not registered.
 func (tx *Tx) ForEach(fn func(name []byte, b *Bucket) error) error {
 	return tx.root.ForEach(func(k, v []byte) error {
-		return fn(k, tx.root.Bucket(k))
+		b, err := tx.root.bucket(k)
+		if err != nil {
+			return fmt.Errorf("bucket %x: %w", k, err)
+		}
+		return fn(k, b)
 	})
 }
 
@@ -146,7 +318,48 @@ func (tx *Tx) Commit() error {
 		return common.ErrTxNotWritable
 	}
 
-	// TODO(benbjohnson): Use vectorized I/O to write out dirty pages.
+	// A scoped transaction rebalances, spills and writes its buckets on the
+	// snapshot, and takes the writer lock only to write the root bucket, the
+	// freelist and the meta page.
+	start := time.Now()
+	if tx.scope != nil {
+		tx.rebalanceScope()
+		if err := tx.writeScope(); err != nil {
+			tx.rollback()
+			return err
+		}
+		tx.db.rwlock.Lock()
+		tx.rebase()
+	}
+
+	db, txid := tx.db, int(tx.meta.Txid())
+	err := tx.commit()
+	db.event(Event{Type: EventCommit, Txid: txid, Duration: time.Since(start), Err: err}, "commit", "txid", txid)
+	if err != nil {
+		return err
+	}
+
+	// Execute commit handlers now that the locks have been removed.
+	for _, fn := range tx.commitHandlers {
+		fn()
+	}
+	for _, fn := range tx.changeHandlers {
+		fn(tx.changes)
+	}
+
+	return nil
+}
+
+// commit writes all changes to disk, updates the meta page and closes the
+// transaction. The transaction is rolled back if it fails.
+func (tx *Tx) commit() error {
+	// Finish the bulk loads which are still open.
+	for len(tx.loaders) > 0 {
+		if err := tx.loaders[0].Close(); err != nil {
+			tx.rollback()
+			return err
+		}
+	}
 
 	// Rebalance nodes which have had deletions.
 	var startTime = time.Now()
@@ -154,6 +367,7 @@ func (tx *Tx) Commit() error {
 	if tx.stats.GetRebalance() > 0 {
 		tx.stats.IncRebalanceTime(time.Since(startTime))
 	}
+	tx.phase(EventCommitRebalance, startTime, 0)
 
 	opgid := tx.meta.Pgid()
 
@@ -164,13 +378,15 @@ func (tx *Tx) Commit() error {
 		return err
 	}
 	tx.stats.IncSpillTime(time.Since(startTime))
+	tx.phase(EventCommitSpill, startTime, 0)
 
 	// Free the old root bucket.
 	tx.meta.RootBucket().SetRootPage(tx.root.RootPage())
 
 	// Free the old freelist because commit writes out a fresh freelist.
+	startTime = time.Now()
 	if tx.meta.Freelist() != common.PgidNoFreelist {
-		tx.db.freelist.free(tx.meta.Txid(), tx.db.page(tx.meta.Freelist()))
+		tx.free(tx.db.page(tx.meta.Freelist()))
 	}
 
 	if !tx.db.NoFreelistSync {
@@ -181,6 +397,7 @@ func (tx *Tx) Commit() error {
 	} else {
 		tx.meta.SetFreelist(common.PgidNoFreelist)
 	}
+	tx.phase(EventCommitFreelist, startTime, 0)
 
 	// If the high water mark has moved up then attempt to grow the database.
 	if tx.meta.Pgid() > opgid {
@@ -191,11 +408,16 @@ func (tx *Tx) Commit() error {
 	}
 
 	// Write dirty pages to disk.
-	startTime = time.Now()
+	var written int
+	for _, p := range tx.pages {
+		written += (int(p.Overflow()) + 1) * tx.db.pageSize
+	}
+	writeStart := time.Now()
 	if err := tx.write(); err != nil {
 		tx.rollback()
 		return err
 	}
+	tx.phase(EventCommitWrite, writeStart, written)
 
 	// If strict mode is enabled then perform a consistency check.
 	if tx.db.StrictMode {
@@ -214,27 +436,37 @@ func (tx *Tx) Commit() error {
 	}
 
 	// Write meta to disk.
+	startTime = time.Now()
 	if err := tx.writeMeta(); err != nil {
 		tx.rollback()
 		return err
 	}
-	tx.stats.IncWriteTime(time.Since(startTime))
+	tx.phase(EventCommitMeta, startTime, 0)
+	tx.stats.IncWriteTime(time.Since(writeStart))
 
-	// Finalize the transaction.
-	tx.close()
+	// The high water mark is above the pages claimed by scoped transactions.
+	tx.db.scopePgid = 0
 
-	// Execute commit handlers now that the locks have been removed.
-	for _, fn := range tx.commitHandlers {
-		fn()
+	// Queue the events of the watchers while the writer lock is held.
+	if len(tx.watchLog) > 0 {
+		tx.db.notifyWatchers(int(tx.meta.Txid()), tx.watchLog)
 	}
 
+	// Finalize the transaction.
+	tx.close()
+
 	return nil
 }
 
+// phase sends the event of a commit phase which started at start.
+func (tx *Tx) phase(typ EventType, start time.Time, size int) {
+	tx.db.hook.OnEvent(Event{Type: typ, Txid: int(tx.meta.Txid()), Duration: time.Since(start), Size: size})
+}
+
 func (tx *Tx) commitFreelist() error {
 	// Allocate new pages for the new free list. This will overestimate
 	// the size of the freelist but not underestimate the size (which would be bad).
-	p, err := tx.allocate((tx.db.freelist.size() / tx.db.pageSize) + 1)
+	p, err := tx.allocate(((tx.db.freelist.size() + tx.db.trailerSize()) / tx.db.pageSize) + 1)
 	if err != nil {
 		tx.rollback()
 		return err
@@ -264,8 +496,11 @@ func (tx *Tx) nonPhysicalRollback() {
 	if tx.db == nil {
 		return
 	}
-	if tx.writable {
+	if tx.ownsFreelist() {
 		tx.db.freelist.rollback(tx.meta.Txid())
+		if len(tx.trimmed) > 0 {
+			tx.db.freelist.untrimTail(tx.trimmed)
+		}
 	}
 	tx.close()
 }
@@ -275,19 +510,30 @@ func (tx *Tx) rollback() {
 	if tx.db == nil {
 		return
 	}
-	if tx.writable {
+	if tx.ownsFreelist() {
 		tx.db.freelist.rollback(tx.meta.Txid())
-		// When mmap fails, the `data`, `dataref` and `datasz` may be reset to
-		// zero values, and there is no way to reload free page IDs in this case.
-		if tx.db.data != nil {
+		if len(tx.trimmed) > 0 {
+			tx.db.freelist.untrimTail(tx.trimmed)
+		}
+		// When the data file is not mapped, there is no way to reload free
+		// page IDs.
+		if tx.db.mapping != nil {
 			if !tx.db.hasSyncedFreelist() {
 				// Reconstruct free page list by scanning the DB to get the whole free page list.
 				// Note: scaning the whole db is heavy if your db size is large in NoSyncFreeList mode.
 				tx.db.freelist.noSyncReload(tx.db.freepages())
 			} else {
 				// Read free page list from freelist page.
-				tx.db.freelist.reload(tx.db.page(tx.db.meta().Freelist()))
+				tx.db.freelist.reload(tx.db.mustReadPage(tx.db.meta().Freelist()))
 			}
+
+			// The pages above the high water mark on disk are not on the
+			// freelist page. Those below the high water mark of the pages
+			// claimed by scoped transactions are free unless still claimed.
+			tx.db.freelist.freeUnclaimed(tx.db.meta().Pgid(), tx.db.scopePgid)
+		}
+		if tx.scope != nil {
+			tx.db.freelist.unclaim(tx.scope.claimed)
 		}
 	}
 	tx.close()
@@ -297,7 +543,7 @@ func (tx *Tx) close() {
 	if tx.db == nil {
 		return
 	}
-	if tx.writable {
+	if tx.ownsFreelist() {
 		// Grab freelist stats.
 		var freelistFreeN = tx.db.freelist.free_count()
 		var freelistPendingN = tx.db.freelist.pending_count()
@@ -305,7 +551,13 @@ func (tx *Tx) close() {
 
 		// Remove transaction ref & writer lock.
 		tx.db.rwtx = nil
+		if tx.scope != nil {
+			tx.db.endScopeTurn(tx.scope.txid)
+		}
 		tx.db.rwlock.Unlock()
+		if tx.scope == nil {
+			tx.db.scopelock.Unlock()
+		}
 
 		// Merge statistics.
 		tx.db.statlock.Lock()
@@ -315,15 +567,20 @@ func (tx *Tx) close() {
 		tx.db.stats.FreelistInuse = freelistAlloc
 		tx.db.stats.TxStats.add(&tx.stats)
 		tx.db.statlock.Unlock()
-	} else {
+	} else if tx.scope == nil {
 		tx.db.removeTx(tx)
 	}
+	if tx.scope != nil {
+		tx.closeScope()
+	}
 
 	// Clear all references.
 	tx.db = nil
 	tx.meta = nil
 	tx.root = Bucket{tx: tx}
 	tx.pages = nil
+	tx.decrypted = nil
+	tx.mapping = nil
 }
 
 // Copy writes the entire database to a writer.
@@ -339,16 +596,36 @@ func (tx *Tx) Copy(w io.Writer) error {
 // If err == nil then exactly tx.Size() bytes will be written into the writer.
 func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 	// Attempt to open reader with WriteFlag
-	f, err := tx.db.openFile(tx.db.path, os.O_RDONLY|tx.WriteFlag, 0)
+	f, closeReader, err := tx.db.openReader(tx.WriteFlag)
 	if err != nil {
 		return 0, err
 	}
 	defer func() {
-		if cerr := f.Close(); err == nil {
+		if cerr := closeReader(); err == nil {
 			err = cerr
 		}
 	}()
 
+	// Write both meta pages.
+	n, err = tx.writeMetaPages(w)
+	if err != nil {
+		return n, err
+	}
+
+	// Copy data pages, which follow the meta pages in the file.
+	size := tx.Size() - int64(tx.db.pageSize*2)
+	wn, err := io.CopyN(w, io.NewSectionReader(f, int64(tx.db.pageSize*2), size), size)
+	n += wn
+	if err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+// writeMetaPages writes the two meta pages of a copy of the database as seen
+// by this transaction.
+func (tx *Tx) writeMetaPages(w io.Writer) (n int64, err error) {
 	// Generate a meta page. We use the same page data for both meta pages.
 	buf := make([]byte, tx.db.pageSize)
 	page := (*common.Page)(unsafe.Pointer(&buf[0]))
@@ -374,18 +651,6 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 		return n, fmt.Errorf("meta 1 copy: %s", err)
 	}
 
-	// Move past the meta pages in the file.
-	if _, err := f.Seek(int64(tx.db.pageSize*2), io.SeekStart); err != nil {
-		return n, fmt.Errorf("seek: %s", err)
-	}
-
-	// Copy data pages.
-	wn, err := io.CopyN(w, f, tx.Size()-int64(tx.db.pageSize*2))
-	n += wn
-	if err != nil {
-		return n, err
-	}
-
 	return n, nil
 }
 
@@ -408,7 +673,13 @@ func (tx *Tx) CopyFile(path string, mode os.FileMode) error {
 
 // allocate returns a contiguous block of memory starting at a given page.
 func (tx *Tx) allocate(count int) (*common.Page, error) {
-	p, err := tx.db.allocate(tx.meta.Txid(), count)
+	var p *common.Page
+	var err error
+	if tx.scope != nil && !tx.scope.committing {
+		p, err = tx.claim(count)
+	} else {
+		p, err = tx.db.allocate(tx.meta.Txid(), count)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -435,62 +706,131 @@ func (tx *Tx) write() error {
 	sort.Sort(pages)
 
 	// Write pages to disk in order.
+	if err := tx.writePages(pages); err != nil {
+		return err
+	}
+
+	// Remember which pages this transaction wrote for incremental backups.
+	tx.db.writes.record(tx.meta.Txid(), pages)
+
+	// Ignore file sync if flag is set on DB.
+	if !tx.db.NoSync || common.IgnoreNoSync {
+		if err := tx.db.storage.Sync(); err != nil {
+			return err
+		}
+	}
+
+	// Put small pages back to page pool.
 	for _, p := range pages {
-		rem := (uint64(p.Overflow()) + 1) * uint64(tx.db.pageSize)
-		offset := int64(p.Id()) * int64(tx.db.pageSize)
-		var written uintptr
+		tx.db.releasePageBuffer(p)
+	}
 
-		// Write out page in "max allocation" sized chunks.
-		for {
-			sz := rem
-			if sz > maxAllocSize-1 {
-				sz = maxAllocSize - 1
-			}
-			buf := common.UnsafeByteSlice(unsafe.Pointer(p), written, 0, int(sz))
+	return nil
+}
 
-			if _, err := tx.db.ops.writeAt(buf, offset); err != nil {
+// writePages writes the sorted dirty pages to disk. When the storage supports
+// vectored writes, each run of contiguous pages is written with as few system
+// calls as possible, otherwise the pages are written one by one.
+func (tx *Tx) writePages(pages common.Pages) error {
+	if tx.db.ops.writevAt == nil {
+		for _, p := range pages {
+			if err := tx.writePage(p); err != nil {
 				return err
 			}
+		}
+		return nil
+	}
 
-			// Update statistics.
-			tx.stats.IncWrite(1)
-
-			// Exit inner for loop if we've written all the chunks.
-			rem -= sz
-			if rem == 0 {
-				break
+	var bufs [][]byte
+	for i := 0; i < len(pages); {
+		// Collect the chunks of the run of pages starting at pages[i].
+		bufs = bufs[:0]
+		next := pages[i].Id()
+		j := i
+		for ; j < len(pages) && pages[j].Id() == next; j++ {
+			if err := tx.sealPage(pages[j]); err != nil {
+				return err
 			}
+			bufs = tx.appendChunks(bufs, pages[j])
+			next += common.Pgid(pages[j].Overflow()) + 1
+		}
 
-			// Otherwise move offset forward and move pointer to next chunk.
-			offset += int64(sz)
-			written += uintptr(sz)
+		calls, err := tx.db.ops.writevAt(bufs, int64(pages[i].Id())*int64(tx.db.pageSize))
+		tx.stats.IncWrite(int64(len(bufs)))
+		tx.stats.IncWriteCalls(int64(calls))
+		if err != nil {
+			return err
 		}
+		i = j
 	}
+	return nil
+}
 
-	// Ignore file sync if flag is set on DB.
-	if !tx.db.NoSync || common.IgnoreNoSync {
-		if err := fdatasync(tx.db); err != nil {
+// writePage encrypts and checksums a dirty page, as the database requires, and
+// writes it to disk.
+func (tx *Tx) writePage(p *common.Page) error {
+	if err := tx.sealPage(p); err != nil {
+		return err
+	}
+
+	offset := int64(p.Id()) * int64(tx.db.pageSize)
+	for _, buf := range tx.appendChunks(nil, p) {
+		if _, err := tx.db.ops.writeAt(buf, offset); err != nil {
 			return err
 		}
+
+		// Update statistics.
+		tx.stats.IncWrite(1)
+		tx.stats.IncWriteCalls(1)
+
+		offset += int64(len(buf))
 	}
+	return nil
+}
 
-	// Put small pages back to page pool.
-	for _, p := range pages {
-		// Ignore page sizes over 1 page.
-		// These are allocated using make() instead of the page pool.
-		if int(p.Overflow()) != 0 {
-			continue
+// sealPage encrypts a dirty page before it's written, stores the id of the
+// transaction in it and checksums it, as the database requires.
+func (tx *Tx) sealPage(p *common.Page) error {
+	if tx.db.cipher != nil {
+		if err := tx.db.encryptPage(p, tx.pageTxid()); err != nil {
+			return err
 		}
+	}
+	if tx.db.pageTxids {
+		p.SetTxid(tx.db.pageSize, tx.pageTxid())
+	}
+	if tx.db.pageChecksums {
+		p.SetChecksum(tx.db.pageSize)
+	}
+	return nil
+}
 
-		buf := common.UnsafeByteSlice(unsafe.Pointer(p), 0, 0, tx.db.pageSize)
-
-		// See https://go.googlesource.com/go/+/f03c9202c43e0abb130669852082117ca50aa9b1
-		for i := range buf {
-			buf[i] = 0
+// appendChunks appends the contents of a page to bufs in "max allocation"
+// sized chunks.
+func (tx *Tx) appendChunks(bufs [][]byte, p *common.Page) [][]byte {
+	rem := (uint64(p.Overflow()) + 1) * uint64(tx.db.pageSize)
+	var written uintptr
+	for rem > 0 {
+		sz := rem
+		if sz > maxAllocSize-1 {
+			sz = maxAllocSize - 1
 		}
-		tx.db.pagePool.Put(buf) //nolint:staticcheck
+		bufs = append(bufs, common.UnsafeByteSlice(unsafe.Pointer(p), written, 0, int(sz)))
+		rem -= sz
+		written += uintptr(sz)
 	}
+	return bufs
+}
 
+// flushPage writes a dirty page to disk before the transaction commits, and
+// drops it from the page cache.
+func (tx *Tx) flushPage(p *common.Page) error {
+	if err := tx.writePage(p); err != nil {
+		return err
+	}
+	delete(tx.pages, p.Id())
+	tx.db.writes.record(tx.meta.Txid(), common.Pages{p})
+	tx.db.releasePageBuffer(p)
 	return nil
 }
 
@@ -499,39 +839,113 @@ func (tx *Tx) writeMeta() error {
 	// Create a temporary buffer for the meta page.
 	buf := make([]byte, tx.db.pageSize)
 	p := tx.db.pageInBuffer(buf, 0)
+	if tx.db.reservedNames {
+		tx.meta.SetFlags(tx.meta.Flags() | common.MetaReservedNamesFlag)
+	}
+	var prev *common.Meta
+	if tx.meta.AddFeatures(tx.features) {
+		prev = &common.Meta{}
+		tx.db.meta().Copy(prev)
+		prev.AddFeatures(tx.features)
+	}
 	tx.meta.Write(p)
+	if err := tx.writeMetaPage(buf, p); err != nil {
+		return err
+	}
+
+	// The first transaction using a feature also raises the format version of
+	// the previous meta page, which older versions of bbolt would otherwise
+	// fall back to.
+	if prev != nil {
+		p = tx.db.pageInBuffer(buf, 0)
+		prev.Write(p)
+		if err := tx.writeMetaPage(buf, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-	// Write the meta page to file.
+// writeMetaPage writes a meta page held in buf to disk.
+func (tx *Tx) writeMetaPage(buf []byte, p *common.Page) error {
 	if _, err := tx.db.ops.writeAt(buf, int64(p.Id())*int64(tx.db.pageSize)); err != nil {
 		return err
 	}
 	if !tx.db.NoSync || common.IgnoreNoSync {
-		if err := fdatasync(tx.db); err != nil {
+		if err := tx.db.storage.Sync(); err != nil {
 			return err
 		}
 	}
 
 	// Update statistics.
 	tx.stats.IncWrite(1)
+	tx.stats.IncWriteCalls(1)
 
 	return nil
 }
 
+// useLeafFeatures records the features used by a leaf element with the given
+// flags, which the transaction writes.
+func (tx *Tx) useLeafFeatures(flags uint32) {
+	if flags&(common.BucketExtLeafFlag|common.CompressedLeafFlag) != 0 {
+		tx.features |= common.MetaBucketExtFlag
+	}
+	if flags&common.ExpiringLeafFlag != 0 {
+		tx.features |= common.MetaExpiryFlag
+	}
+}
+
 // page returns a reference to the page with a given id.
 // If page has been written to then a temporary buffered page is returned.
+//
+// It panics with a CorruptionError if the page fails its checksum or can not be
+// decrypted, which the managed transactions turn into an error.
 func (tx *Tx) page(id common.Pgid) *common.Page {
+	p, err := tx.checkedPage(id)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// checkedPage is like page, but returns an error instead of panicking when the
+// page is corrupted.
+func (tx *Tx) checkedPage(id common.Pgid) (*common.Page, error) {
 	// Check the dirty pages first.
 	if tx.pages != nil {
 		if p, ok := tx.pages[id]; ok {
 			p.FastCheck(id)
-			return p
+			return p, nil
+		}
+	}
+
+	// Pages of encrypted databases are decrypted into the page cache.
+	if tx.db.cipher != nil && id > 1 {
+		p, err := tx.decryptedPage(id)
+		if err != nil {
+			return nil, err
 		}
+		p.FastCheck(id)
+		return p, nil
 	}
 
 	// Otherwise return directly from the mmap.
-	p := tx.db.page(id)
+	m := tx.view()
+	if err := tx.db.checkPage(m, id); err != nil {
+		return nil, err
+	}
+	p := m.page(id, tx.db.pageSize)
 	p.FastCheck(id)
-	return p
+	return p, nil
+}
+
+// view returns the mapping the transaction reads pages from.
+func (tx *Tx) view() *mapping {
+	if tx.mapping != nil {
+		return tx.mapping
+	}
+	return tx.db.mapping
 }
 
 // forEachPage iterates over every page within a given page and executes a function.
@@ -570,7 +984,7 @@ func (tx *Tx) Page(id int) (*common.PageInfo, error) {
 	}
 
 	// Build the page info.
-	p := tx.db.page(common.Pgid(id))
+	p := tx.view().page(common.Pgid(id), tx.db.pageSize)
 	info := &common.PageInfo{
 		ID:            id,
 		Count:         int(p.Count()),
@@ -630,6 +1044,8 @@ type TxStats struct {
 	Write int64 // number of writes performed
 	// DEPRECATED: Use GetWriteTime() or IncWriteTime()
 	WriteTime time.Duration // total time spent writing to disk
+	// DEPRECATED: Use GetWriteCalls() or IncWriteCalls()
+	WriteCalls int64 // number of write system calls
 }
 
 func (s *TxStats) add(other *TxStats) {
@@ -645,6 +1061,7 @@ func (s *TxStats) add(other *TxStats) {
 	s.IncSpillTime(other.GetSpillTime())
 	s.IncWrite(other.GetWrite())
 	s.IncWriteTime(other.GetWriteTime())
+	s.IncWriteCalls(other.GetWriteCalls())
 }
 
 // Sub calculates and returns the difference between two sets of transaction stats.
@@ -664,6 +1081,7 @@ func (s *TxStats) Sub(other *TxStats) TxStats {
 	diff.SpillTime = s.GetSpillTime() - other.GetSpillTime()
 	diff.Write = s.GetWrite() - other.GetWrite()
 	diff.WriteTime = s.GetWriteTime() - other.GetWriteTime()
+	diff.WriteCalls = s.GetWriteCalls() - other.GetWriteCalls()
 	return diff
 }
 
@@ -787,6 +1205,16 @@ func (s *TxStats) IncWriteTime(delta time.Duration) time.Duration {
 	return atomicAddDuration(&s.WriteTime, delta)
 }
 
+// GetWriteCalls returns WriteCalls atomically.
+func (s *TxStats) GetWriteCalls() int64 {
+	return atomic.LoadInt64(&s.WriteCalls)
+}
+
+// IncWriteCalls increases WriteCalls atomically and returns the new value.
+func (s *TxStats) IncWriteCalls(delta int64) int64 {
+	return atomic.AddInt64(&s.WriteCalls, delta)
+}
+
 func atomicAddDuration(ptr *time.Duration, du time.Duration) time.Duration {
 	return time.Duration(atomic.AddInt64((*int64)(unsafe.Pointer(ptr)), int64(du)))
 }
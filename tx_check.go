@@ -3,6 +3,7 @@ package bbolt
 import (
 	"encoding/hex"
 	"fmt"
+	"sort"
 
 	"go.etcd.io/bbolt/internal/common"
 )
@@ -32,6 +33,13 @@ func (tx *Tx) Check(options ...CheckOption) <-chan error {
 }
 
 func (tx *Tx) check(kvStringer KVStringer, ch chan error) {
+	// Verify the page checksums first, since the other checks can not read
+	// corrupted pages.
+	if !tx.checkPageChecksums(ch) {
+		close(ch)
+		return
+	}
+
 	// Force loading free list if opened in ReadOnly mode.
 	tx.db.loadFreelist()
 
@@ -59,6 +67,14 @@ func (tx *Tx) check(kvStringer KVStringer, ch chan error) {
 	// Recursively check buckets.
 	tx.checkBucket(&tx.root, reachable, freed, kvStringer, ch)
 
+	// Internal buckets are hidden from the buckets of the root.
+	c := tx.root.Cursor()
+	for k, _, flags := c.seek(reservedPrefix); k != nil && tx.isReservedName(k); k, _, flags = c.next() {
+		if flags&common.BucketLeafFlag != 0 {
+			tx.checkBucket(tx.root.Bucket(k), reachable, freed, kvStringer, ch)
+		}
+	}
+
 	// Ensure all pages below high water mark are either reachable or freed.
 	for i := common.Pgid(0); i < tx.meta.Pgid(); i++ {
 		_, isReachable := reachable[i]
@@ -67,10 +83,59 @@ func (tx *Tx) check(kvStringer KVStringer, ch chan error) {
 		}
 	}
 
+	// Verify the declared indexes against the buckets they index.
+	for _, def := range tx.indexes {
+		tx.checkIndex(def, kvStringer, ch)
+	}
+
 	// Close the channel to signal completion.
 	close(ch)
 }
 
+// checkPageChecksums verifies the checksums of the freelist page and of every
+// page reachable from the root bucket, and reports whether they are all valid.
+// The children of corrupted pages are not visited.
+func (tx *Tx) checkPageChecksums(ch chan error) bool {
+	if !tx.db.pageChecksums {
+		return true
+	}
+
+	valid := true
+	if tx.meta.Freelist() != common.PgidNoFreelist {
+		if _, err := tx.checkedPage(tx.meta.Freelist()); err != nil {
+			ch <- err
+			valid = false
+		}
+	}
+
+	var walk func(id common.Pgid)
+	walk = func(id common.Pgid) {
+		p, err := tx.checkedPage(id)
+		if err != nil {
+			ch <- err
+			valid = false
+			return
+		}
+		switch {
+		case p.IsBranchPage():
+			for i := range p.BranchPageElements() {
+				walk(p.BranchPageElement(uint16(i)).Pgid())
+			}
+		case p.IsLeafPage():
+			for i := range p.LeafPageElements() {
+				// Inline buckets do not have pages of their own.
+				if b := p.LeafPageElement(uint16(i)).Bucket(); b != nil && b.RootPage() != 0 {
+					walk(b.RootPage())
+				}
+			}
+		}
+	}
+	if tx.root.RootPage() != 0 {
+		walk(tx.root.RootPage())
+	}
+	return valid
+}
+
 func (tx *Tx) checkBucket(b *Bucket, reachable map[common.Pgid]*common.Page, freed map[common.Pgid]bool,
 	kvStringer KVStringer, ch chan error) {
 	// Ignore inline buckets.
@@ -101,23 +166,119 @@ func (tx *Tx) checkBucket(b *Bucket, reachable map[common.Pgid]*common.Page, fre
 		}
 	})
 
-	tx.recursivelyCheckPages(b.RootPage(), kvStringer.KeyToString, ch)
+	tx.recursivelyCheckPages(b.RootPage(), b.compare, kvStringer.KeyToString, ch)
 
 	// Check each bucket within this bucket.
 	_ = b.ForEachBucket(func(k []byte) error {
-		if child := b.Bucket(k); child != nil {
+		child, err := b.bucket(k)
+		if err != nil {
+			// The keys of a bucket whose codec or comparator is not
+			// registered can not be checked, but its pages are in use.
+			ch <- fmt.Errorf("bucket %s: %w", kvStringer.KeyToString(k), err)
+			_, v, flags := b.Cursor().seek(k)
+			if child, err := b.openBucket(v, flags); err == nil {
+				tx.markReachable(child.RootPage(), reachable)
+			}
+			return nil
+		}
+		if child != nil {
+			tx.checkBucketCounters(k, child, kvStringer, ch)
 			tx.checkBucket(child, reachable, freed, kvStringer, ch)
 		}
 		return nil
 	})
 }
 
+// markReachable marks the pages of a bucket which can not be opened, and of
+// the buckets nested in it, as reachable without checking them. Nested
+// buckets are found from the pages, as the keys can not be searched.
+func (tx *Tx) markReachable(root common.Pgid, reachable map[common.Pgid]*common.Page) {
+	// Inline buckets do not have pages of their own.
+	if root == 0 {
+		return
+	}
+	tx.forEachPage(root, func(p *common.Page, _ int, _ []common.Pgid) {
+		for i := common.Pgid(0); i <= common.Pgid(p.Overflow()); i++ {
+			reachable[p.Id()+i] = p
+		}
+		if p.IsLeafPage() {
+			for i := uint16(0); i < p.Count(); i++ {
+				if b := p.LeafPageElement(i).Bucket(); b != nil {
+					tx.markReachable(b.RootPage(), reachable)
+				}
+			}
+		}
+	})
+}
+
+// checkBucketCounters verifies the key count and value size kept by a counted
+// bucket against the ones found by reading the whole bucket.
+func (tx *Tx) checkBucketCounters(name []byte, b *Bucket, kvStringer KVStringer, ch chan error) {
+	if !b.ext.Counted {
+		return
+	}
+	keyN, valueSize := b.countKeys()
+	if keyN != int(b.ext.KeyN) {
+		ch <- fmt.Errorf("bucket %s: key count %d does not match %d keys", kvStringer.KeyToString(name), b.ext.KeyN, keyN)
+	}
+	if valueSize != int64(b.ext.ValueBytes) {
+		ch <- fmt.Errorf("bucket %s: value size %d does not match %d bytes", kvStringer.KeyToString(name), b.ext.ValueBytes, valueSize)
+	}
+}
+
+// checkIndex verifies the entries of a declared index against the ones of the
+// keys in the indexed bucket.
+func (tx *Tx) checkIndex(def *indexDef, kvStringer KVStringer, ch chan error) {
+	type entry struct{ ikey, key string }
+	expected := make(map[entry]bool)
+	if b, err := tx.bucketAt(def.path); err == nil {
+		c := b.Cursor()
+		for k, v, flags := c.first(); k != nil; k, v, flags = c.next() {
+			if flags&common.BucketLeafFlag != 0 {
+				continue
+			}
+			for _, ikey := range def.fn(k, c.value(v, flags)) {
+				if len(ikey) > 0 {
+					expected[entry{string(ikey), string(k)}] = true
+				}
+			}
+		}
+	}
+
+	if index := tx.root.Bucket(def.bucket); index != nil {
+		_ = index.ForEachBucket(func(ikey []byte) error {
+			return index.Bucket(ikey).ForEach(func(k, _ []byte) error {
+				e := entry{string(ikey), string(k)}
+				if !expected[e] {
+					ch <- fmt.Errorf("index %s: unexpected key %s for index key %s", def.name, kvStringer.KeyToString(k), kvStringer.KeyToString(ikey))
+				}
+				delete(expected, e)
+				return nil
+			})
+		})
+	}
+
+	missing := make([]entry, 0, len(expected))
+	for e := range expected {
+		missing = append(missing, e)
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		if missing[i].ikey != missing[j].ikey {
+			return missing[i].ikey < missing[j].ikey
+		}
+		return missing[i].key < missing[j].key
+	})
+	for _, e := range missing {
+		ch <- fmt.Errorf("index %s: missing key %s for index key %s", def.name, kvStringer.KeyToString([]byte(e.key)), kvStringer.KeyToString([]byte(e.ikey)))
+	}
+}
+
 // recursivelyCheckPages confirms database consistency with respect to b-tree
-// key order constraints:
+// key order constraints, with keys ordered by compare:
 //   - keys on pages must be sorted
 //   - keys on children pages are between 2 consecutive keys on the parent's branch page).
-func (tx *Tx) recursivelyCheckPages(pgId common.Pgid, keyToString func([]byte) string, ch chan error) {
-	tx.recursivelyCheckPagesInternal(pgId, nil, nil, nil, keyToString, ch)
+func (tx *Tx) recursivelyCheckPages(pgId common.Pgid, compare func(a, b []byte) int, keyToString func([]byte) string, ch chan error) {
+	tx.recursivelyCheckPagesInternal(pgId, nil, nil, nil, compare, keyToString, ch)
 }
 
 // recursivelyCheckPagesInternal verifies that all keys in the subtree rooted at `pgid` are:
@@ -127,7 +288,7 @@ func (tx *Tx) recursivelyCheckPages(pgId common.Pgid, keyToString func([]byte) s
 //     `pagesStack` is expected to contain IDs of pages from the tree root to `pgid` for the clean debugging message.
 func (tx *Tx) recursivelyCheckPagesInternal(
 	pgId common.Pgid, minKeyClosed, maxKeyOpen []byte, pagesStack []common.Pgid,
-	keyToString func([]byte) string, ch chan error) (maxKeyInSubtree []byte) {
+	compare func(a, b []byte) int, keyToString func([]byte) string, ch chan error) (maxKeyInSubtree []byte) {
 
 	p := tx.page(pgId)
 	pagesStack = append(pagesStack, pgId)
@@ -137,13 +298,13 @@ func (tx *Tx) recursivelyCheckPagesInternal(
 		runningMin := minKeyClosed
 		for i := range p.BranchPageElements() {
 			elem := p.BranchPageElement(uint16(i))
-			verifyKeyOrder(elem.Pgid(), "branch", i, elem.Key(), runningMin, maxKeyOpen, ch, keyToString, pagesStack)
+			verifyKeyOrder(elem.Pgid(), "branch", i, elem.Key(), runningMin, maxKeyOpen, ch, compare, keyToString, pagesStack)
 
 			maxKey := maxKeyOpen
 			if i < len(p.BranchPageElements())-1 {
 				maxKey = p.BranchPageElement(uint16(i + 1)).Key()
 			}
-			maxKeyInSubtree = tx.recursivelyCheckPagesInternal(elem.Pgid(), elem.Key(), maxKey, pagesStack, keyToString, ch)
+			maxKeyInSubtree = tx.recursivelyCheckPagesInternal(elem.Pgid(), elem.Key(), maxKey, pagesStack, compare, keyToString, ch)
 			runningMin = maxKeyInSubtree
 		}
 		return maxKeyInSubtree
@@ -151,7 +312,7 @@ func (tx *Tx) recursivelyCheckPagesInternal(
 		runningMin := minKeyClosed
 		for i := range p.LeafPageElements() {
 			elem := p.LeafPageElement(uint16(i))
-			verifyKeyOrder(pgId, "leaf", i, elem.Key(), runningMin, maxKeyOpen, ch, keyToString, pagesStack)
+			verifyKeyOrder(pgId, "leaf", i, elem.Key(), runningMin, maxKeyOpen, ch, compare, keyToString, pagesStack)
 			runningMin = elem.Key()
 		}
 		if p.Count() > 0 {
@@ -167,13 +328,13 @@ func (tx *Tx) recursivelyCheckPagesInternal(
  * verifyKeyOrder checks whether an entry with given #index on pgId (pageType: "branch|leaf") that has given "key",
  * is within range determined by (previousKey..maxKeyOpen) and reports found violations to the channel (ch).
  */
-func verifyKeyOrder(pgId common.Pgid, pageType string, index int, key []byte, previousKey []byte, maxKeyOpen []byte, ch chan error, keyToString func([]byte) string, pagesStack []common.Pgid) {
-	if index == 0 && previousKey != nil && compareKeys(previousKey, key) > 0 {
+func verifyKeyOrder(pgId common.Pgid, pageType string, index int, key []byte, previousKey []byte, maxKeyOpen []byte, ch chan error, compare func(a, b []byte) int, keyToString func([]byte) string, pagesStack []common.Pgid) {
+	if index == 0 && previousKey != nil && compare(previousKey, key) > 0 {
 		ch <- fmt.Errorf("the first key[%d]=(hex)%s on %s page(%d) needs to be >= the key in the ancestor (%s). Stack: %v",
 			index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
 	}
 	if index > 0 {
-		cmpRet := compareKeys(previousKey, key)
+		cmpRet := compare(previousKey, key)
 		if cmpRet > 0 {
 			ch <- fmt.Errorf("key[%d]=(hex)%s on %s page(%d) needs to be > (found <) than previous element (hex)%s. Stack: %v",
 				index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
@@ -183,7 +344,7 @@ func verifyKeyOrder(pgId common.Pgid, pageType string, index int, key []byte, pr
 				index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
 		}
 	}
-	if maxKeyOpen != nil && compareKeys(key, maxKeyOpen) >= 0 {
+	if maxKeyOpen != nil && compare(key, maxKeyOpen) >= 0 {
 		ch <- fmt.Errorf("key[%d]=(hex)%s on %s page(%d) needs to be < than key of the next element in ancestor (hex)%s. Pages stack: %v",
 			index, keyToString(key), pageType, pgId, keyToString(previousKey), pagesStack)
 	}
@@ -0,0 +1,100 @@
+package bbolt
+
+import (
+	"sort"
+	"time"
+)
+
+// TxInfo describes an open read transaction.
+type TxInfo struct {
+	// ID is the id of the transaction, which is the id of the last write
+	// transaction committed when it began.
+	ID int
+
+	// Start is the time the transaction began.
+	Start time.Time
+
+	// Label is the label set with Tx.SetLabel, if any.
+	Label string
+
+	// Stack is the stack of the goroutine which began the transaction, if
+	// the database was opened with Options.TxStacks.
+	Stack string
+
+	// PinnedPages is the number of pages freed by later write transactions
+	// which can not be reused until the transaction closes.
+	PinnedPages int
+}
+
+// SetLabel sets a label which identifies the transaction in DB.OpenTxs and
+// in the reports of Options.MaxReadTxAge, such as the name of the caller.
+func (tx *Tx) SetLabel(label string) {
+	db := tx.db
+	if db == nil {
+		return
+	}
+	db.metalock.Lock()
+	tx.label = label
+	db.metalock.Unlock()
+}
+
+// OpenTxs returns the open read transactions of the database, oldest first.
+//
+// It waits for the open write transaction, if any, to close before it counts
+// the pinned pages, so it must not be called from a write transaction.
+func (db *DB) OpenTxs() []TxInfo {
+	db.rwlock.Lock()
+	defer db.rwlock.Unlock()
+	db.metalock.Lock()
+	defer db.metalock.Unlock()
+
+	infos := make([]TxInfo, 0, len(db.txs))
+	for _, t := range db.txs {
+		infos = append(infos, db.txInfo(t))
+	}
+	sort.SliceStable(infos, func(i, j int) bool { return infos[i].Start.Before(infos[j].Start) })
+	return infos
+}
+
+// txInfo returns the description of a read transaction. It must be called
+// with the writer and meta locks held.
+func (db *DB) txInfo(t *Tx) TxInfo {
+	info := TxInfo{
+		ID:    t.ID(),
+		Start: t.start,
+		Label: t.label,
+		Stack: string(t.stack),
+	}
+	if db.freelist != nil {
+		info.PinnedPages = db.freelist.pinned(t.meta.Txid())
+	}
+	return info
+}
+
+// reportLongReadTxs reports the read transactions which have been open for
+// longer than MaxReadTxAge, once each. It must be called with the writer and
+// meta locks held.
+func (db *DB) reportLongReadTxs() {
+	if db.maxReadTxAge <= 0 {
+		return
+	}
+	now := time.Now()
+	for _, t := range db.txs {
+		age := now.Sub(t.start)
+		if t.reported || age < db.maxReadTxAge {
+			continue
+		}
+		t.reported = true
+
+		info := db.txInfo(t)
+		db.hook.OnEvent(Event{Type: EventLongReadTx, Txid: info.ID, Duration: age, Size: info.PinnedPages})
+		keyvals := []interface{}{"txid", info.ID, "age", age, "pinnedPages", info.PinnedPages}
+		if info.Label != "" {
+			keyvals = append(keyvals, "label", info.Label)
+		}
+		if info.Stack != "" {
+			keyvals = append(keyvals, "stack", info.Stack)
+		}
+		db.logger.Warn("read transaction open too long", keyvals...)
+	}
+}
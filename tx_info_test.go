@@ -0,0 +1,72 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Ensure that the open read transactions are described with the pages they
+// pin, and reported once they are older than MaxReadTxAge.
+func TestDB_OpenTxs(t *testing.T) {
+	var events []bolt.Event
+	logger := &recordingLogger{}
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "db"), 0600, &bolt.Options{
+		Logger: logger,
+		EventHook: bolt.EventHookFunc(func(e bolt.Event) {
+			if e.Type == bolt.EventLongReadTx {
+				events = append(events, e)
+			}
+		}),
+		MaxReadTxAge: time.Millisecond,
+		TxStacks:     true,
+	})
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%03d", i)), make([]byte, 500)))
+		}
+		return nil
+	}))
+
+	start := time.Now()
+	rtx, err := db.Begin(false)
+	require.NoError(t, err)
+	rtx.SetLabel("reader")
+
+	// Deleting the keys frees pages which the reader can still see.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("widgets"))
+	}))
+
+	txs := db.OpenTxs()
+	require.Len(t, txs, 1)
+	require.Equal(t, rtx.ID(), txs[0].ID)
+	require.Equal(t, "reader", txs[0].Label)
+	require.False(t, txs[0].Start.Before(start))
+	require.Contains(t, txs[0].Stack, "TestDB_OpenTxs")
+	require.Greater(t, txs[0].PinnedPages, 10)
+
+	// The reader is reported by the next write transactions, once.
+	time.Sleep(2 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		require.NoError(t, db.Update(func(tx *bolt.Tx) error { return nil }))
+	}
+	require.Len(t, events, 1)
+	require.Equal(t, rtx.ID(), events[0].Txid)
+	require.Equal(t, txs[0].PinnedPages, events[0].Size)
+	require.GreaterOrEqual(t, events[0].Duration, time.Millisecond)
+	require.Contains(t, logger.msgs, "warn: read transaction open too long")
+
+	require.NoError(t, rtx.Rollback())
+	require.Empty(t, db.OpenTxs())
+}
@@ -21,6 +21,7 @@ func TestTxStats_add(t *testing.T) {
 		SpillTime:     10001 * time.Second,
 		Write:         100000,
 		WriteTime:     100001 * time.Second,
+		WriteCalls:    1000,
 	}
 
 	statsB := TxStats{
@@ -36,6 +37,7 @@ func TestTxStats_add(t *testing.T) {
 		SpillTime:     11002 * time.Second,
 		Write:         110001,
 		WriteTime:     110010 * time.Second,
+		WriteCalls:    1100,
 	}
 
 	statsB.add(&statsA)
@@ -51,4 +53,5 @@ func TestTxStats_add(t *testing.T) {
 	assert.Equal(t, 21003*time.Second, statsB.GetSpillTime())
 	assert.Equal(t, int64(210001), statsB.GetWrite())
 	assert.Equal(t, 210011*time.Second, statsB.GetWriteTime())
+	assert.Equal(t, int64(2100), statsB.GetWriteCalls())
 }
@@ -433,6 +433,124 @@ func TestTx_DeleteBucket_NotFound(t *testing.T) {
 	}
 }
 
+// Ensure that inline and non-inline buckets can be moved and renamed along
+// with their contents and sequence.
+func TestTx_MoveBucket(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	path := func(names ...string) [][]byte {
+		var p [][]byte
+		for _, name := range names {
+			p = append(p, []byte(name))
+		}
+		return p
+	}
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		src, err := tx.CreateBucket([]byte("src"))
+		require.NoError(t, err)
+		_, err = tx.CreateBucket([]byte("dst"))
+		require.NoError(t, err)
+
+		small, err := src.CreateBucket([]byte("small"))
+		require.NoError(t, err)
+		require.NoError(t, small.Put([]byte("foo"), []byte("bar")))
+		require.NoError(t, small.SetSequence(7))
+
+		large, err := src.CreateBucket([]byte("large"))
+		require.NoError(t, err)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, large.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)))
+		}
+		_, err = large.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		return large.SetSequence(9)
+	}))
+
+	// Move buckets which are not opened in the transaction.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.MoveBucket(path("src", "small"), path("dst"), []byte("moved")))
+		require.NoError(t, tx.MoveBucket(path("src", "large"), nil, []byte("large")))
+		require.Nil(t, tx.Bucket([]byte("src")).Bucket([]byte("small")))
+		require.Equal(t, []byte("bar"), tx.Bucket([]byte("dst")).Bucket([]byte("moved")).Get([]byte("foo")))
+		return nil
+	}))
+	db.MustCheck()
+
+	// Move buckets which are modified in the transaction.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		small := tx.Bucket([]byte("dst")).Bucket([]byte("moved"))
+		require.NoError(t, small.Put([]byte("baz"), []byte("bat")))
+		require.NoError(t, tx.MoveBucket(path("dst", "moved"), path("dst"), []byte("renamed")))
+		require.NoError(t, small.Put([]byte("qux"), []byte("quux")))
+
+		large := tx.Bucket([]byte("large"))
+		require.NoError(t, large.Delete([]byte("0000")))
+		return tx.MoveBucket(path("large"), path("dst", "renamed"), []byte("large"))
+	}))
+	db.MustCheck()
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Nil(t, tx.Bucket([]byte("large")))
+		dst := tx.Bucket([]byte("dst"))
+		require.Nil(t, dst.Bucket([]byte("moved")))
+
+		small := dst.Bucket([]byte("renamed"))
+		require.Equal(t, uint64(7), small.Sequence())
+		require.Equal(t, []byte("bar"), small.Get([]byte("foo")))
+		require.Equal(t, []byte("bat"), small.Get([]byte("baz")))
+		require.Equal(t, []byte("quux"), small.Get([]byte("qux")))
+
+		large := small.Bucket([]byte("large"))
+		require.Equal(t, uint64(9), large.Sequence())
+		require.Equal(t, 1000, large.Stats().KeyN)
+		require.Nil(t, large.Get([]byte("0000")))
+		require.NotNil(t, large.Bucket([]byte("nested")))
+		return nil
+	}))
+}
+
+// Ensure that moving a bucket returns an error for missing buckets, taken
+// names and moves into the bucket itself.
+func TestTx_MoveBucket_Errors(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		_, err = b.CreateBucket([]byte("nested"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), []byte("bar")))
+		_, err = tx.CreateBucket([]byte("gadgets"))
+		return err
+	}))
+
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		widgets := [][]byte{[]byte("widgets")}
+		nested := [][]byte{[]byte("widgets"), []byte("nested")}
+
+		require.Equal(t, common.ErrBucketNotFound, tx.MoveBucket([][]byte{[]byte("missing")}, nil, []byte("x")))
+		require.Equal(t, common.ErrBucketNotFound, tx.MoveBucket(widgets, [][]byte{[]byte("missing")}, []byte("x")))
+		require.Equal(t, common.ErrIncompatibleValue, tx.MoveBucket([][]byte{[]byte("widgets"), []byte("foo")}, nil, []byte("x")))
+		require.Equal(t, common.ErrBucketNameRequired, tx.MoveBucket(nil, nil, []byte("x")))
+		require.Equal(t, common.ErrBucketNameRequired, tx.MoveBucket(widgets, nil, nil))
+		require.Equal(t, common.ErrBucketExists, tx.MoveBucket(widgets, nil, []byte("gadgets")))
+		require.Equal(t, common.ErrIncompatibleValue, tx.MoveBucket(nested, widgets, []byte("foo")))
+		require.Equal(t, common.ErrInvalidMove, tx.MoveBucket(widgets, widgets, []byte("x")))
+		require.Equal(t, common.ErrInvalidMove, tx.MoveBucket(widgets, nested, []byte("x")))
+
+		// Moving a bucket onto itself does nothing.
+		require.NoError(t, tx.MoveBucket(nested, widgets, []byte("nested")))
+		return nil
+	}))
+
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, common.ErrTxNotWritable, tx.MoveBucket([][]byte{[]byte("widgets")}, nil, []byte("x")))
+		return nil
+	}))
+	db.MustCheck()
+}
+
 // Ensure that no error is returned when a tx.ForEach function does not return
 // an error.
 func TestTx_ForEach_NoError(t *testing.T) {
@@ -948,6 +1066,9 @@ func TestTxStats_GetAndIncAtomically(t *testing.T) {
 	stats.IncWriteTime(100001 * time.Second)
 	assert.Equal(t, 100001*time.Second, stats.GetWriteTime())
 
+	stats.IncWriteCalls(1000)
+	assert.Equal(t, int64(1000), stats.GetWriteCalls())
+
 	assert.Equal(t,
 		bolt.TxStats{
 			PageCount:     1,
@@ -962,6 +1083,7 @@ func TestTxStats_GetAndIncAtomically(t *testing.T) {
 			SpillTime:     10001 * time.Second,
 			Write:         100000,
 			WriteTime:     100001 * time.Second,
+			WriteCalls:    1000,
 		},
 		stats,
 	)
@@ -981,6 +1103,7 @@ func TestTxStats_Sub(t *testing.T) {
 		SpillTime:     10001 * time.Second,
 		Write:         100000,
 		WriteTime:     100001 * time.Second,
+		WriteCalls:    1000,
 	}
 
 	statsB := bolt.TxStats{
@@ -996,6 +1119,7 @@ func TestTxStats_Sub(t *testing.T) {
 		SpillTime:     11002 * time.Second,
 		Write:         110001,
 		WriteTime:     110010 * time.Second,
+		WriteCalls:    1100,
 	}
 
 	diff := statsB.Sub(&statsA)
@@ -1011,6 +1135,41 @@ func TestTxStats_Sub(t *testing.T) {
 	assert.Equal(t, 1001*time.Second, diff.GetSpillTime())
 	assert.Equal(t, int64(10001), diff.GetWrite())
 	assert.Equal(t, 10009*time.Second, diff.GetWriteTime())
+	assert.Equal(t, int64(100), diff.GetWriteCalls())
+}
+
+// Ensure that a commit writes runs of contiguous dirty pages with a single
+// system call where vectored writes are supported.
+func TestTx_Commit_CoalescedWrites(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+
+	before := db.Stats()
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 10000; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%05d", i)), make([]byte, 100)))
+		}
+		return nil
+	}))
+	after := db.Stats()
+	diff := after.Sub(&before)
+
+	require.Greater(t, diff.TxStats.GetWrite(), int64(100))
+	if runtime.GOOS == "linux" {
+		// The data pages and the meta page.
+		require.Less(t, diff.TxStats.GetWriteCalls(), int64(10))
+	} else {
+		require.Equal(t, diff.TxStats.GetWrite(), diff.TxStats.GetWriteCalls())
+	}
+
+	db.MustClose()
+	db.MustReopen()
+	db.MustCheck()
+	require.NoError(t, db.View(func(tx *bolt.Tx) error {
+		require.Equal(t, 10000, tx.Bucket([]byte("widgets")).Stats().KeyN)
+		return nil
+	}))
 }
 
 // TestTx_TruncateBeforeWrite ensures the file is truncated ahead whether we sync freelist or not.
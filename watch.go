@@ -0,0 +1,229 @@
+package bbolt
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"go.etcd.io/bbolt/internal/common"
+)
+
+// watchQueueSize is the number of events a watcher holds for its receiver
+// before it drops them and sends a resync event instead.
+const watchQueueSize = 1024
+
+// WatchEvent is a change of a key in a watched bucket, or a signal that the
+// receiver must read the bucket again.
+type WatchEvent struct {
+	// Txid is the id of the transaction which made the change.
+	Txid int
+
+	// Key is the key which changed. It is nil for resync events.
+	Key []byte
+
+	// Existed and Exists report whether the key existed before and after
+	// the change. Keys holding nested buckets exist like other keys.
+	Existed bool
+	Exists  bool
+
+	// Resync is set when the changes made to the bucket can not be described
+	// key by key: because the receiver fell behind and events were dropped,
+	// or because the bucket was changed as a whole, for example deleted,
+	// moved, bulk loaded or cleared with DeleteRange. The receiver must read
+	// the bucket again to find its state as of Txid.
+	Resync bool
+}
+
+// watcher delivers the events of the transactions committed since it was
+// added to the channel returned by DB.Watch.
+type watcher struct {
+	path   [][]byte
+	prefix []byte
+	ch     chan WatchEvent
+	wake   chan struct{}
+	stop   chan struct{}
+
+	mu       sync.Mutex
+	queue    []WatchEvent
+	overflow int // txid of the last dropped event, or 0
+}
+
+// watchRecord is a change recorded by a write transaction for the watchers.
+type watchRecord struct {
+	path    [][]byte
+	key     []byte
+	existed bool
+	exists  bool
+
+	// resync is set for changes to the bucket at path, and the buckets
+	// nested in it, as a whole.
+	resync bool
+}
+
+// Watch returns a channel receiving the changes made by committed write
+// transactions to the keys starting with prefix of the bucket at path. A nil
+// prefix watches every key of the bucket. The bucket does not need to exist.
+//
+// Events are delivered after the transaction commits, in transaction id order
+// and in the order the changes were made. The channel is closed once ctx is
+// done or the database is closed. Up to 1024 events are held for a receiver
+// which falls behind; after that, they are dropped and replaced by a single
+// event with Resync set. Keys which expire are reported when they are deleted
+// by a sweep. The keys of events must not be modified.
+// Returns an error if path is empty or the database is not open.
+func (db *DB) Watch(ctx context.Context, path [][]byte, prefix []byte) (<-chan WatchEvent, error) {
+	if len(path) == 0 {
+		return nil, common.ErrBucketNameRequired
+	} else if db.isReservedName(path[0]) {
+		return nil, common.ErrBucketNameReserved
+	}
+
+	w := &watcher{
+		prefix: cloneBytes(prefix),
+		ch:     make(chan WatchEvent),
+		wake:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+	for _, name := range path {
+		w.path = append(w.path, cloneBytes(name))
+	}
+
+	db.watchMu.Lock()
+	if db.watchers == nil {
+		db.watchMu.Unlock()
+		return nil, common.ErrDatabaseNotOpen
+	}
+	db.watchers[w] = struct{}{}
+	db.watchMu.Unlock()
+
+	go func() {
+		defer close(w.ch)
+		defer db.removeWatcher(w)
+		w.run(ctx)
+	}()
+	return w.ch, nil
+}
+
+// removeWatcher stops delivering events to a watcher.
+func (db *DB) removeWatcher(w *watcher) {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	delete(db.watchers, w)
+}
+
+// stopWatchers closes the channels of all watchers, and stops accepting new
+// ones.
+func (db *DB) stopWatchers() {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for w := range db.watchers {
+		close(w.stop)
+	}
+	db.watchers = nil
+}
+
+// watched reports whether any watchers are waiting for events.
+func (db *DB) watched() bool {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	return len(db.watchers) > 0
+}
+
+// notifyWatchers queues the events of a committed transaction for the
+// watchers they concern. It must be called before the writer lock is
+// released, so that events are queued in transaction id order.
+func (db *DB) notifyWatchers(txid int, records []watchRecord) {
+	db.watchMu.Lock()
+	defer db.watchMu.Unlock()
+	for w := range db.watchers {
+		var events []WatchEvent
+		for _, r := range records {
+			if e, ok := w.match(txid, r); ok {
+				events = append(events, e)
+			}
+		}
+		if len(events) > 0 {
+			w.push(events)
+		}
+	}
+}
+
+// match returns the event of a change for the watcher, if it concerns it.
+func (w *watcher) match(txid int, r watchRecord) (WatchEvent, bool) {
+	if r.resync {
+		if len(w.path) >= len(r.path) && pathHasPrefix(w.path, r.path) {
+			return WatchEvent{Txid: txid, Resync: true}, true
+		}
+		return WatchEvent{}, false
+	}
+	if len(w.path) != len(r.path) || !pathHasPrefix(w.path, r.path) || !bytes.HasPrefix(r.key, w.prefix) {
+		return WatchEvent{}, false
+	}
+	return WatchEvent{Txid: txid, Key: r.key, Existed: r.existed, Exists: r.exists}, true
+}
+
+// push queues events for the receiver, or drops the queue if it is full.
+func (w *watcher) push(events []WatchEvent) {
+	w.mu.Lock()
+	if len(w.queue)+len(events) > watchQueueSize {
+		w.queue = nil
+		w.overflow = events[len(events)-1].Txid
+	} else {
+		w.queue = append(w.queue, events...)
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends the queued events to the receiver until ctx is done or the
+// database is closed.
+func (w *watcher) run(ctx context.Context) {
+	for {
+		w.mu.Lock()
+		events := w.queue
+		if w.overflow != 0 {
+			events = append([]WatchEvent{{Txid: w.overflow, Resync: true}}, events...)
+		}
+		w.queue, w.overflow = nil, 0
+		w.mu.Unlock()
+
+		for _, e := range events {
+			select {
+			case w.ch <- e:
+			case <-ctx.Done():
+				return
+			case <-w.stop:
+				return
+			}
+		}
+
+		select {
+		case <-w.wake:
+		case <-ctx.Done():
+			return
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// watchKey records a change of a key of the bucket for the watchers.
+func (b *Bucket) watchKey(key []byte, existed, exists bool) {
+	if !b.tx.watching || (!existed && !exists) {
+		return
+	}
+	b.tx.watchLog = append(b.tx.watchLog, watchRecord{path: b.path(), key: cloneBytes(key), existed: existed, exists: exists})
+}
+
+// watchResync records a change of the bucket at path, and of the buckets
+// nested in it, as a whole for the watchers.
+func (tx *Tx) watchResync(path [][]byte) {
+	if !tx.watching {
+		return
+	}
+	tx.watchLog = append(tx.watchLog, watchRecord{path: path, resync: true})
+}
@@ -0,0 +1,105 @@
+package bbolt_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	bolt "go.etcd.io/bbolt"
+	"go.etcd.io/bbolt/internal/btesting"
+)
+
+// receive returns the next event of a watch channel.
+func receive(t *testing.T, ch <-chan bolt.WatchEvent) bolt.WatchEvent {
+	select {
+	case e, ok := <-ch:
+		require.True(t, ok, "channel closed")
+		return e
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return bolt.WatchEvent{}
+}
+
+// Ensure that watchers receive the changes of their bucket and prefix after
+// the transactions commit, in order.
+func TestDB_Watch(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, err := db.Watch(ctx, [][]byte{[]byte("widgets")}, []byte("foo"))
+	require.NoError(t, err)
+
+	var txid int
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		txid = tx.ID()
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foo"), []byte("1")))
+		require.NoError(t, b.Put([]byte("foo"), []byte("2")))
+		require.NoError(t, b.Put([]byte("bar"), []byte("1")))
+		require.NoError(t, b.Delete([]byte("foobar")))
+
+		// Changes which are rolled back are not delivered.
+		sp, err := tx.Savepoint()
+		require.NoError(t, err)
+		require.NoError(t, b.Put([]byte("foobaz"), []byte("1")))
+		return tx.RollbackTo(sp)
+	}))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Delete([]byte("foo"))
+	}))
+	require.Error(t, db.Update(func(tx *bolt.Tx) error {
+		require.NoError(t, tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("3")))
+		return fmt.Errorf("rollback")
+	}))
+
+	require.Equal(t, bolt.WatchEvent{Txid: txid, Key: []byte("foo"), Exists: true}, receive(t, ch))
+	require.Equal(t, bolt.WatchEvent{Txid: txid, Key: []byte("foo"), Existed: true, Exists: true}, receive(t, ch))
+	require.Equal(t, bolt.WatchEvent{Txid: txid + 1, Key: []byte("foo"), Existed: true}, receive(t, ch))
+
+	// Deleting the bucket asks the watcher to read it again.
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("widgets"))
+	}))
+	require.Equal(t, bolt.WatchEvent{Txid: txid + 2, Resync: true}, receive(t, ch))
+
+	// The channel is closed once the context is done.
+	cancel()
+	for range ch {
+	}
+}
+
+// Ensure that watchers which fall behind receive a resync event in place of
+// the events they missed.
+func TestDB_Watch_Overflow(t *testing.T) {
+	db := btesting.MustCreateDB(t)
+	ch, err := db.Watch(context.Background(), [][]byte{[]byte("widgets")}, nil)
+	require.NoError(t, err)
+
+	var txid int
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		require.NoError(t, err)
+		for i := 0; i < 2000; i++ {
+			require.NoError(t, b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value")))
+		}
+		return nil
+	}))
+	require.NoError(t, db.Update(func(tx *bolt.Tx) error {
+		txid = tx.ID()
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	}))
+
+	require.Equal(t, bolt.WatchEvent{Txid: txid - 1, Resync: true}, receive(t, ch))
+	require.Equal(t, bolt.WatchEvent{Txid: txid, Key: []byte("foo"), Exists: true}, receive(t, ch))
+
+	// The channel is closed when the database is closed.
+	db.MustClose()
+	for range ch {
+	}
+}